@@ -326,7 +326,7 @@ func (n *VisitNode) Blk() *ssa.BasicBlock {
 // Fn returns the function which the block belongs to.
 func (n *VisitNode) Fn() *ssa.Function {
 	if n.blk == nil {
-		log.Fatal(ErrBadNode)
+		panic(ErrBadNode)
 	}
 	return n.blk.Parent()
 }
@@ -334,7 +334,7 @@ func (n *VisitNode) Fn() *ssa.Function {
 // Index returns the block index.
 func (n *VisitNode) Index() int {
 	if n.blk == nil {
-		log.Fatalln(ErrBadNode)
+		panic(ErrBadNode)
 	}
 	return n.blk.Index
 }
@@ -49,10 +49,19 @@ var toplevel = &emptyCtx{s: store.New()}
 // Toplevel returns an empty context.
 //
 // It is used for representing a top-level context at entry points of analysis.
+// The returned context is shared, see NewToplevel for independent analyses.
 func Toplevel() Context {
 	return toplevel
 }
 
+// NewToplevel returns a new empty context with its own backing storage.
+//
+// It is used for representing a top-level context of an analysis independent
+// of other analyses (e.g. running concurrently).
+func NewToplevel() Context {
+	return &emptyCtx{s: store.New()}
+}
+
 // Updater is an interface for a context that has the ability to modify the
 // underlying storage which the instances point to.
 //
@@ -148,9 +157,16 @@ func Switch(parent Context, call *funcs.Instance) Context {
 				}
 			}
 		} else {
-			if param != nil {
+			if param != nil && argValue != nil {
 				c.Put(param, argValue)
 			}
+			if closure, ok := argValue.(*funcs.Definition); ok {
+				// Function value: bound variables of the closure are needed
+				// when the function value is called in the callee.
+				for _, binding := range closure.Bindings() {
+					c.Put(binding, parent.Get(binding))
+				}
+			}
 		}
 	}
 	return &c
@@ -7,3 +7,13 @@ func TestToplevelContext(t *testing.T) {
 		t.Errorf("toplevel context is not unique")
 	}
 }
+
+func TestNewToplevelContext(t *testing.T) {
+	ctx1, ctx2 := NewToplevel(), NewToplevel()
+	if ctx1 == ctx2 || ctx1.getStorage() == ctx2.getStorage() {
+		t.Errorf("new toplevel contexts share storage")
+	}
+	if ctx1 == Toplevel() {
+		t.Errorf("new toplevel context is the shared toplevel context")
+	}
+}
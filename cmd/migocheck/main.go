@@ -0,0 +1,236 @@
+// Command migocheck is the command line entry point to checking MiGo types by
+// bounded exploration of their state space.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/nickng/gospal/migocheck"
+	"github.com/nickng/gospal/migoinfer"
+	"github.com/nickng/gospal/ssa/build"
+	"github.com/nickng/migo"
+	"github.com/nickng/migo/parser"
+	"github.com/pkg/errors"
+)
+
+const (
+	Usage = `migocheck is a tool for checking MiGo types inferred from Go source code
+(or read from a MiGo file) for deadlocks, sends on closed channels, closes of
+closed channels and goroutine leaks.
+
+Usage:
+
+  migocheck [options] file.go [files.go...]
+  migocheck [options] file.migo
+
+With -spec, the MiGo is checked for conformance to a specification instead,
+i.e. every behaviour of the MiGo is a behaviour of the specification, and the
+first divergence is reported.
+
+The exit status is 1 if a problem (or a divergence) is found.
+
+Options:
+
+`
+)
+
+var (
+	entryFunc string
+	tests     bool
+	diagFmt   string
+	traceFmt  string
+	specFile  string
+	specEntry string
+	conf      = migocheck.DefaultConfig
+)
+
+func init() {
+	flag.StringVar(&entryFunc, "entry", "main.main", "Specify the entry MiGo definition to check")
+	flag.BoolVar(&tests, "tests", false, "Load test files and check the MiGo of every test function")
+	flag.StringVar(&specFile, "spec", "", "Specify MiGo file of the specification to check conformance to, instead of checking for problems")
+	flag.StringVar(&specEntry, "spec-entry", "", "Specify the entry definition of the specification (default the entry checked)")
+	flag.StringVar(&traceFmt, "trace", "text", "Specify format of result and traces of problems (text, json, mermaid or plantuml)")
+	flag.StringVar(&diagFmt, "diag", "text", "Specify format of diagnostics of problems written to stderr (text, json or none)")
+	flag.IntVar(&conf.MaxStates, "max-states", conf.MaxStates, "Specify max states explored (0 means no limit)")
+	flag.IntVar(&conf.MaxDepth, "max-depth", conf.MaxDepth, "Specify max steps of a trace (0 means no limit)")
+	flag.IntVar(&conf.MaxProcs, "max-procs", conf.MaxProcs, "Specify max processes of a state (0 means no limit)")
+	flag.IntVar(&conf.MaxChans, "max-chans", conf.MaxChans, "Specify max channels of a state (0 means no limit)")
+	flag.IntVar(&conf.MaxCalls, "max-calls", conf.MaxCalls, "Specify max call depth of a process (0 means no limit)")
+	flag.IntVar(&conf.MaxLoop, "max-loop", conf.MaxLoop, "Specify max iterations of a loop with a constant bound tracked exactly (0 means no limit)")
+}
+
+// target is a MiGo program and the entry definition to check.
+type target struct {
+	prog     *migo.Program
+	entry    string
+	inferred bool                  // Entry may be removed from the inferred MiGo.
+	pos      migocheck.PosFunc     // Source positions of statements.
+	ctx      migocheck.ContextFunc // Call-context paths of spawns.
+	approx   migocheck.ApproxFunc  // If statements approximating loops.
+	bound    migocheck.BoundFunc   // Iterations of loops with a constant bound.
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, Usage)
+		flag.PrintDefaults()
+		os.Exit(0)
+	}
+	switch diagFmt {
+	case "text", "json", "none":
+	default:
+		log.Fatalf("Unknown diagnostics format %q (text, json or none)", diagFmt)
+	}
+	switch traceFmt {
+	case "text", "json", "mermaid", "plantuml":
+	default:
+		log.Fatalf("Unknown trace format %q (text, json, mermaid or plantuml)", traceFmt)
+	}
+	var targets []target
+	if flag.NArg() == 1 && filepath.Ext(flag.Arg(0)) == ".migo" {
+		targets = append(targets, target{prog: parseFile(flag.Arg(0)), entry: entryFunc})
+	} else {
+		targets = infer()
+	}
+	var spec *migo.Program
+	if specFile != "" {
+		spec = parseFile(specFile)
+	}
+	ok := true
+	var diags []migoinfer.Diagnostic
+	for _, t := range targets {
+		checker := migocheck.New(t.prog)
+		checker.SetEntry(t.entry)
+		checker.SetConfig(conf)
+		checker.SetPositions(t.pos)
+		checker.SetSpawnContexts(t.ctx)
+		checker.SetLoopApprox(t.approx)
+		checker.SetLoopBounds(t.bound)
+		if spec != nil {
+			conformance, err := conform(checker, spec, t)
+			if err != nil {
+				log.Fatal("Conformance check failed: ", err)
+			}
+			if conformance != nil {
+				ok = ok && conformance.OK()
+				diags = append(diags, conformance.Diagnostics()...)
+			}
+			continue
+		}
+		result, err := checker.Check()
+		if errors.Cause(err) == migocheck.ErrNoEntry && t.inferred {
+			fmt.Printf("%s: no communication\n", t.entry)
+			continue
+		}
+		if err != nil {
+			log.Fatal("Check failed: ", err)
+		}
+		if err := writeResult(result); err != nil {
+			log.Fatal("Cannot write result: ", err)
+		}
+		ok = ok && result.OK()
+		diags = append(diags, result.Diagnostics()...)
+	}
+	switch diagFmt {
+	case "json":
+		if diags == nil {
+			diags = []migoinfer.Diagnostic{}
+		}
+		enc := json.NewEncoder(os.Stderr)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(diags); err != nil {
+			log.Fatal("Cannot write diagnostics: ", err)
+		}
+	case "none":
+	default:
+		for _, d := range diags {
+			fmt.Fprintln(os.Stderr, d)
+		}
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// parseFile parses the MiGo file name.
+func parseFile(name string) *migo.Program {
+	f, err := os.Open(name)
+	if err != nil {
+		log.Fatalf("Cannot open %s: %v", name, err)
+	}
+	defer f.Close()
+	prog, err := parser.Parse(f)
+	if err != nil {
+		log.Fatalf("Parse %s failed: %v", name, err)
+	}
+	return prog
+}
+
+// conform checks the conformance of target t to spec and writes the result,
+// or returns nil if the entry (or the spec entry in tests mode) is not found.
+func conform(checker *migocheck.Checker, spec *migo.Program, t target) (*migocheck.Conformance, error) {
+	entry := specEntry
+	if entry == "" || tests {
+		entry = t.entry
+	}
+	conformance, err := checker.Conform(spec, entry)
+	if errors.Cause(err) == migocheck.ErrNoEntry && t.inferred {
+		fmt.Printf("%s: no communication or no spec\n", t.entry)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return conformance, conformance.WriteText(os.Stdout)
+}
+
+// writeResult writes the result to stdout in the trace format.
+func writeResult(result *migocheck.Result) error {
+	switch traceFmt {
+	case "json":
+		return result.WriteJSON(os.Stdout)
+	case "mermaid":
+		return result.WriteMermaid(os.Stdout)
+	case "plantuml":
+		return result.WritePlantUML(os.Stdout)
+	}
+	return result.WriteText(os.Stdout)
+}
+
+// infer infers the MiGo of the Go files in the arguments, and returns the
+// programs to check.
+func infer() []target {
+	bconf := build.FromFiles(flag.Args()...).Default()
+	if tests {
+		bconf = bconf.WithTests()
+	}
+	info, err := bconf.Build()
+	if err != nil {
+		log.Fatal("Build failed:", err)
+	}
+	inferer := migoinfer.New(info, ioutil.Discard)
+	if tests {
+		inferer.SetTests(false)
+	}
+	prog, err := inferer.Analyse()
+	if err != nil {
+		if _, ok := err.(migoinfer.AnalysisErrors); !ok {
+			log.Fatal("Analysis failed: ", err)
+		}
+	}
+	if !tests {
+		return []target{{prog: prog, entry: entryFunc, inferred: true, pos: inferer.StmtPosition, ctx: inferer.SpawnContext, approx: inferer.LoopApprox, bound: inferer.LoopBound}}
+	}
+	var targets []target
+	for _, entry := range inferer.Entries {
+		targets = append(targets, target{prog: entry.MiGo, entry: entry.Name, inferred: true, pos: inferer.StmtPosition, ctx: inferer.SpawnContext, approx: inferer.LoopApprox, bound: inferer.LoopBound})
+	}
+	return targets
+}
@@ -0,0 +1,124 @@
+// Command migodiff is the command line entry point to comparing the MiGo types
+// of two versions of a program.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nickng/gospal/migodiff"
+	"github.com/nickng/gospal/migoinfer"
+	"github.com/nickng/gospal/ssa/build"
+	"github.com/nickng/migo"
+	"github.com/nickng/migo/parser"
+)
+
+const (
+	Usage = `migodiff is a tool for comparing the MiGo types inferred from two versions
+of a Go program (or read from MiGo files).
+
+Usage:
+
+  migodiff [options] old new
+
+where old and new are each a MiGo file (.migo), a Go file, or a directory of
+Go files (test files excluded). The exit status is 1 if a change is found.
+
+Options:
+
+`
+)
+
+var format string
+
+func init() {
+	flag.StringVar(&format, "format", "text", "Specify format of changes (text or json)")
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 2 {
+		fmt.Fprintf(os.Stderr, Usage)
+		flag.PrintDefaults()
+		os.Exit(0)
+	}
+	switch format {
+	case "text", "json":
+	default:
+		log.Fatalf("Unknown format %q (text or json)", format)
+	}
+	old, oldPos := load(flag.Arg(0))
+	new, newPos := load(flag.Arg(1))
+	d := migodiff.New(old, new)
+	d.SetPositions(oldPos, newPos)
+	changes := d.Diff()
+	switch format {
+	case "json":
+		if changes == nil {
+			changes = []migodiff.Change{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(changes); err != nil {
+			log.Fatal("Cannot write changes: ", err)
+		}
+	default:
+		for _, c := range changes {
+			fmt.Println(c)
+		}
+	}
+	if len(changes) > 0 {
+		os.Exit(1)
+	}
+}
+
+// load returns the MiGo of the version at path, and the source positions of
+// its statements if inferred.
+func load(path string) (*migo.Program, migodiff.PosFunc) {
+	if filepath.Ext(path) == ".migo" {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("Cannot open %s: %v", path, err)
+		}
+		defer f.Close()
+		prog, err := parser.Parse(f)
+		if err != nil {
+			log.Fatalf("Parse %s failed: %v", path, err)
+		}
+		return prog, nil
+	}
+	files := []string{path}
+	if fi, err := os.Stat(path); err != nil {
+		log.Fatalf("Cannot open %s: %v", path, err)
+	} else if fi.IsDir() {
+		if files, err = filepath.Glob(filepath.Join(path, "*.go")); err != nil {
+			log.Fatalf("Cannot list %s: %v", path, err)
+		}
+		var srcs []string
+		for _, file := range files {
+			if !strings.HasSuffix(file, "_test.go") {
+				srcs = append(srcs, file)
+			}
+		}
+		files = srcs
+	}
+	info, err := build.FromFiles(files...).Default().Build()
+	if err != nil {
+		log.Fatalf("Build %s failed: %v", path, err)
+	}
+	inferer := migoinfer.New(info, ioutil.Discard)
+	inferer.SetStable()
+	prog, err := inferer.Analyse()
+	if err != nil {
+		if _, ok := err.(migoinfer.AnalysisErrors); !ok {
+			log.Fatalf("Analysis of %s failed: %v", path, err)
+		}
+	}
+	return prog, inferer.StmtPosition
+}
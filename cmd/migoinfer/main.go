@@ -2,11 +2,14 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/nickng/gospal/migoinfer"
 	"github.com/nickng/gospal/ssa/build"
@@ -28,6 +31,23 @@ var (
 	logPath   string
 	showRaw   bool
 	entryFunc string
+	cgAlgo    string
+	invLimit  int
+	invAllow  string
+	unroll    int
+	library   bool
+	split     bool
+	tests     bool
+	diagFmt   string
+	maxDepth  int
+	maxSteps  int
+	maxCtxs   int
+	timeout   time.Duration
+	workers   int
+	stable    bool
+	positions bool
+	srcMap    string
+	format    string
 	logFile   string
 	logWriter = ioutil.Discard
 )
@@ -35,7 +55,24 @@ var (
 func init() {
 	flag.StringVar(&logPath, "log", "", "Specify analysis log file (use '-' for stderr)")
 	flag.BoolVar(&showRaw, "raw", false, "Show raw unfiltered MiGo")
+	flag.BoolVar(&library, "lib", false, "Infer MiGo of every exported function of non-main packages")
+	flag.BoolVar(&tests, "tests", false, "Load test files and infer MiGo of every test function")
+	flag.BoolVar(&split, "split", false, "Output one MiGo program per entry in library or tests mode")
+	flag.BoolVar(&stable, "stable", false, "Use stable names (from source variables) and ordering of definitions")
+	flag.StringVar(&format, "format", "text", "Specify output format (text, json or dot)")
+	flag.BoolVar(&positions, "pos", false, "Show source positions of definitions and statements as trailing comments")
+	flag.StringVar(&srcMap, "srcmap", "", "Specify file to write source map of MiGo definitions and statements (JSON)")
+	flag.IntVar(&workers, "j", 1, "Specify number of entries analysed in parallel in library or tests mode")
 	flag.StringVar(&entryFunc, "entry", "", `Specify the function to view (format: (import/path).FuncName, empty means main.main)`)
+	flag.StringVar(&cgAlgo, "callgraph", "cha", "Specify call graph algorithm for dynamic calls (cha, pta, rta or static)")
+	flag.IntVar(&invLimit, "invoke-limit", 8, "Specify max implementations of unresolved interface calls (0 means no limit)")
+	flag.IntVar(&unroll, "spawn-unroll", 8, "Specify max trip count of loops spawning goroutines to replicate (0 means never)")
+	flag.StringVar(&diagFmt, "diag", "text", "Specify format of diagnostics written to stderr (text, json or none)")
+	flag.IntVar(&maxDepth, "max-depth", 0, "Specify max call depth, deeper calls are truncated (0 means no limit)")
+	flag.IntVar(&maxSteps, "max-steps", 0, "Specify max instruction visits, later calls are truncated (0 means no limit)")
+	flag.IntVar(&maxCtxs, "max-contexts", 0, "Specify max context variants per function, calls needing more are truncated (0 means no limit)")
+	flag.DurationVar(&timeout, "timeout", 0, "Specify analysis time limit, later calls are truncated (0 means no limit)")
+	flag.StringVar(&invAllow, "invoke-allow", "", "Specify comma-separated types allowed to implement unresolved interface calls (format: import/path.TypeName)")
 }
 
 func main() {
@@ -45,8 +82,21 @@ func main() {
 		flag.PrintDefaults()
 		os.Exit(0)
 	}
+	switch diagFmt {
+	case "text", "json", "none":
+	default:
+		log.Fatalf("Unknown diagnostics format %q (text, json or none)", diagFmt)
+	}
+	switch format {
+	case migoinfer.FormatText, migoinfer.FormatJSON, migoinfer.FormatDOT:
+	default:
+		log.Fatalf("Unknown output format %q (text, json or dot)", format)
+	}
 
 	conf := build.FromFiles(flag.Args()...).Default()
+	if tests {
+		conf = conf.WithTests()
+	}
 	switch logPath {
 	case "":
 	case "-":
@@ -73,9 +123,62 @@ func main() {
 	if entryFunc != "" {
 		inferer.SetEntryFunc(entryFunc)
 	}
+	inferer.SetCallGraph(cgAlgo)
+	inferer.SetInvokeLimit(invLimit)
+	if invAllow != "" {
+		inferer.SetInvokeAllow(strings.Split(invAllow, ",")...)
+	}
+	inferer.SetSpawnUnroll(unroll)
+	inferer.SetWorkers(workers)
+	if stable {
+		inferer.SetStable()
+	}
+	if positions {
+		inferer.SetPositions()
+	}
+	inferer.SetFormat(format)
+	inferer.SetMaxDepth(maxDepth)
+	inferer.SetMaxSteps(maxSteps)
+	inferer.SetMaxContexts(maxCtxs)
+	if timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		inferer.SetContext(ctx)
+	}
+	if library {
+		inferer.SetLibrary(split)
+	}
+	if tests {
+		inferer.SetTests(split)
+	}
 	inferer.SetOutput(os.Stdout)
 	if showRaw {
 		inferer.Raw = true
 	}
-	inferer.Analyse()
+	if _, err := inferer.Analyse(); err != nil {
+		if _, ok := err.(migoinfer.AnalysisErrors); !ok {
+			log.Fatal("Analysis failed: ", err)
+		}
+	}
+	if srcMap != "" {
+		f, err := os.Create(srcMap)
+		if err != nil {
+			log.Fatalf("Cannot create source map %s: %v", srcMap, err)
+		}
+		if err := inferer.WriteSourceMap(f); err != nil {
+			log.Fatal("Cannot write source map: ", err)
+		}
+		f.Close()
+	}
+	switch diagFmt {
+	case "json":
+		if err := inferer.WriteDiagnosticsJSON(os.Stderr); err != nil {
+			log.Fatal("Cannot write diagnostics: ", err)
+		}
+	case "none":
+	default:
+		if err := inferer.WriteDiagnostics(os.Stderr); err != nil {
+			log.Fatal("Cannot write diagnostics: ", err)
+		}
+	}
 }
@@ -0,0 +1,208 @@
+// Command migorun is the command line entry point to running MiGo types with
+// random or user-chosen scheduling.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nickng/gospal/migocheck"
+	"github.com/nickng/gospal/migoinfer"
+	"github.com/nickng/gospal/ssa/build"
+	"github.com/nickng/migo"
+	"github.com/nickng/migo/parser"
+)
+
+const (
+	Usage = `migorun is a tool for running MiGo types inferred from Go source code (or
+read from a MiGo file) with random or user-chosen scheduling.
+
+Usage:
+
+  migorun [options] file.go [files.go...]
+  migorun [options] file.migo
+
+In random mode (the default), the program is run with random scheduling until
+no step is enabled, and the trace and the final state are written. The exit
+status is 1 if the program deadlocks or panics.
+
+Options:
+
+`
+
+	Help = `Commands:
+
+  s, step [n]   take the enabled step n (or the only enabled step)
+  l, list       list the enabled steps
+  r, run [n]    take random steps until blocked (or n steps, default -max-steps)
+  b, back       undo the last step
+  p, state      show goroutines and channels
+  t, trace      show the steps taken
+  reset         go back to the initial state
+  h, help       show this help
+  q, quit       quit
+`
+)
+
+var (
+	entryFunc   string
+	interactive bool
+	seed        int64
+	maxSteps    int
+	conf        = migocheck.DefaultConfig
+)
+
+func init() {
+	flag.StringVar(&entryFunc, "entry", "main.main", "Specify the entry MiGo definition to run")
+	flag.BoolVar(&interactive, "i", false, "Run interactively, reading commands from stdin")
+	flag.Int64Var(&seed, "seed", 0, "Specify seed of random scheduling (0 means seed by time)")
+	flag.IntVar(&maxSteps, "max-steps", 1000, "Specify max steps of a random run (0 means no limit)")
+	flag.IntVar(&conf.MaxProcs, "max-procs", conf.MaxProcs, "Specify max processes (0 means no limit)")
+	flag.IntVar(&conf.MaxChans, "max-chans", conf.MaxChans, "Specify max channels (0 means no limit)")
+	flag.IntVar(&conf.MaxCalls, "max-calls", conf.MaxCalls, "Specify max call depth of a process (0 means no limit)")
+	flag.IntVar(&conf.MaxLoop, "max-loop", conf.MaxLoop, "Specify max iterations of a loop with a constant bound tracked exactly (0 means no limit)")
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, Usage)
+		flag.PrintDefaults()
+		os.Exit(0)
+	}
+	checker := load()
+	checker.SetEntry(entryFunc)
+	checker.SetConfig(conf)
+	in, err := checker.Interpreter()
+	if err != nil {
+		log.Fatal("Cannot run: ", err)
+	}
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	in.SetSeed(seed)
+	if interactive {
+		repl(in, os.Stdin, os.Stdout)
+		return
+	}
+	in.Run(maxSteps)
+	fmt.Printf("seed %d\n", seed)
+	fmt.Print(in.Trace())
+	if err := in.WriteState(os.Stdout); err != nil {
+		log.Fatal("Cannot write state: ", err)
+	}
+	if s := in.Status(); s == migocheck.StatusDeadlock || s == migocheck.StatusPanic {
+		os.Exit(1)
+	}
+}
+
+// load returns the checker of the MiGo in the arguments.
+func load() *migocheck.Checker {
+	if flag.NArg() == 1 && filepath.Ext(flag.Arg(0)) == ".migo" {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			log.Fatalf("Cannot open %s: %v", flag.Arg(0), err)
+		}
+		defer f.Close()
+		prog, err := parser.Parse(f)
+		if err != nil {
+			log.Fatal("Parse failed: ", err)
+		}
+		return migocheck.New(prog)
+	}
+	info, err := build.FromFiles(flag.Args()...).Default().Build()
+	if err != nil {
+		log.Fatal("Build failed:", err)
+	}
+	inferer := migoinfer.New(info, ioutil.Discard)
+	var prog *migo.Program
+	if prog, err = inferer.Analyse(); err != nil {
+		if _, ok := err.(migoinfer.AnalysisErrors); !ok {
+			log.Fatal("Analysis failed: ", err)
+		}
+	}
+	checker := migocheck.New(prog)
+	checker.SetPositions(inferer.StmtPosition)
+	checker.SetSpawnContexts(inferer.SpawnContext)
+	checker.SetLoopApprox(inferer.LoopApprox)
+	checker.SetLoopBounds(inferer.LoopBound)
+	return checker
+}
+
+// repl reads commands from r and runs them on in, writing the output to w.
+func repl(in *migocheck.Interpreter, r io.Reader, w io.Writer) {
+	fmt.Fprintf(w, "seed %d, type h for help\n", seed)
+	in.WriteState(w)
+	in.WriteSteps(w)
+	scanner := bufio.NewScanner(r)
+	for fmt.Fprint(w, "> "); scanner.Scan(); fmt.Fprint(w, "> ") {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		n, hasN := 0, len(fields) > 1
+		if hasN {
+			var err error
+			if n, err = strconv.Atoi(fields[1]); err != nil {
+				fmt.Fprintf(w, "bad number %q\n", fields[1])
+				continue
+			}
+		}
+		switch fields[0] {
+		case "s", "step":
+			if !hasN && len(in.Steps()) > 1 {
+				fmt.Fprintln(w, "more than one step enabled, choose one:")
+				in.WriteSteps(w)
+				continue
+			}
+			if err := in.Step(n); err != nil {
+				fmt.Fprintln(w, err)
+				continue
+			}
+			fmt.Fprintf(w, "%4d  %s\n", len(in.Trace()), in.Trace()[len(in.Trace())-1])
+			in.WriteSteps(w)
+		case "l", "list":
+			in.WriteSteps(w)
+		case "r", "run":
+			if !hasN {
+				n = maxSteps
+			}
+			taken := in.Run(n)
+			trace := in.Trace()
+			for i := len(trace) - taken; i < len(trace); i++ {
+				fmt.Fprintf(w, "%4d  %s\n", i+1, trace[i])
+			}
+			in.WriteState(w)
+		case "b", "back":
+			if err := in.Back(); err != nil {
+				fmt.Fprintln(w, err)
+				continue
+			}
+			in.WriteSteps(w)
+		case "p", "state":
+			in.WriteState(w)
+		case "t", "trace":
+			fmt.Fprint(w, in.Trace())
+		case "reset":
+			in.Reset()
+			in.WriteState(w)
+			in.WriteSteps(w)
+		case "h", "help":
+			fmt.Fprint(w, Help)
+		case "q", "quit":
+			return
+		default:
+			fmt.Fprintf(w, "unknown command %q, type h for help\n", fields[0])
+		}
+	}
+	fmt.Fprintln(w)
+}
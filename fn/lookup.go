@@ -6,6 +6,7 @@ import (
 	"go/token"
 	"go/types"
 	"log"
+	"sort"
 
 	"golang.org/x/tools/go/ssa"
 )
@@ -200,3 +201,41 @@ func unwrapnilchk(fn *ssa.Function) (realFn *ssa.Function, wrapped bool) {
 	realFn = fn
 	return
 }
+
+// LookupImpls finds all concrete implementations of method meth of interface
+// iface among the named types in prog, i.e. class hierarchy analysis.
+// The results are sorted by name.
+func LookupImpls(prog *ssa.Program, meth *types.Func, iface *types.Interface) []*ssa.Function {
+	if meth == nil || iface == nil {
+		return nil
+	}
+	seen := make(map[*ssa.Function]bool)
+	var impls []*ssa.Function
+	for _, pkg := range prog.AllPackages() {
+		for _, memb := range pkg.Members {
+			t, ok := memb.(*ssa.Type)
+			if !ok {
+				continue
+			}
+			if types.IsInterface(t.Type()) {
+				continue
+			}
+			for _, typ := range []types.Type{t.Type(), types.NewPointer(t.Type())} {
+				if !types.Implements(typ, iface) {
+					continue
+				}
+				fn := prog.LookupMethod(typ, meth.Pkg(), meth.Name())
+				if fn == nil {
+					continue
+				}
+				fn = FindConcrete(prog, fn)
+				if !seen[fn] {
+					seen[fn] = true
+					impls = append(impls, fn)
+				}
+			}
+		}
+	}
+	sort.Slice(impls, func(i, j int) bool { return impls[i].String() < impls[j].String() })
+	return impls
+}
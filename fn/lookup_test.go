@@ -1,6 +1,7 @@
 package fn
 
 import (
+	"go/types"
 	gssa "github.com/nickng/gospal/ssa"
 	"github.com/nickng/gospal/ssa/build"
 	"golang.org/x/tools/go/ssa"
@@ -159,3 +160,35 @@ func TestChainedCall(t *testing.T) {
 	}
 	t.Logf("%v has type %v", c.Call.Value.Name(), fn.String())
 }
+
+// Tests lookup of all implementations of interface.
+func TestLookupImpls(t *testing.T) {
+	info, err := build.FromFiles("testdata/impls.go").Default().Build()
+	if err != nil {
+		t.Errorf("SSA build failed: %v", err)
+	}
+	mains, err := gssa.MainPkgs(info.Prog, false)
+	if err != nil {
+		t.Errorf("no main package: %v", err)
+	}
+	// invoke call statement
+	var c *ssa.Call
+	for _, instr := range mains[0].Func("call").Blocks[0].Instrs {
+		if call, ok := instr.(*ssa.Call); ok && call.Call.IsInvoke() {
+			c = call
+		}
+	}
+	if c == nil {
+		t.Fatalf("Expecting an invoke call in %v", mains[0].Func("call"))
+	}
+	iface := c.Call.Value.Type().Underlying().(*types.Interface)
+	impls := LookupImpls(info.Prog, c.Call.Method, iface)
+	if expect, got := 2, len(impls); expect != got {
+		t.Fatalf("Expecting %d implementations but got %d: %v", expect, got, impls)
+	}
+	for i, expect := range []string{"(*main.u).f", "(main.t).f"} {
+		if got := impls[i].String(); expect != got {
+			t.Errorf("Implementation %d wrong:\nExpect:\t%v\nGot:\t%v\n", i, expect, got)
+		}
+	}
+}
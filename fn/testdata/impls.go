@@ -0,0 +1,26 @@
+// +build ignore
+
+package main
+
+type fer interface {
+	f()
+}
+
+type t struct{}
+
+func (t) f() {}
+
+type u struct{}
+
+func (*u) f() {}
+
+type v struct{}
+
+func call(x fer) {
+	x.f()
+}
+
+func main() {
+	call(t{})
+	call(new(u))
+}
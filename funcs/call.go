@@ -5,12 +5,24 @@ import (
 	"fmt"
 	"go/token"
 	"go/types"
-	"log"
 
 	"github.com/nickng/gospal/store"
+	"github.com/pkg/errors"
 	"golang.org/x/tools/go/ssa"
 )
 
+var (
+	// ErrArgMismatch is the error when the arguments of a call do not match the
+	// parameters of the function.
+	ErrArgMismatch = errors.New("funcs: mismatched arguments/parameters")
+	// ErrBindMismatch is the error when the bindings of a closure do not match
+	// the captured variables of the function.
+	ErrBindMismatch = errors.New("funcs: mismatched bindings/captures")
+	// ErrNotTuple is the error when the return value of a call with multiple
+	// return values is not a tuple.
+	ErrNotTuple = errors.New("funcs: return value is not a tuple")
+)
+
 // A Call is a function call definition, i.e. function definition from the
 // perspective of the caller.
 //
@@ -23,25 +35,22 @@ type Call struct {
 }
 
 // MakeCall instantiates function call given its definition and a CallCommon.
-func MakeCall(d *Definition, call *ssa.CallCommon, ret ssa.Value) *Call {
+// If the call does not match the definition, MakeCall returns a nil call and
+// the error.
+func MakeCall(d *Definition, call *ssa.CallCommon, ret ssa.Value) (*Call, error) {
 	c := Call{def: d}
 	// Function call arguments.
 	if call != nil {
 		c.Args = getArgs(call)
 		if len(c.Args) != d.NParam {
-			log.Fatalf("Mismatched argument(%d)/parameter(%d)\n\t%s\n\t%s",
-				len(c.Args), d.NParam,
-				d.Function.Prog.Fset.Position(call.Pos()).String(),
-				d.Function.Prog.Fset.Position(d.Function.Pos()).String())
+			return nil, errors.Wrapf(ErrArgMismatch, "%d arguments, %d parameters of %s",
+				len(c.Args), d.NParam, d.Function)
 		}
 		if len(d.bindings) != d.NFreeVar {
-			log.Printf("Mismatched capture(%d)/binding(%d)\n\t%s\n\t%s",
-				len(d.bindings), d.NFreeVar,
-				d.Function.Prog.Fset.Position(call.Pos()).String(),
-				d.Function.Prog.Fset.Position(d.Function.Pos()).String())
 			// TODO(nickng) this means matching arg-param is impossible but
 			// perhaps there are ways to recover from this?
-			return nil
+			return nil, errors.Wrapf(ErrBindMismatch, "%d bindings, %d captures of %s",
+				len(d.bindings), d.NFreeVar, d.Function)
 		}
 	} else {
 		c.Args = getFakeArgs(d.Function)
@@ -62,7 +71,7 @@ func MakeCall(d *Definition, call *ssa.CallCommon, ret ssa.Value) *Call {
 		if ret != nil {
 			c.Parameters[d.NParam+d.NFreeVar] = ret
 		} else {
-			c.Parameters[d.NParam+d.NFreeVar] = store.Unused{store.MockKey{
+			c.Parameters[d.NParam+d.NFreeVar] = store.Unused{MockKey: store.MockKey{
 				Description: "Unused_RetVal",
 				Typ:         d.Function.Signature.Results().At(0).Type(),
 				SrcPos:      d.Function.Pos(),
@@ -75,7 +84,7 @@ func MakeCall(d *Definition, call *ssa.CallCommon, ret ssa.Value) *Call {
 				switch instr := instr.(type) {
 				case *ssa.Extract:
 					if instr.Tuple != ret {
-						log.Fatal("Return values:", ret.Name(), "is not a tuple")
+						return nil, errors.Wrapf(ErrNotTuple, "return values %s of %s", ret.Name(), d.Function)
 					}
 					c.Parameters[d.NParam+d.NFreeVar+instr.Index] = instr
 					retvals[instr.Index] = instr
@@ -87,7 +96,7 @@ func MakeCall(d *Definition, call *ssa.CallCommon, ret ssa.Value) *Call {
 		}
 		for i, retval := range retvals {
 			if retval == nil {
-				c.Parameters[d.NParam+d.NFreeVar+i] = store.Unused{store.MockKey{
+				c.Parameters[d.NParam+d.NFreeVar+i] = store.Unused{MockKey: store.MockKey{
 					Description: "Unused_RetVal",
 					Typ:         d.Function.Signature.Results().At(i).Type(),
 					SrcPos:      d.Function.Pos(),
@@ -95,7 +104,7 @@ func MakeCall(d *Definition, call *ssa.CallCommon, ret ssa.Value) *Call {
 			}
 		}
 	}
-	return &c
+	return &c, nil
 }
 
 func (c *Call) Definition() *Definition {
@@ -178,15 +187,12 @@ func getArgs(call *ssa.CallCommon) []store.Key {
 
 // getFakeArgs returns fake arguments in function call.
 func getFakeArgs(fn *ssa.Function) []store.Key {
-	if sigParam := fn.Signature.Params(); sigParam != nil {
-		nArg := sigParam.Len()
-		args := make([]store.Key, nArg)
-		for i, arg := range fn.Params {
-			args[i] = createMock(fn, arg.Type(), "arg")
-		}
-		return args
+	// fn.Params includes the receiver of methods.
+	args := make([]store.Key, len(fn.Params))
+	for i, arg := range fn.Params {
+		args[i] = createMock(fn, arg.Type(), "arg")
 	}
-	return []store.Key{}
+	return args
 }
 
 // mockValue is a dummy ssa.Value for filling in empty function params/returns.
@@ -2,16 +2,19 @@ package funcs
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"go/token"
 	"go/types"
-	"log"
 
 	"github.com/nickng/gospal/store"
 
 	"golang.org/x/tools/go/ssa"
 )
 
+// ErrNilFunction is the error when making a definition of a nil function.
+var ErrNilFunction = errors.New("funcs: function is nil")
+
 // returnCount counts number of occurrences of return value ssa.Value.
 type returnCount map[store.Key]int
 
@@ -52,8 +55,7 @@ func MakeClosureDefinition(fn *ssa.Function, bindings []ssa.Value) *Definition {
 // MakeDefinition returns a definition for a given function.
 func MakeDefinition(fn *ssa.Function) *Definition {
 	if fn == nil {
-		log.Fatal("funcs.MakeDefinition: Function is nil")
-		return nil
+		panic(ErrNilFunction)
 	}
 	params, isVararg := getParams(fn)
 	nParam := len(params)
@@ -99,6 +101,11 @@ func (d *Definition) Return(i int) store.Key {
 	return d.Parameters[d.NParam+d.NFreeVar+i]
 }
 
+// Bindings returns the variables bound to the free variables of a closure.
+func (d *Definition) Bindings() []ssa.Value {
+	return d.bindings
+}
+
 // IsReturn return true if the given name is a return value.
 func (d *Definition) IsReturn(k store.Key) bool {
 	for _, rc := range d.returnSet {
@@ -115,7 +122,7 @@ func (d *Definition) IsReturn(k store.Key) bool {
 func (d *Definition) getName() []byte {
 	var buf bytes.Buffer
 	if r := d.Function.Signature.Recv(); r != nil {
-		buf.WriteString(fmt.Sprintf("\"%s\".%s", r.Pkg().Path(), r.Name()))
+		buf.WriteString(fmt.Sprintf("\"%s\".%s", r.Pkg().Path(), recvTypeName(r)))
 	} else {
 		if pkg := d.Function.Package(); pkg != nil {
 			buf.WriteString(fmt.Sprintf("\"%s\"", pkg.Pkg.Path()))
@@ -166,6 +173,19 @@ func (d *Definition) UniqName() string {
 	return string(d.getName())
 }
 
+// recvTypeName returns the name of the type of receiver r, so that methods of
+// different types with unnamed receivers have distinct names.
+func recvTypeName(r *types.Var) string {
+	t := r.Type()
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	if named, ok := t.(*types.Named); ok {
+		return named.Obj().Name()
+	}
+	return r.Name()
+}
+
 // hasBody returns true if the function has body defined.
 func hasBody(fn *ssa.Function) bool {
 	return len(fn.Blocks) > 0
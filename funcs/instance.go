@@ -7,35 +7,55 @@ import (
 	"golang.org/x/tools/go/ssa"
 )
 
-var instances struct {
+// Instances keeps track of the instances of functions, so instances of the
+// same function are numbered in sequence. The zero value is ready to use.
+//
+// Analyses which run concurrently should use their own Instances, so the
+// numbering is independent of the scheduling.
+type Instances struct {
 	mu    sync.Mutex
 	calls map[*ssa.Function]int
 }
 
+// instances is the Instances shared by Instantiate and InstantiateVariant.
+var instances Instances
+
 // Instantiate materialises a new function call instance.
 func Instantiate(call *Call) *Instance {
-	if instances.calls == nil {
-		instances.calls = make(map[*ssa.Function]int)
+	return InstantiateVariant(call, 0)
+}
+
+// InstantiateVariant materialises a new function call instance of the given
+// context variant. Instances of different variants of a function have
+// different names.
+func InstantiateVariant(call *Call, variant int) *Instance {
+	return instances.InstantiateVariant(call, variant)
+}
+
+// InstantiateVariant materialises a new function call instance of the given
+// context variant, numbered in sequence of the instances in is.
+func (is *Instances) InstantiateVariant(call *Call, variant int) *Instance {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	if is.calls == nil {
+		is.calls = make(map[*ssa.Function]int)
 	}
-	instances.mu.Lock()
-	defer instances.mu.Unlock()
 	f := call.Function()
-	if _, ok := instances.calls[f]; !ok {
-		instances.calls[f] = 0
-	}
-	seq := instances.calls[f]
-	instances.calls[f]++
+	seq := is.calls[f]
+	is.calls[f]++
 	return &Instance{
-		call: call,
-		seq:  seq,
+		call:    call,
+		seq:     seq,
+		variant: variant,
 	}
 }
 
 // An Instance of a function call.
 // It is guaranteed unique by the seq field.
 type Instance struct {
-	call *Call // Function call definition.
-	seq  int   // Sequence (instance number).
+	call    *Call // Function call definition.
+	seq     int   // Sequence (instance number).
+	variant int   // Context variant (0 is the default).
 }
 
 // Call returns the call definition (function definition at caller).
@@ -57,6 +77,9 @@ func (i Instance) Name() string {
 	if i.call == nil {
 		return "_emptycall_"
 	}
+	if i.variant > 0 {
+		return fmt.Sprintf("%s#ctx%d", string(i.Definition().getName()), i.variant)
+	}
 	return string(i.Definition().getName())
 }
 
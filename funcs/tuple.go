@@ -0,0 +1,42 @@
+package funcs
+
+import (
+	"bytes"
+
+	"github.com/nickng/gospal/store"
+)
+
+// Tuple is the value of a function call with multiple return values.
+// The i-th element is the value of the i-th return value, and the elements
+// are retrieved by ssa.Extract of the call.
+type Tuple []store.Value
+
+// MakeTuple returns a new Tuple with n elements.
+func MakeTuple(n int) Tuple {
+	return make(Tuple, n)
+}
+
+// At returns the i-th element of the tuple, or nil if it is undefined.
+func (t Tuple) At(i int) store.Value {
+	if i < 0 || i >= len(t) {
+		return nil
+	}
+	return t[i]
+}
+
+func (t Tuple) UniqName() string {
+	var buf bytes.Buffer
+	buf.WriteRune('(')
+	for i, v := range t {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		if v == nil {
+			buf.WriteRune('_')
+		} else {
+			buf.WriteString(v.UniqName())
+		}
+	}
+	buf.WriteRune(')')
+	return buf.String()
+}
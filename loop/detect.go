@@ -12,7 +12,10 @@ import (
 	"golang.org/x/tools/go/ssa"
 )
 
-var ErrIdxNotInt = errors.New("index is not int")
+var (
+	ErrIdxNotInt = errors.New("index is not int")
+	ErrNoSubtree = errors.New("loop condition subtree does not exist")
+)
 
 // State is the loop transition states.
 type State int
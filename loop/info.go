@@ -3,7 +3,7 @@ package loop
 import (
 	"bytes"
 	"fmt"
-	"log"
+	"go/token"
 	"strings"
 
 	"golang.org/x/tools/go/ssa"
@@ -75,7 +75,7 @@ func (i *Info) AddTrue(cond ssa.Value) {
 		}
 		return
 	}
-	log.Fatal("AddTrue to subtree that does not exist")
+	panic(ErrNoSubtree)
 }
 
 func (i *Info) AddFalse(cond ssa.Value) {
@@ -91,7 +91,7 @@ func (i *Info) AddFalse(cond ssa.Value) {
 		}
 		return
 	}
-	log.Fatal("AddFalse to subtree that does not exist")
+	panic(ErrNoSubtree)
 }
 
 // MarkTarget points a part of a loop condition to an existing subtree.
@@ -100,7 +100,7 @@ func (i *Info) MarkTarget() {
 		prev.True = i.target
 		return
 	}
-	log.Fatal("MarkTarget to subtree that does not exist")
+	panic(ErrNoSubtree)
 }
 
 func binTreeToString(t *BinTree) []string {
@@ -139,6 +139,9 @@ func (i *Info) String() string {
 	return buf.String()
 }
 
+// Index returns the index variable of the loop, nil if not detected.
+func (i *Info) Index() ssa.Value { return i.indexVar }
+
 func (i *Info) BodyIdx() int { return i.bodyIdx }
 
 func (i *Info) DoneIdx() int { return i.doneIdx }
@@ -147,3 +150,73 @@ func (i *Info) DoneIdx() int { return i.doneIdx }
 func (i *Info) ParamsOK() bool {
 	return i.indexVar != nil && i.indexOK && i.condRoot != nil && i.condRoot.Cond != nil && i.condOK
 }
+
+// TripCount returns the number of iterations of the loop if it can be
+// determined statically, i.e. the loop condition is a single comparison
+// between the index and a constant, and the loop terminates.
+func (i *Info) TripCount() (int64, bool) {
+	if !i.ParamsOK() || i.stepVal == 0 {
+		return 0, false
+	}
+	if t := i.condRoot.True; i.condRoot.False != nil || (t != nil && !t.Target) {
+		return 0, false // Compound condition.
+	}
+	cond, ok := i.condRoot.Cond.(*ssa.BinOp)
+	if !ok {
+		return 0, false
+	}
+	op, bound := cond.Op, cond.Y
+	if cond.Y == i.indexVar { // Normalise to index on the left.
+		bound = cond.X
+		switch op {
+		case token.LSS:
+			op = token.GTR
+		case token.LEQ:
+			op = token.GEQ
+		case token.GTR:
+			op = token.LSS
+		case token.GEQ:
+			op = token.LEQ
+		}
+	} else if cond.X != i.indexVar {
+		return 0, false
+	}
+	c, ok := bound.(*ssa.Const)
+	if !ok {
+		return 0, false
+	}
+	b, err := getIntConst(c)
+	if err != nil {
+		return 0, false
+	}
+	a, s := i.initVal, i.stepVal
+	switch op {
+	case token.LEQ:
+		b, op = b+1, token.LSS
+	case token.GEQ:
+		b, op = b-1, token.GTR
+	}
+	switch op {
+	case token.LSS:
+		if a >= b {
+			return 0, true
+		}
+		if s < 0 {
+			return 0, false // Unbounded.
+		}
+		return (b - a + s - 1) / s, true
+	case token.GTR:
+		if a <= b {
+			return 0, true
+		}
+		if s > 0 {
+			return 0, false // Unbounded.
+		}
+		return (a - b - s - 1) / -s, true
+	case token.NEQ:
+		if d := b - a; d%s == 0 && d/s >= 0 {
+			return d / s, true
+		}
+	}
+	return 0, false
+}
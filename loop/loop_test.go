@@ -141,3 +141,47 @@ func TestNotLoop(t *testing.T) {
 		}
 	}
 }
+
+func TestTripCount(t *testing.T) {
+	tests := []struct {
+		loop  string
+		count int64
+		ok    bool
+	}{
+		{"for i := 0; i < 10; i++ {}", 10, true},
+		{"for i := 1; i <= 9; i += 2 {}", 5, true},
+		{"for i := 10; i > 0; i-- {}", 10, true},
+		{"for i := 0; 3 > i; i++ {}", 3, true},
+		{"for i := 0; i != 6; i += 3 {}", 2, true},
+		{"for i := 0; i < 10 && i%2 == 0; i++ {}", 0, false},
+		{"for i := 0; i < n; i++ {}", 0, false},
+	}
+	for _, test := range tests {
+		src := `package main
+		var n = 4
+		func main() {
+			` + test.loop + `
+		}`
+		info, err := build.FromReader(strings.NewReader(src)).Default().Build()
+		if err != nil {
+			t.Fatal("cannot build SSA:", err)
+		}
+		mains, err := ssa.MainPkgs(info.Prog, false)
+		if err != nil {
+			t.Fatal("Cannot find main package:", err)
+		}
+		ld := loopDetector{d: NewDetector()}
+		for _, main := range mains {
+			block.TraverseEdges(main.Func("main"), ld.detect)
+			l := ld.d.ForLoopAt(main.Func("main").Blocks[1])
+			if l == nil {
+				t.Errorf("Loop not detected: %s", test.loop)
+				continue
+			}
+			if count, ok := l.TripCount(); count != test.count || ok != test.ok {
+				t.Errorf("Trip count of %s: want (%d, %t) got (%d, %t)",
+					test.loop, test.count, test.ok, count, ok)
+			}
+		}
+	}
+}
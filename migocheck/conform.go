@@ -0,0 +1,437 @@
+package migocheck
+
+// Conformance to a specification.
+//
+// A MiGo program conforms to a specification (another MiGo program, e.g. the
+// intended protocol written by hand) if every behaviour of the program is a
+// behaviour of the specification. The behaviours are the visible actions:
+// newchan (with the buffer size), send, receive and close on buffered
+// channels, synchronisations on unbuffered channels, and the return of the
+// entry. Spawns, calls, branches and tau are internal, so the structure of
+// the goroutines and definitions may differ. Channels are matched by their
+// newchan, i.e. a channel of the program is renamed to the channel of the
+// specification made by the matching newchan, so the names of the channels do
+// not matter.
+//
+// The program is explored breadth first with the set of the states of the
+// specification which match the trace so far, so the divergence reported is
+// the shortest trace of the program that the specification cannot follow.
+// Deadlocks and leaks are not part of the behaviours, see Check.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/nickng/gospal/migoinfer"
+	"github.com/nickng/migo"
+	"github.com/pkg/errors"
+)
+
+// CodeDivergence is the diagnostic code of divergences from a specification.
+const CodeDivergence = "spec-divergence"
+
+// Conformance is the result of checking a MiGo program against a
+// specification.
+type Conformance struct {
+	Entry     string // Name of the entry definition of the program.
+	SpecEntry string // Name of the entry definition of the specification.
+	States    int    // Number of states explored.
+	Bounded   bool   // Some states are not explored because of the bounds.
+
+	Divergence *Divergence // First divergence, nil if the program conforms.
+}
+
+// OK returns true if no divergence is found.
+func (c *Conformance) OK() bool {
+	return c.Divergence == nil
+}
+
+// Divergence is a trace of a program which the specification cannot follow.
+type Divergence struct {
+	Trace    Trace    // Steps from the initial state, the last step diverges.
+	Return   bool     // The divergence is the return of the entry.
+	Expected []string // Visible actions the specification can take instead.
+}
+
+// Step returns the diverging step, or no step if the entry of the program
+// returns without any step.
+func (d *Divergence) Step() Step {
+	if len(d.Trace) == 0 {
+		return Step{}
+	}
+	return d.Trace[len(d.Trace)-1]
+}
+
+// action returns the text of the diverging action.
+func (d *Divergence) action() string {
+	if d.Return {
+		return "return of the entry"
+	}
+	step := d.Step()
+	if len(step.Events) == 0 {
+		return "return of the entry"
+	}
+	if len(step.Events) == 2 {
+		return fmt.Sprintf("%s <-> %s", eventText(step.Events[0]), eventText(step.Events[1]))
+	}
+	return eventText(step.Events[0])
+}
+
+func (d *Divergence) header() string {
+	var buf bytes.Buffer
+	buf.WriteString("diverges at:\n")
+	for _, e := range d.Step().Events {
+		buf.WriteString(fmt.Sprintf("      %-20s #%d %s: %s\n", shortPos(e.Pos), e.Proc, e.Func, eventText(e)))
+	}
+	if d.Return {
+		buf.WriteString("      where the entry returns\n")
+	}
+	if len(d.Expected) == 0 {
+		buf.WriteString("expected no visible action\n")
+	} else {
+		buf.WriteString("expected one of:\n")
+		for _, a := range d.Expected {
+			buf.WriteString(fmt.Sprintf("      %s\n", a))
+		}
+	}
+	return buf.String()
+}
+
+// WriteText writes the result as text to w.
+func (c *Conformance) WriteText(w io.Writer) error {
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("%s against spec %s: %d states explored", c.Entry, c.SpecEntry, c.States))
+	if c.Bounded {
+		buf.WriteString(" (bounded, result may be incomplete)")
+	}
+	buf.WriteString("\n")
+	if d := c.Divergence; d != nil {
+		buf.WriteString(d.header())
+		if err := NewTraceView(c.Entry, d.Trace).WriteText(&buf); err != nil {
+			return err
+		}
+	} else {
+		buf.WriteString("conforms to spec\n")
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Diagnostics returns the divergence as a diagnostic at the diverging step.
+func (c *Conformance) Diagnostics() []migoinfer.Diagnostic {
+	d := c.Divergence
+	if d == nil {
+		return nil
+	}
+	expected := "no visible action"
+	if len(d.Expected) > 0 {
+		expected = strings.Join(d.Expected, ", ")
+	}
+	diag := migoinfer.Diagnostic{
+		Severity: migoinfer.SeverityError,
+		Code:     CodeDivergence,
+		Func:     c.Entry,
+		Message:  fmt.Sprintf("%s diverges from spec %s (expected %s)", d.action(), c.SpecEntry, expected),
+	}
+	if events := d.Step().Events; len(events) > 0 {
+		diag.Pos, diag.Func = events[0].Pos, events[0].Func
+	}
+	return []migoinfer.Diagnostic{diag}
+}
+
+// Conform checks that the program conforms to the entry definition of spec,
+// up to the bounds of the checker.
+func (c *Checker) Conform(spec *migo.Program, entry string) (*Conformance, error) {
+	m, err := newMachine(c.Prog, c.Config, c.Pos, c.Ctx, c.Approx, c.Bound)
+	if err != nil {
+		return nil, err
+	}
+	d, ok := m.lookup(c.Entry)
+	if !ok {
+		return nil, errors.Wrap(ErrNoEntry, c.Entry)
+	}
+	sm, err := newMachine(spec, c.Config, nil, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	sd, ok := sm.lookup(entry)
+	if !ok {
+		return nil, errors.Wrapf(ErrNoEntry, "spec %s", entry)
+	}
+	return (&conformer{m: m, spec: sm}).explore(d, sd), nil
+}
+
+// Kinds of visible actions besides the communication events.
+const (
+	labelSync   EventKind = "sync"   // Synchronisation on unbuffered channel.
+	labelReturn EventKind = "return" // Return of the entry.
+)
+
+// label is a visible action.
+type label struct {
+	kind EventKind
+	ch   int // Unique ID of the channel, or -1.
+	size int // Buffer size of newchan.
+}
+
+// stepLabel returns the visible action of step, false if step is internal.
+func stepLabel(step Step) (label, bool) {
+	e := step.Events[0]
+	if len(step.Events) == 2 {
+		return label{kind: labelSync, ch: e.ChanID}, e.ChanID >= 0
+	}
+	switch e.Kind {
+	case EventNewChan:
+		return label{kind: EventNewChan, ch: e.ChanID, size: int(e.Stmt.(*migo.NewChanStatement).Size)}, true
+	case EventSend, EventRecv, EventClose:
+		return label{kind: e.Kind, ch: e.ChanID}, e.ChanID >= 0
+	}
+	return label{}, false
+}
+
+// config is a state of the specification, and the renaming from the unique
+// IDs of the channels of the program to those of the specification.
+type config struct {
+	s      *state
+	rename map[int]int
+}
+
+// key returns the key of the configuration, where the channels are renamed
+// relative to the channel indices (idx, by unique ID) of the program state.
+func (c config) key(idx map[int]int) string {
+	inverse := make(map[int]int, len(c.rename))
+	for from, to := range c.rename {
+		inverse[to] = from
+	}
+	var buf strings.Builder
+	buf.WriteString(c.s.key())
+	for _, ch := range c.s.chans {
+		k := -1
+		if from, ok := inverse[ch.uid]; ok {
+			if i, ok := idx[from]; ok {
+				k = i
+			}
+		}
+		fmt.Fprintf(&buf, "<%d>", k)
+	}
+	return buf.String()
+}
+
+// conformer explores a program with the matching states of the specification.
+type conformer struct {
+	m, spec *machine
+	cut     bool // A bound is reached.
+}
+
+// pair is a state of the program and the matching configurations of the
+// specification, closed under internal steps.
+type pair struct {
+	s      *state
+	specs  []config
+	parent *pair
+	step   Step
+	depth  int
+}
+
+func (p *pair) trace() Trace {
+	trace := make(Trace, p.depth)
+	for ; p.parent != nil; p = p.parent {
+		trace[p.depth-1] = p.step
+	}
+	return trace
+}
+
+// uidIndex returns the indices of the channels of s by unique ID.
+func uidIndex(s *state) map[int]int {
+	idx := make(map[int]int, len(s.chans))
+	for i, ch := range s.chans {
+		idx[ch.uid] = i
+	}
+	return idx
+}
+
+func (p *pair) key() string {
+	idx := uidIndex(p.s)
+	keys := make([]string, len(p.specs))
+	for i, c := range p.specs {
+		keys[i] = c.key(idx)
+	}
+	sort.Strings(keys)
+	return p.s.key() + "|" + strings.Join(keys, "|")
+}
+
+func (cf *conformer) explore(entry, specEntry *def) *Conformance {
+	r := &Conformance{Entry: entry.name, SpecEntry: specEntry.name}
+	s := cf.m.initial(entry)
+	s.normalise()
+	ss := cf.spec.initial(specEntry)
+	ss.normalise()
+	init := &pair{s: s, specs: cf.closure(s, []config{{s: ss, rename: map[int]int{}}})}
+	if s.mainDone {
+		specs := cf.returned(init.specs)
+		if len(specs) == 0 {
+			r.States = 1
+			r.Divergence = &Divergence{Return: true, Expected: cf.expected(init.specs)}
+			return r
+		}
+		init.specs = specs
+	}
+	seen := map[string]bool{init.key(): true}
+	queue := []*pair{init}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue[0], queue = nil, queue[1:]
+		r.States++
+		ts, cut := cf.m.successors(p.s)
+		cf.cut = cf.cut || cut
+		if cf.m.MaxDepth > 0 && p.depth >= cf.m.MaxDepth {
+			cf.cut = cf.cut || len(ts) > 0
+			continue
+		}
+		for _, t := range ts {
+			specs := p.specs
+			if l, ok := stepLabel(t.step); ok {
+				specs = cf.closure(t.next, cf.match(specs, l))
+			}
+			before, ret := p.specs, false // Configurations the divergence is from.
+			if t.next.mainDone && !p.s.mainDone && len(specs) > 0 {
+				before, ret = specs, true
+				specs = cf.returned(specs)
+			}
+			next := &pair{s: t.next, specs: specs, parent: p, step: t.step, depth: p.depth + 1}
+			if len(specs) == 0 {
+				r.Divergence = &Divergence{Trace: next.trace(), Return: ret, Expected: cf.expected(before)}
+				r.Bounded = cf.cut
+				return r
+			}
+			k := next.key()
+			if seen[k] {
+				continue
+			}
+			if cf.m.MaxStates > 0 && len(seen) >= cf.m.MaxStates {
+				cf.cut = true
+				break
+			}
+			seen[k] = true
+			queue = append(queue, next)
+		}
+	}
+	r.Bounded = cf.cut
+	return r
+}
+
+// closure returns the configurations reachable from cs by internal steps of
+// the specification, where s is the program state.
+func (cf *conformer) closure(s *state, cs []config) []config {
+	idx := uidIndex(s)
+	seen := make(map[string]bool)
+	var closed []config
+	for queue := cs; len(queue) > 0; {
+		c := queue[0]
+		queue = queue[1:]
+		if k := c.key(idx); seen[k] {
+			continue
+		} else {
+			seen[k] = true
+		}
+		if cf.m.MaxStates > 0 && len(closed) >= cf.m.MaxStates {
+			cf.cut = true
+			break
+		}
+		closed = append(closed, c)
+		ts, cut := cf.spec.successors(c.s)
+		cf.cut = cf.cut || cut
+		for _, t := range ts {
+			if _, ok := stepLabel(t.step); !ok {
+				queue = append(queue, config{s: t.next, rename: c.rename})
+			}
+		}
+	}
+	return closed
+}
+
+// match returns the configurations after a step of the specification from cs
+// with the visible action l of the program.
+func (cf *conformer) match(cs []config, l label) []config {
+	var matched []config
+	for _, c := range cs {
+		ts, _ := cf.spec.successors(c.s)
+		for _, t := range ts {
+			sl, ok := stepLabel(t.step)
+			if !ok || sl.kind != l.kind {
+				continue
+			}
+			switch l.kind {
+			case EventNewChan:
+				if sl.size != l.size {
+					continue
+				}
+				rename := make(map[int]int, len(c.rename)+1)
+				for from, to := range c.rename {
+					rename[from] = to
+				}
+				rename[l.ch] = sl.ch
+				matched = append(matched, config{s: t.next, rename: rename})
+			default:
+				if to, ok := c.rename[l.ch]; ok && to == sl.ch {
+					matched = append(matched, config{s: t.next, rename: c.rename})
+				}
+			}
+		}
+	}
+	return matched
+}
+
+// returned returns the configurations of cs where the entry has returned.
+func (cf *conformer) returned(cs []config) []config {
+	var done []config
+	for _, c := range cs {
+		if c.s.mainDone {
+			done = append(done, c)
+		}
+	}
+	return done
+}
+
+// expected returns the visible actions of the configurations cs, with the
+// channels named by the channels of the program (cN) if renamed.
+func (cf *conformer) expected(cs []config) []string {
+	seen := make(map[string]bool)
+	var actions []string
+	add := func(a string) {
+		if !seen[a] {
+			seen[a] = true
+			actions = append(actions, a)
+		}
+	}
+	for _, c := range cs {
+		inverse := make(map[int]int, len(c.rename))
+		for from, to := range c.rename {
+			inverse[to] = from
+		}
+		ts, _ := cf.spec.successors(c.s)
+		for _, t := range ts {
+			l, ok := stepLabel(t.step)
+			if !ok {
+				continue
+			}
+			if l.kind == EventNewChan {
+				add(fmt.Sprintf("newchan (size %d)", l.size))
+				continue
+			}
+			if from, ok := inverse[l.ch]; ok {
+				add(fmt.Sprintf("%s c%d", l.kind, from))
+			} else {
+				add(fmt.Sprintf("%s %s", l.kind, t.step.Events[0].Chan))
+			}
+		}
+		if c.s.mainDone {
+			add(string(labelReturn))
+		}
+	}
+	sort.Strings(actions)
+	return actions
+}
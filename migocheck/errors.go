@@ -0,0 +1,17 @@
+package migocheck
+
+import "github.com/pkg/errors"
+
+var (
+	// ErrNoEntry is the error if the entry definition is not in the program.
+	ErrNoEntry = errors.New("entry definition not found")
+
+	// ErrNoStep is the error if a step is taken but no step is enabled.
+	ErrNoStep = errors.New("no step enabled")
+
+	// ErrBadStep is the error if a step is not one of the enabled steps.
+	ErrBadStep = errors.New("step not enabled")
+
+	// ErrNoHistory is the error if a step is undone at the initial state.
+	ErrNoHistory = errors.New("no step to undo")
+)
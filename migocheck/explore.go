@@ -0,0 +1,139 @@
+package migocheck
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nickng/migo"
+)
+
+// visit is an explored state and the step from its parent.
+type visit struct {
+	s      *state
+	parent *visit
+	step   Step
+	depth  int
+}
+
+// trace returns the steps from the initial state to v.
+func (v *visit) trace() Trace {
+	trace := make(Trace, v.depth)
+	for ; v.parent != nil; v = v.parent {
+		trace[v.depth-1] = v.step
+	}
+	return trace
+}
+
+// explore explores the states from entry breadth first.
+func (m *machine) explore(entry *def) *Result {
+	r := &Result{Entry: entry.name}
+	init := &visit{s: m.initial(entry)}
+	init.s.normalise()
+	seen := map[string]bool{init.s.key(): true}
+	deadlocks := make(map[string]bool)
+	violations := make(map[violationKey]bool)
+	leaks := make(map[leakKey]bool)
+	queue := []*visit{init}
+	for len(queue) > 0 {
+		v := queue[0]
+		queue[0], queue = nil, queue[1:]
+		r.States++
+		ts, cut := m.successors(v.s)
+		if cut {
+			r.Bounded = true
+		}
+		switch {
+		case v.s.panic != nil && !v.s.mainDone:
+			m.violation(r, v, violations)
+		case len(ts) > 0 || cut || v.s.panic != nil:
+		case !v.s.mainDone:
+			m.deadlock(r, v, deadlocks)
+		case len(v.s.procs) > 0:
+			m.leak(r, v, leaks)
+		}
+		if m.MaxDepth > 0 && v.depth >= m.MaxDepth {
+			if len(ts) > 0 {
+				r.Bounded = true
+			}
+			continue
+		}
+		for _, t := range ts {
+			k := t.next.key()
+			if seen[k] {
+				continue
+			}
+			if m.MaxStates > 0 && len(seen) >= m.MaxStates {
+				r.Bounded = true
+				break
+			}
+			seen[k] = true
+			queue = append(queue, &visit{s: t.next, parent: v, step: t.step, depth: v.depth + 1})
+		}
+	}
+	if r.Unbound = m.unbound; len(r.Unbound) > 0 {
+		r.Bounded = true
+	}
+	return r
+}
+
+// deadlock records the deadlock state of v, unless a deadlock at the same
+// statements is recorded.
+func (m *machine) deadlock(r *Result, v *visit, seen map[string]bool) {
+	var at []string
+	for _, p := range v.s.procs {
+		f := p.stack[len(p.stack)-1]
+		at = append(at, fmt.Sprintf("%d.%d", f.b.id, f.pc))
+	}
+	sort.Strings(at)
+	if k := strings.Join(at, ","); !seen[k] {
+		seen[k] = true
+		d := &Deadlock{Trace: v.trace()}
+		d.Loop = approxLoop(d.Trace)
+		for _, p := range v.s.procs {
+			d.Blocked = append(d.Blocked, m.blockedAt(v.s, p))
+		}
+		r.Deadlocks = append(r.Deadlocks, d)
+	}
+}
+
+// violationKey is the operation and the close of a violation.
+type violationKey struct {
+	code      string
+	op, close migo.Statement
+}
+
+// violation records the violation of the state of v, unless a violation at the
+// same operation and close is recorded.
+func (m *machine) violation(r *Result, v *visit, seen map[violationKey]bool) {
+	k := violationKey{code: v.s.panic.Code, op: v.s.panic.Op.Stmt}
+	if v.s.panic.Close != nil {
+		k.close = v.s.panic.Close.Stmt
+	}
+	if !seen[k] {
+		seen[k] = true
+		violation := *v.s.panic
+		violation.Trace = v.trace()
+		violation.Loop = approxLoop(violation.Trace)
+		r.Violations = append(r.Violations, &violation)
+	}
+}
+
+// blockedAt returns the event of the next statement of p in s.
+func (m *machine) blockedAt(s *state, p *proc) Event {
+	n, f := p.head()
+	e := m.event(p, f, n.stmt, EventTau)
+	var name string
+	switch stmt := n.stmt.(type) {
+	case *migo.SendStatement:
+		e.Kind, name = EventSend, stmt.Chan
+	case *migo.RecvStatement:
+		e.Kind, name = EventRecv, stmt.Chan
+	case *migo.SelectStatement:
+		e.Kind = EventSelect
+	}
+	if ch, ok := f.env.lookup(name); ok {
+		e.setChan(s.chans[ch])
+	}
+	return e
+}
@@ -0,0 +1,294 @@
+package migocheck
+
+// Interpretation of MiGo.
+//
+// The interpreter runs a MiGo program by the semantics of the checker one step
+// at a time. The step is chosen among the enabled steps by the caller, e.g. to
+// explore a schedule by hand, or at random from a seeded source, e.g. for a
+// quick smoke test of the model. Steps can be undone to try another schedule.
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"io"
+	"math/rand"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Status is the status of an interpreted program.
+type Status int
+
+// Statuses of programs.
+const (
+	StatusRunning  Status = iota // Some steps are enabled.
+	StatusDone                   // All processes returned.
+	StatusDeadlock               // The entry is blocked forever.
+	StatusLeak                   // The entry returned, some goroutines are blocked forever.
+	StatusPanic                  // A channel operation panicked.
+	StatusBounded                // No step is enabled within the bounds.
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusRunning:
+		return "running"
+	case StatusDone:
+		return "done"
+	case StatusDeadlock:
+		return "deadlock"
+	case StatusLeak:
+		return "goroutine leak"
+	case StatusPanic:
+		return "panic"
+	case StatusBounded:
+		return "bounded"
+	}
+	return fmt.Sprintf("Status(%d)", int(s))
+}
+
+// ProcStatus is the status of a process.
+type ProcStatus struct {
+	ID      int      // ID of the process (0 is the entry).
+	Stack   []string // Definitions being executed, outermost first.
+	Next    Event    // Next statement of the process.
+	Spawn   *Event   // Spawn of the process (nil for the entry).
+	Enabled bool     // The process has an enabled step.
+}
+
+func (p ProcStatus) String() string {
+	s := fmt.Sprintf("#%d %s: %s", p.ID, p.Next.Func, eventText(p.Next))
+	if !p.Enabled {
+		s += " (blocked)"
+	}
+	return s
+}
+
+// ChanStatus is the status of a channel.
+type ChanStatus struct {
+	ID     int            // Unique ID of the channel in the trace.
+	Label  string         // Label of the channel.
+	Size   int            // Buffer size.
+	Count  int            // Number of values in the buffer.
+	Closed bool           // The channel is closed.
+	Nil    bool           // The channel is nil.
+	Make   token.Position // Source position of the newchan.
+}
+
+func (c ChanStatus) String() string {
+	s := fmt.Sprintf("c%d %s: %d/%d buffered", c.ID, strings.Replace(c.Label, `"`, "", -1), c.Count, c.Size)
+	switch {
+	case c.Nil:
+		s += ", nil"
+	case c.Closed:
+		s += ", closed"
+	}
+	return s
+}
+
+// Interpreter runs a MiGo program one step at a time.
+type Interpreter struct {
+	m     *machine
+	entry *def
+	rand  *rand.Rand
+
+	s       *state
+	trace   Trace
+	history []*state // States before the steps of the trace.
+	ts      []transition
+	cut     bool
+}
+
+// Interpreter returns an interpreter of the program from the entry definition,
+// with the random source seeded by 1. The bounds on processes, channels and
+// calls apply to the steps.
+func (c *Checker) Interpreter() (*Interpreter, error) {
+	m, err := newMachine(c.Prog, c.Config, c.Pos, c.Ctx, c.Approx, c.Bound)
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := m.lookup(c.Entry)
+	if !ok {
+		return nil, errors.Wrap(ErrNoEntry, c.Entry)
+	}
+	in := &Interpreter{m: m, entry: entry, rand: rand.New(rand.NewSource(1))}
+	in.Reset()
+	return in, nil
+}
+
+// SetSeed sets the seed of the random source of RandomStep and Run.
+func (in *Interpreter) SetSeed(seed int64) {
+	in.rand.Seed(seed)
+}
+
+// Reset sets the program to its initial state.
+func (in *Interpreter) Reset() {
+	s := in.m.initial(in.entry)
+	s.normalise()
+	in.trace, in.history = nil, nil
+	in.set(s)
+}
+
+// set sets the current state to s.
+func (in *Interpreter) set(s *state) {
+	in.s = s
+	in.ts, in.cut = in.m.successors(s)
+}
+
+// Status returns the status of the program.
+func (in *Interpreter) Status() Status {
+	switch {
+	case in.s.panic != nil:
+		return StatusPanic
+	case len(in.ts) > 0:
+		return StatusRunning
+	case in.cut:
+		return StatusBounded
+	case !in.s.mainDone:
+		return StatusDeadlock
+	case len(in.s.procs) > 0:
+		return StatusLeak
+	}
+	return StatusDone
+}
+
+// Panic returns the channel operation which panicked, or nil.
+func (in *Interpreter) Panic() *Violation {
+	return in.s.panic
+}
+
+// Steps returns the enabled steps.
+func (in *Interpreter) Steps() []Step {
+	steps := make([]Step, len(in.ts))
+	for i, t := range in.ts {
+		steps[i] = t.step
+	}
+	return steps
+}
+
+// Step takes the enabled step i (an index of Steps).
+func (in *Interpreter) Step(i int) error {
+	if len(in.ts) == 0 {
+		return ErrNoStep
+	}
+	if i < 0 || i >= len(in.ts) {
+		return errors.Wrapf(ErrBadStep, "step %d of %d", i, len(in.ts))
+	}
+	t := in.ts[i]
+	in.history = append(in.history, in.s)
+	in.trace = append(in.trace, t.step)
+	in.set(t.next)
+	return nil
+}
+
+// RandomStep takes an enabled step at random, and returns the step.
+func (in *Interpreter) RandomStep() (Step, error) {
+	if len(in.ts) == 0 {
+		return Step{}, ErrNoStep
+	}
+	i := in.rand.Intn(len(in.ts))
+	step := in.ts[i].step
+	return step, in.Step(i)
+}
+
+// Run takes random steps until no step is enabled or max steps are taken (0
+// means no limit), and returns the number of steps taken.
+func (in *Interpreter) Run(max int) int {
+	n := 0
+	for len(in.ts) > 0 && (max <= 0 || n < max) {
+		in.RandomStep()
+		n++
+	}
+	return n
+}
+
+// Back undoes the last step.
+func (in *Interpreter) Back() error {
+	if len(in.history) == 0 {
+		return ErrNoHistory
+	}
+	last := len(in.history) - 1
+	s := in.history[last]
+	in.history, in.trace = in.history[:last], in.trace[:last]
+	in.set(s)
+	return nil
+}
+
+// Trace returns the steps taken from the initial state.
+func (in *Interpreter) Trace() Trace {
+	return append(Trace(nil), in.trace...)
+}
+
+// Procs returns the status of the processes, in order of ID.
+func (in *Interpreter) Procs() []ProcStatus {
+	enabled := make(map[int]bool)
+	for _, t := range in.ts {
+		for _, e := range t.step.Events {
+			enabled[e.Proc] = true
+		}
+	}
+	var procs []ProcStatus
+	for _, p := range in.s.procs {
+		ps := ProcStatus{ID: p.id, Next: in.m.blockedAt(in.s, p), Spawn: p.spawn, Enabled: enabled[p.id]}
+		for _, f := range p.stack {
+			if len(ps.Stack) == 0 || ps.Stack[len(ps.Stack)-1] != f.b.def.name {
+				ps.Stack = append(ps.Stack, f.b.def.name)
+			}
+		}
+		procs = append(procs, ps)
+	}
+	return procs
+}
+
+// Chans returns the status of the channels referenced by the processes, in
+// order of reference.
+func (in *Interpreter) Chans() []ChanStatus {
+	var chans []ChanStatus
+	for _, c := range in.s.chans {
+		chans = append(chans, ChanStatus{
+			ID:     c.uid,
+			Label:  c.label,
+			Size:   c.size,
+			Count:  c.count,
+			Closed: c.closed,
+			Nil:    c.isNil,
+			Make:   c.made,
+		})
+	}
+	return chans
+}
+
+// WriteState writes the status, the processes and the channels as text to w.
+func (in *Interpreter) WriteState(w io.Writer) error {
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("%s after %d steps\n", in.Status(), len(in.trace)))
+	if v := in.s.panic; v != nil {
+		buf.WriteString(v.header())
+	}
+	if procs := in.Procs(); len(procs) > 0 {
+		buf.WriteString("goroutines:\n")
+		for _, p := range procs {
+			buf.WriteString(fmt.Sprintf("      %s\n", p))
+		}
+	}
+	if chans := in.Chans(); len(chans) > 0 {
+		buf.WriteString("channels:\n")
+		for _, c := range chans {
+			buf.WriteString(fmt.Sprintf("      %s\n", c))
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// WriteSteps writes the enabled steps, numbered by index, as text to w.
+func (in *Interpreter) WriteSteps(w io.Writer) error {
+	var buf bytes.Buffer
+	for i, t := range in.ts {
+		buf.WriteString(fmt.Sprintf("%4d  %s\n", i, t.step))
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
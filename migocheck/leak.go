@@ -0,0 +1,85 @@
+package migocheck
+
+// Goroutine leaks.
+//
+// A goroutine leaks if it is blocked forever while the rest of the program
+// terminates, e.g. a worker sending to a channel nobody receives from after a
+// timeout. A leak is a reachable state where the entry has returned, and the
+// remaining processes cannot make progress.
+
+import (
+	"fmt"
+
+	"github.com/nickng/gospal/migoinfer"
+	"github.com/nickng/migo"
+)
+
+// CodeLeak is the diagnostic code of goroutine leaks.
+const CodeLeak = "goroutine-leak"
+
+// Leak is a goroutine blocked forever.
+type Leak struct {
+	Spawn   Event  // Spawn of the goroutine.
+	Blocked Event  // Statement the goroutine is blocked at.
+	Trace   Trace  // Steps from the initial state to the leak.
+	Loop    *Event // First approximated loop of the trace, see Deadlock.
+}
+
+// Possible returns true if the leak is reached through an approximated loop.
+func (l *Leak) Possible() bool {
+	return l.Loop != nil
+}
+
+func (l *Leak) String() string {
+	return l.header() + "trace:\n" + l.Trace.String()
+}
+
+// header returns the description of the leak without its trace.
+func (l *Leak) header() string {
+	return possible(l.Loop) + fmt.Sprintf("goroutine leak, spawned at:\n      %s\nblocked at:\n      %s\n", l.Spawn, l.Blocked)
+}
+
+// leakKey is the spawn and the blocked statements of a leak.
+type leakKey struct {
+	spawn, blocked migo.Statement
+}
+
+// leak records the leaked processes of the state of v, unless a leak at the
+// same spawn and blocked statements is recorded.
+func (m *machine) leak(r *Result, v *visit, seen map[leakKey]bool) {
+	for _, p := range v.s.procs {
+		if p.spawn == nil {
+			continue
+		}
+		blocked := m.blockedAt(v.s, p)
+		k := leakKey{spawn: p.spawn.Stmt, blocked: blocked.Stmt}
+		if !seen[k] {
+			seen[k] = true
+			trace := v.trace()
+			r.Leaks = append(r.Leaks, &Leak{Spawn: *p.spawn, Blocked: blocked, Trace: trace, Loop: approxLoop(trace)})
+		}
+	}
+}
+
+// leakDiagnostics returns the leaks as diagnostics at the blocked statements.
+func (r *Result) leakDiagnostics() []migoinfer.Diagnostic {
+	var diags []migoinfer.Diagnostic
+	for _, l := range r.Leaks {
+		at := "-"
+		if l.Spawn.Pos.IsValid() {
+			at = l.Spawn.Pos.String()
+		}
+		diag := migoinfer.Diagnostic{
+			Severity: migoinfer.SeverityWarning,
+			Code:     CodeLeak,
+			Pos:      l.Blocked.Pos,
+			Func:     l.Blocked.Func,
+			Message:  fmt.Sprintf("goroutine blocked forever at %s, spawned at %s (%s in %s)", l.Blocked.action(), at, l.Spawn.action(), l.Spawn.Func),
+		}
+		if l.Possible() {
+			diag.Message = fmt.Sprintf("possible leak: %s, loop approximated at %s", diag.Message, shortPos(l.Loop.Pos))
+		}
+		diags = append(diags, diag)
+	}
+	return diags
+}
@@ -0,0 +1,46 @@
+package migocheck
+
+// Loops of MiGo.
+//
+// An ifFor statement is the entry of a loop inferred by migoinfer. If the
+// number of iterations of the loop is known (see Checker.SetLoopBounds) and
+// within MaxLoop, the iterations are counted per process so the branches are
+// taken as in the program. Otherwise the branches are a nondeterministic
+// choice which over-approximates the loop, and the problems reached through
+// the choice are possible problems.
+
+import "github.com/nickng/migo"
+
+// loopTrips returns the number of iterations of the loop of the ifFor
+// statement stmt, false if the loop does not have a constant bound or does not
+// exit within MaxLoop (0 means no limit) iterations.
+func (m *machine) loopTrips(stmt *migo.IfForStatement) (int64, bool) {
+	if m.bound == nil {
+		return 0, false
+	}
+	n, ok := m.bound(stmt)
+	if !ok || n < 0 || m.MaxLoop > 0 && n > int64(m.MaxLoop) {
+		return 0, false
+	}
+	return n, true
+}
+
+// approxLoop returns the first event of trace which approximates a loop, or
+// nil if no loop is approximated.
+func approxLoop(trace Trace) *Event {
+	for _, step := range trace {
+		if e := step.Events[0]; e.Approx {
+			return &e
+		}
+	}
+	return nil
+}
+
+// possible returns the header of a problem reached through the approximated
+// loop, or "" if loop is nil.
+func possible(loop *Event) string {
+	if loop == nil {
+		return ""
+	}
+	return "possible problem, loop approximated at:\n      " + loop.String() + "\n"
+}
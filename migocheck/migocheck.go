@@ -0,0 +1,188 @@
+// Package migocheck checks MiGo programs by bounded exploration of their state
+// space.
+//
+// A state of a MiGo program is the processes (the entry and the spawned
+// goroutines), each a stack of definition bodies being executed, and the
+// channels they share. Channels follow the Go semantics: sends and receives on
+// unbuffered channels synchronise, buffered channels are queues of their size,
+// receives on closed channels do not block, and operations on nil channels
+// block forever. The branches of if statements and the cases of select
+// statements are nondeterministic choices, where the default case (a tau
+// case) of a select is chosen only if no other case is ready. The loops
+// inferred with a constant bound (ifFor, see Checker.SetLoopBounds) iterate as
+// in the program, and the other loops (ifFor, or if statements approximating
+// loops, see Checker.SetLoopApprox) are nondeterministic choices, where the
+// problems reached through them are possible problems, which are reported but
+// do not fail the check (see Result.OK). The program ends when the entry
+// returns, but the spawned processes are explored further to find the
+// goroutines blocked forever (leaks).
+//
+// The exploration is breadth first, so the traces reported lead to their
+// states in the fewest steps. It is bounded by the number of states, the
+// length of traces, the number of processes and channels and the depth of
+// calls (see Config), and the result says if a bound is reached.
+package migocheck
+
+import (
+	"go/token"
+
+	"github.com/nickng/migo"
+	"github.com/pkg/errors"
+)
+
+// Config is the bounds of the exploration (0 means no limit).
+type Config struct {
+	MaxStates int // Maximum number of states explored.
+	MaxDepth  int // Maximum number of steps of a trace.
+	MaxProcs  int // Maximum number of processes of a state.
+	MaxChans  int // Maximum number of channels of a state.
+	MaxCalls  int // Maximum depth of calls of a process.
+	MaxLoop   int // Maximum iterations of a loop tracked exactly.
+}
+
+// DefaultConfig is the default bounds of the exploration.
+var DefaultConfig = Config{
+	MaxStates: 100000,
+	MaxDepth:  1000,
+	MaxProcs:  32,
+	MaxChans:  32,
+	MaxCalls:  64,
+	MaxLoop:   64,
+}
+
+// PosFunc returns the source position of a MiGo statement.
+type PosFunc func(migo.Statement) (token.Position, bool)
+
+// ContextFunc returns the call-context path of a MiGo spawn statement, i.e.
+// the functions called from the entry to the spawning function.
+type ContextFunc func(migo.Statement) []string
+
+// ApproxFunc returns true if a MiGo if statement approximates a loop, e.g. a
+// loop with unknown bound inferred by migoinfer.
+type ApproxFunc func(migo.Statement) bool
+
+// BoundFunc returns the number of iterations of the loop of a MiGo ifFor
+// statement, false if the loop does not have a constant bound, e.g. the trip
+// count of a loop inferred by migoinfer.
+type BoundFunc func(migo.Statement) (int64, bool)
+
+// Checker checks a MiGo program.
+type Checker struct {
+	Prog   *migo.Program // MiGo program.
+	Entry  string        // Name of the entry definition.
+	Pos    PosFunc       // Source positions of statements (nil if unknown).
+	Ctx    ContextFunc   // Call-context paths of spawns (nil if unknown).
+	Approx ApproxFunc    // If statements approximating loops (nil if none).
+	Bound  BoundFunc     // Iterations of ifFor loops (nil if unknown).
+	Config
+}
+
+// New returns a new Checker of prog with the default bounds, where the entry
+// definition is main.main.
+func New(prog *migo.Program) *Checker {
+	return &Checker{Prog: prog, Entry: "main.main", Config: DefaultConfig}
+}
+
+// SetEntry sets the name of the entry definition.
+func (c *Checker) SetEntry(name string) {
+	c.Entry = name
+}
+
+// SetPositions sets the source positions of statements, e.g. the positions
+// of the statements inferred by migoinfer.
+func (c *Checker) SetPositions(pos PosFunc) {
+	c.Pos = pos
+}
+
+// SetSpawnContexts sets the call-context paths of spawn statements, e.g. the
+// paths of the spawns inferred by migoinfer.
+func (c *Checker) SetSpawnContexts(ctx ContextFunc) {
+	c.Ctx = ctx
+}
+
+// SetLoopApprox sets the if statements which approximate loops, e.g. the
+// statements of loops inferred by migoinfer, which are explored as the ifFor
+// statements of loops without a constant bound.
+func (c *Checker) SetLoopApprox(approx ApproxFunc) {
+	c.Approx = approx
+}
+
+// SetLoopBounds sets the number of iterations of ifFor loops with a constant
+// bound, e.g. the trip counts of loops inferred by migoinfer. The other ifFor
+// loops are explored as nondeterministic choices.
+func (c *Checker) SetLoopBounds(bound BoundFunc) {
+	c.Bound = bound
+}
+
+// SetConfig sets the bounds of the exploration.
+func (c *Checker) SetConfig(conf Config) {
+	c.Config = conf
+}
+
+// Result is the result of checking a MiGo program.
+type Result struct {
+	Entry     string      // Name of the entry definition.
+	States    int         // Number of states explored.
+	Bounded   bool        // Some states are not explored because of the bounds.
+	Deadlocks []*Deadlock // Deadlocks found, by length of trace.
+
+	Violations []*Violation // Channel operations which panic, by length of trace.
+	Leaks      []*Leak      // Goroutines blocked forever, by length of trace.
+
+	// Unbound is the operations on names not bound to a channel, e.g. the
+	// parameters of the entry. They are explored as if they succeed, so the
+	// result is Bounded.
+	Unbound []Event
+}
+
+// OK returns true if no problem is found, the possible problems (reached
+// through approximated loops) are not problems.
+func (r *Result) OK() bool {
+	for _, d := range r.Deadlocks {
+		if !d.Possible() {
+			return false
+		}
+	}
+	for _, v := range r.Violations {
+		if !v.Possible() {
+			return false
+		}
+	}
+	for _, l := range r.Leaks {
+		if !l.Possible() {
+			return false
+		}
+	}
+	return true
+}
+
+// Deadlock is a reachable state where the entry has not returned but no
+// process can make progress.
+type Deadlock struct {
+	Trace   Trace   // Steps from the initial state to the deadlock.
+	Blocked []Event // Statements the processes are blocked at.
+
+	// Loop is the first loop of the trace whose branch is approximated, i.e.
+	// the deadlock is possible but may not be reachable in the program.
+	Loop *Event
+}
+
+// Possible returns true if the deadlock is reached through an approximated
+// loop.
+func (d *Deadlock) Possible() bool {
+	return d.Loop != nil
+}
+
+// Check explores the state space of the program from the entry definition
+// and returns the problems found.
+func (c *Checker) Check() (*Result, error) {
+	m, err := newMachine(c.Prog, c.Config, c.Pos, c.Ctx, c.Approx, c.Bound)
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := m.lookup(c.Entry)
+	if !ok {
+		return nil, errors.Wrap(ErrNoEntry, c.Entry)
+	}
+	return m.explore(entry), nil
+}
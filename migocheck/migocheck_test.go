@@ -0,0 +1,705 @@
+package migocheck_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/nickng/gospal/migocheck"
+	"github.com/nickng/gospal/migoinfer"
+	"github.com/nickng/gospal/ssa/build"
+	"github.com/nickng/migo"
+	"github.com/nickng/migo/parser"
+	"github.com/pkg/errors"
+)
+
+func parse(t *testing.T, s string) *migo.Program {
+	prog, err := parser.Parse(strings.NewReader(s))
+	if err != nil {
+		t.Fatalf("cannot parse MiGo: %v", err)
+	}
+	return prog
+}
+
+func check(t *testing.T, s string, conf migocheck.Config) *migocheck.Result {
+	checker := migocheck.New(parse(t, s))
+	checker.SetConfig(conf)
+	result, err := checker.Check()
+	if err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+	return result
+}
+
+// infer returns the MiGo inferred from the Go source file and its inferer.
+func infer(t *testing.T, file string) (*migo.Program, *migoinfer.Inferer) {
+	info, err := build.FromFiles(file).Default().Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	inferer := migoinfer.New(info, nil)
+	prog, err := inferer.Analyse()
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+	return prog, inferer
+}
+
+// TestDeadlock tests deadlock detection and the minimal traces.
+func TestDeadlock(t *testing.T) {
+	tests := []struct {
+		name    string
+		migo    string
+		blocked []string // Blocked statements of the deadlock, if any.
+		steps   int      // Steps of the trace.
+	}{
+		{
+			name: "Sync",
+			migo: `def main.main(): let ch = newchan ch, 0; spawn main.s(ch); recv ch;
+			def main.s(ch): send ch;`,
+		},
+		{
+			name:    "Recv",
+			migo:    `def main.main(): let ch = newchan ch, 0; spawn main.s(ch); recv ch; recv ch; def main.s(ch): send ch;`,
+			blocked: []string{"#0 main.main: recv ch"},
+			steps:   3,
+		},
+		{
+			name: "Buffered",
+			migo: `def main.main(): let ch = newchan ch, 1; send ch; recv ch;`,
+		},
+		{
+			name:    "BufferFull",
+			migo:    `def main.main(): let ch = newchan ch, 1; send ch; send ch;`,
+			blocked: []string{"#0 main.main: send ch"},
+			steps:   2,
+		},
+		{
+			name: "Closed",
+			migo: `def main.main(): let ch = newchan ch, 0; close ch; recv ch;`,
+		},
+		{
+			name:    "Nil",
+			migo:    `def main.main(): let ch = newchan nilchan, 0; send ch;`,
+			blocked: []string{"#0 main.main: send ch"},
+			steps:   1,
+		},
+		{
+			name: "SelectDefault",
+			migo: `def main.main(): let ch = newchan ch, 0; select case recv ch; case tau; endselect;`,
+		},
+		{
+			name:    "Select",
+			migo:    `def main.main(): let ch = newchan ch, 0; select case recv ch; case send ch; endselect;`,
+			blocked: []string{"#0 main.main: select"},
+			steps:   1,
+		},
+		{
+			name:    "Branch",
+			migo:    `def main.main(): let ch = newchan ch, 0; spawn main.s(ch); if recv ch; else tau; endif; recv ch; def main.s(ch): send ch;`,
+			blocked: []string{"#0 main.main: recv ch"},
+			steps:   4,
+		},
+		{
+			name: "Loop",
+			migo: `def main.main(): let ch = newchan ch, 0; spawn main.s(ch); call main.r(ch);
+			def main.s(ch): send ch; call main.s(ch);
+			def main.r(ch): recv ch; call main.r(ch);`,
+		},
+		{
+			name: "Leak", // Not a deadlock, main.main returns.
+			migo: `def main.main(): let ch = newchan ch, 0; spawn main.s(ch); def main.s(ch): send ch;`,
+		},
+		{
+			name:    "Both",
+			migo:    `def main.main(): let a = newchan a, 0; let b = newchan b, 0; spawn main.s(a, b); recv a; send b; def main.s(a, b): recv b; send a;`,
+			blocked: []string{"#0 main.main: recv a", "#1 main.s: recv b"},
+			steps:   3,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := check(t, test.migo, migocheck.DefaultConfig)
+			if result.Bounded {
+				t.Errorf("expects exploration within bounds")
+			}
+			if test.blocked == nil {
+				if len(result.Deadlocks) > 0 {
+					t.Errorf("expects no deadlock but got:\n%s", result.Deadlocks[0])
+				}
+				return
+			}
+			if len(result.Deadlocks) != 1 {
+				t.Fatalf("expects 1 deadlock but got %d", len(result.Deadlocks))
+			}
+			d := result.Deadlocks[0]
+			var blocked []string
+			for _, e := range d.Blocked {
+				blocked = append(blocked, e.String())
+			}
+			if strings.Join(blocked, "\n") != strings.Join(test.blocked, "\n") {
+				t.Errorf("expects blocked at %v but got %v", test.blocked, blocked)
+			}
+			if len(d.Trace) != test.steps {
+				t.Errorf("expects trace of %d steps but got:\n%s", test.steps, d.Trace)
+			}
+		})
+	}
+}
+
+// TestMinimalTrace tests that the deadlocks are found by length of trace.
+func TestMinimalTrace(t *testing.T) {
+	result := check(t, `def main.main(): let ch = newchan ch, 0; if tau; tau; tau; send ch; else send ch; endif;`, migocheck.DefaultConfig)
+	if len(result.Deadlocks) != 2 {
+		t.Fatalf("expects 2 deadlocks but got %d", len(result.Deadlocks))
+	}
+	if trace := result.Deadlocks[0].Trace; len(trace) != 2 || trace[1].Events[0].Choice != "else" {
+		t.Errorf("expects deadlock through else branch but got:\n%s", trace)
+	}
+}
+
+// TestBounds tests that exploration stops at the bounds.
+func TestBounds(t *testing.T) {
+	spawner := `def main.main(): let ch = newchan ch, 0; call main.f(ch); recv ch;
+	def main.f(ch): spawn main.s(ch); call main.f(ch);
+	def main.s(ch): send ch;`
+	conf := migocheck.DefaultConfig
+	conf.MaxProcs = 4
+	if result := check(t, spawner, conf); !result.Bounded || !result.OK() {
+		t.Errorf("expects bounded exploration without deadlock but got %+v", result)
+	}
+	conf = migocheck.DefaultConfig
+	conf.MaxStates = 2
+	if result := check(t, spawner, conf); !result.Bounded || result.States > 2 {
+		t.Errorf("expects at most 2 states explored but got %d", result.States)
+	}
+}
+
+// TestLoop tests that loops with a constant bound are followed exactly, and
+// the problems found through other loops are possible problems.
+func TestLoop(t *testing.T) {
+	// The parser does not support ifFor, the loops are written as if and
+	// replaced by ifFor with the loop conditions and trip counts (negative if
+	// the bound is unknown).
+	loops := func(conds []string, trips ...int64) *migocheck.Result {
+		prog := parse(t, `def main.main(): let ch = newchan ch, 0; spawn main.s(ch); call main.r(ch);
+		def main.s(ch): if send ch; call main.s(ch); else tau; endif;
+		def main.r(ch): if recv ch; call main.r(ch); else tau; endif;`)
+		bounds := make(map[migo.Statement]int64)
+		for i, name := range []string{"main.s", "main.r"} {
+			fn, _ := prog.Function(name)
+			stmt := fn.Stmts[0].(*migo.IfStatement)
+			fn.Stmts[0] = &migo.IfForStatement{ForCond: conds[i], Then: stmt.Then, Else: stmt.Else}
+			if trips[i] >= 0 {
+				bounds[fn.Stmts[0]] = trips[i]
+			}
+		}
+		checker := migocheck.New(prog)
+		checker.SetLoopBounds(func(s migo.Statement) (int64, bool) {
+			n, ok := bounds[s]
+			return n, ok
+		})
+		result, err := checker.Check()
+		if err != nil {
+			t.Fatalf("check failed: %v", err)
+		}
+		return result
+	}
+	if result := loops([]string{"i = 0; (i<3); i = i + 1", "j = 0; (j<3); j = j + 1"}, 3, 3); !result.OK() || len(result.Deadlocks)+len(result.Leaks) != 0 {
+		t.Errorf("expects no problem with matching loops but got %+v", result)
+	}
+	result := loops([]string{"i = 0; (i<3); i = i + 1", "j = 4; (j>0); j = j - 1"}, 3, 4)
+	if len(result.Deadlocks) != 1 || result.Deadlocks[0].Possible() || result.OK() {
+		t.Errorf("expects 1 deadlock with mismatched loops but got %+v", result)
+	}
+	if result := loops([]string{"i = 0; (i<3); i = i + 1", "j = 0; (j<n); j = j + 1"}, 3, -1); len(result.Deadlocks) == 0 || !result.OK() {
+		t.Errorf("expects possible deadlocks with unknown bound but got %+v", result)
+	}
+
+	prog, inferer := infer(t, "testdata/varloop/main.go")
+	checker := migocheck.New(prog)
+	checker.SetPositions(inferer.StmtPosition)
+	result, err := checker.Check()
+	if err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+	if len(result.Deadlocks) == 0 || !result.OK() {
+		t.Fatalf("expects possible deadlocks but got %+v", result)
+	}
+	for _, d := range result.Deadlocks {
+		if !d.Possible() || d.Loop.Pos.Line != 15 {
+			t.Errorf("expects deadlock through loop at line 15 but got %s", d)
+		}
+	}
+	for _, diag := range result.Diagnostics() {
+		if diag.Severity != migoinfer.SeverityWarning || !strings.HasPrefix(diag.Message, "possible") {
+			t.Errorf("expects possible problem warning but got %v", diag)
+		}
+	}
+
+	prog, inferer = infer(t, "testdata/constloop/main.go")
+	checker = migocheck.New(prog)
+	checker.SetLoopBounds(inferer.LoopBound)
+	if result, err = checker.Check(); err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+	if len(result.Deadlocks) != 1 || result.Deadlocks[0].Possible() || result.OK() {
+		t.Errorf("expects 1 deadlock with inferred loop bounds but got %+v", result)
+	}
+}
+
+// TestUnbound tests that operations on unbound channels are reported and the
+// result is bounded.
+func TestUnbound(t *testing.T) {
+	result := check(t, `def main.main(a): recv a; call main.f(a); def main.f(b): close b;`, migocheck.DefaultConfig)
+	if !result.Bounded || len(result.Unbound) != 2 {
+		t.Fatalf("expects 2 operations on unbound channels but got %+v", result)
+	}
+	if e := result.Unbound[0]; e.Kind != migocheck.EventRecv || e.Chan != "a" {
+		t.Errorf("expects recv a but got %s", e)
+	}
+	diags := result.Diagnostics()
+	if len(diags) != 2 || diags[1].Code != migocheck.CodeUnbound || !strings.Contains(diags[1].Message, "close on unbound channel b") {
+		t.Errorf("expects unbound channel diagnostics but got %v", diags)
+	}
+	var buf bytes.Buffer
+	if err := result.WriteText(&buf); err != nil {
+		t.Fatalf("cannot write result: %v", err)
+	}
+	if strings.Contains(buf.String(), "no problem found\n") {
+		t.Errorf("expects unbound channels in text but got:\n%s", buf.String())
+	}
+}
+
+// TestNoEntry tests checking without the entry definition.
+func TestNoEntry(t *testing.T) {
+	checker := migocheck.New(parse(t, `def main.f(): tau;`))
+	if _, err := checker.Check(); err == nil {
+		t.Errorf("expects error without main.main")
+	}
+	checker.SetEntry("main.f")
+	if result, err := checker.Check(); err != nil || !result.OK() {
+		t.Errorf("expects main.f checked without problem but got %v, %v", result, err)
+	}
+}
+
+// TestInferred tests checking MiGo inferred from Go.
+func TestInferred(t *testing.T) {
+	tests := []struct {
+		dir string
+		ok  bool
+	}{
+		{dir: "srcmap", ok: true},
+		{dir: "for-select", ok: true},
+		{dir: "recv", ok: false},
+		{dir: "multi-return", ok: true},
+		{dir: "carried-param", ok: true},
+		{dir: "stable/v1", ok: true},
+		{dir: "spawn-loop", ok: true},
+	}
+	for _, test := range tests {
+		t.Run(test.dir, func(t *testing.T) {
+			prog, inferer := infer(t, path.Join("../migoinfer/testdata", test.dir, "main.go"))
+			checker := migocheck.New(prog)
+			checker.SetLoopApprox(inferer.LoopApprox)
+			checker.SetLoopBounds(inferer.LoopBound)
+			result, err := checker.Check()
+			if err != nil {
+				t.Fatalf("check failed: %v", err)
+			}
+			if result.OK() != test.ok {
+				t.Errorf("expects OK=%t but got:\n%+v", test.ok, result.Deadlocks)
+			}
+		})
+	}
+}
+
+// TestSafety tests detection of channel operations which panic.
+func TestSafety(t *testing.T) {
+	tests := []struct {
+		name  string
+		migo  string
+		codes []string
+	}{
+		{
+			name:  "SendClosed",
+			migo:  `def main.main(): let ch = newchan ch, 1; close ch; send ch;`,
+			codes: []string{migocheck.CodeSendClosed},
+		},
+		{
+			name:  "CloseClosed",
+			migo:  `def main.main(): let ch = newchan ch, 0; spawn main.c(ch); close ch; def main.c(ch): close ch;`,
+			codes: []string{migocheck.CodeCloseClosed}, // main.main returns after close.
+		},
+		{
+			name:  "CloseNil",
+			migo:  `def main.main(): let ch = newchan nilchan, 0; close ch;`,
+			codes: []string{migocheck.CodeCloseNil},
+		},
+		{
+			name:  "SelectSendClosed",
+			migo:  `def main.main(): let ch = newchan ch, 0; close ch; select case send ch; case tau; endselect;`,
+			codes: []string{migocheck.CodeSendClosed},
+		},
+		{
+			name: "RecvClosed",
+			migo: `def main.main(): let ch = newchan ch, 0; close ch; recv ch; recv ch;`,
+		},
+		{
+			name: "CloseOnce",
+			migo: `def main.main(): let ch = newchan ch, 0; spawn main.c(ch); recv ch; def main.c(ch): close ch;`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := check(t, test.migo, migocheck.DefaultConfig)
+			var codes []string
+			for _, v := range result.Violations {
+				codes = append(codes, v.Code)
+				if last := v.Trace[len(v.Trace)-1].Events[0]; last.Stmt != v.Op.Stmt {
+					t.Errorf("expects trace ending at %s but got %s", v.Op, last)
+				}
+				if (v.Code == migocheck.CodeCloseNil) != (v.Close == nil) {
+					t.Errorf("unexpected close of %s violation: %v", v.Code, v.Close)
+				}
+			}
+			if strings.Join(codes, ",") != strings.Join(test.codes, ",") {
+				t.Errorf("expects violations %v but got %v", test.codes, codes)
+			}
+			if result.OK() != (len(test.codes) == 0) {
+				t.Errorf("expects OK=%t", len(test.codes) == 0)
+			}
+		})
+	}
+}
+
+// TestSafetyDiagnostics tests the source positions of the diagnostics of
+// violations in inferred MiGo.
+func TestSafetyDiagnostics(t *testing.T) {
+	prog, inferer := infer(t, "testdata/closed/main.go")
+	checker := migocheck.New(prog)
+	checker.SetPositions(inferer.StmtPosition)
+	result, err := checker.Check()
+	if err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+	diags := result.Diagnostics()
+	if len(diags) != 2 {
+		t.Fatalf("expects 2 diagnostics but got %v", diags)
+	}
+	tests := []struct {
+		code      string
+		line      int
+		closeLine int
+	}{
+		{code: migocheck.CodeSendClosed, line: 4, closeLine: 12},
+		{code: migocheck.CodeCloseClosed, line: 14, closeLine: 5},
+	}
+	for i, test := range tests {
+		v := result.Violations[i]
+		if v.Code != test.code || v.Op.Pos.Line != test.line || v.Close == nil || v.Close.Pos.Line != test.closeLine {
+			t.Errorf("expects %s at line %d closed at line %d but got %s", test.code, test.line, test.closeLine, v)
+		}
+		if d := diags[i]; d.Code != test.code || d.Pos.Line != test.line || d.Severity != migoinfer.SeverityError {
+			t.Errorf("expects %s diagnostic at line %d but got %s", test.code, test.line, d)
+		}
+	}
+}
+
+// TestLeak tests detection of goroutines blocked forever.
+func TestLeak(t *testing.T) {
+	tests := []struct {
+		name   string
+		migo   string
+		leaks  []string // Blocked statements of leaks.
+		spawns []string // Spawn statements of leaks.
+	}{
+		{
+			name:   "Timeout",
+			migo:   `def main.main(): let t = newchan t, 0; close t; let ch = newchan ch, 0; spawn main.w(ch); select case recv ch; case recv t; endselect; def main.w(ch): send ch;`,
+			leaks:  []string{"#1 main.w: send ch"},
+			spawns: []string{"#0 main.main: spawn main.w(ch)"},
+		},
+		{
+			name: "Buffered",
+			migo: `def main.main(): let t = newchan t, 0; close t; let ch = newchan ch, 1; spawn main.w(ch); select case recv ch; case recv t; endselect; def main.w(ch): send ch;`,
+		},
+		{
+			name: "Finish", // Spawned processes finish after main.main returns.
+			migo: `def main.main(): let ch = newchan ch, 0; spawn main.w(ch); spawn main.r(ch); def main.w(ch): send ch; def main.r(ch): recv ch;`,
+		},
+		{
+			name:   "Nested",
+			migo:   `def main.main(): let ch = newchan ch, 0; spawn main.w(ch); def main.w(ch): spawn main.r(ch); recv ch; recv ch; def main.r(ch): send ch;`,
+			leaks:  []string{"#1 main.w: recv ch"},
+			spawns: []string{"#0 main.main: spawn main.w(ch)"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := check(t, test.migo, migocheck.DefaultConfig)
+			if len(result.Deadlocks) > 0 {
+				t.Errorf("expects no deadlock but got:\n%s", result.Deadlocks[0])
+			}
+			var leaks, spawns []string
+			for _, l := range result.Leaks {
+				leaks = append(leaks, l.Blocked.String())
+				spawns = append(spawns, l.Spawn.String())
+			}
+			if strings.Join(leaks, "\n") != strings.Join(test.leaks, "\n") || strings.Join(spawns, "\n") != strings.Join(test.spawns, "\n") {
+				t.Errorf("expects leaks %v spawned at %v but got %v spawned at %v", test.leaks, test.spawns, leaks, spawns)
+			}
+		})
+	}
+}
+
+// TestLeakDiagnostics tests the source positions of the diagnostics of leaks
+// in inferred MiGo.
+func TestLeakDiagnostics(t *testing.T) {
+	prog, inferer := infer(t, "testdata/leak/main.go")
+	checker := migocheck.New(prog)
+	checker.SetPositions(inferer.StmtPosition)
+	result, err := checker.Check()
+	if err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+	if len(result.Leaks) != 1 {
+		t.Fatalf("expects 1 leak but got %d", len(result.Leaks))
+	}
+	l := result.Leaks[0]
+	if l.Spawn.Pos.Line != 9 || l.Blocked.Pos.Line != 4 || l.Blocked.Kind != migocheck.EventSend {
+		t.Errorf("expects leak spawned at line 9 blocked at send at line 4 but got %s", l)
+	}
+	if len(l.Trace) == 0 || l.Trace[len(l.Trace)-1].Events[0].Choice != "case 1" {
+		t.Errorf("expects trace ending with timeout case but got:\n%s", l.Trace)
+	}
+	diags := result.Diagnostics()
+	if len(diags) != 1 || diags[0].Code != migocheck.CodeLeak || diags[0].Pos.Line != 4 {
+		t.Errorf("expects leak diagnostic at line 4 but got %v", diags)
+	}
+}
+
+// TestTraceView tests the goroutine and channel identities of the trace of a
+// leak in inferred MiGo, and its sequence diagrams.
+func TestTraceView(t *testing.T) {
+	prog, inferer := infer(t, "testdata/leak/main.go")
+	checker := migocheck.New(prog)
+	checker.SetPositions(inferer.StmtPosition)
+	checker.SetSpawnContexts(inferer.SpawnContext)
+	result, err := checker.Check()
+	if err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+	if len(result.Leaks) != 1 {
+		t.Fatalf("expects 1 leak but got %d", len(result.Leaks))
+	}
+	v := migocheck.NewTraceView(result.Entry, result.Leaks[0].Trace)
+	if len(v.Goroutines) != 2 {
+		t.Fatalf("expects 2 goroutines but got %v", v.Goroutines)
+	}
+	if g := v.Goroutines[1]; g.Func != "main.work" || g.Parent != 0 || g.Spawn.Line != 9 || strings.Join(g.Context, " > ") != "main.main > main.fetch" {
+		t.Errorf("expects main.work spawned by #0 at line 9 in main.main > main.fetch but got %s", g)
+	}
+	if len(v.Channels) != 2 || v.Channels[1].Make.Line != 8 {
+		t.Errorf("expects 2 channels, the second made at line 8 but got %v", v.Channels)
+	}
+	var buf bytes.Buffer
+	if err := v.WriteMermaid(&buf); err != nil {
+		t.Fatalf("cannot write mermaid: %v", err)
+	}
+	if s := buf.String(); !strings.HasPrefix(s, "sequenceDiagram\n") || !strings.Contains(s, "g0->>g1: spawn main.work(t0) (main.go:9:2)") || strings.ContainsAny(s, "#;") {
+		t.Errorf("unexpected mermaid diagram:\n%s", s)
+	}
+	buf.Reset()
+	if err := v.WritePlantUML(&buf); err != nil {
+		t.Fatalf("cannot write plantuml: %v", err)
+	}
+	if s := buf.String(); !strings.HasPrefix(s, "@startuml\n") || !strings.HasSuffix(s, "@enduml\n") || !strings.Contains(s, "g0 -> g1 : spawn main.work(t0)") {
+		t.Errorf("unexpected plantuml diagram:\n%s", s)
+	}
+	buf.Reset()
+	if err := result.WriteJSON(&buf); err != nil {
+		t.Fatalf("cannot write json: %v", err)
+	}
+	var decoded struct {
+		Problems []struct {
+			Code  string
+			Trace struct {
+				Goroutines []struct {
+					Func    string
+					Context []string
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("cannot decode json: %v", err)
+	}
+	if len(decoded.Problems) != 1 || decoded.Problems[0].Code != migocheck.CodeLeak || len(decoded.Problems[0].Trace.Goroutines) != 2 {
+		t.Errorf("unexpected json result:\n%s", buf.String())
+	}
+}
+
+// TestInterpreter tests stepping through a schedule, undoing steps and the
+// status of processes and channels.
+func TestInterpreter(t *testing.T) {
+	in, err := migocheck.New(parse(t, `def main.main(): let ch = newchan ch, 1; send ch; spawn main.r(ch); send ch; send ch; def main.r(ch): recv ch;`)).Interpreter()
+	if err != nil {
+		t.Fatalf("cannot run: %v", err)
+	}
+	for i := 0; i < 3; i++ { // newchan, send, spawn.
+		if err := in.Step(0); err != nil {
+			t.Fatalf("cannot take step %d: %v", i, err)
+		}
+	}
+	if chans := in.Chans(); len(chans) != 1 || chans[0].Count != 1 || chans[0].Size != 1 {
+		t.Errorf("expects channel with 1/1 buffered but got %v", chans)
+	}
+	if procs := in.Procs(); len(procs) != 2 || procs[0].Next.Kind != migocheck.EventSend || procs[0].Enabled || !procs[1].Enabled {
+		t.Errorf("expects #0 blocked at send and #1 enabled but got %v", procs)
+	}
+	if err := in.Step(1); errors.Cause(err) != migocheck.ErrBadStep {
+		t.Errorf("expects step 1 not enabled but got %v", err)
+	}
+	if n := in.Run(0); n != 2 || in.Status() != migocheck.StatusDeadlock {
+		t.Errorf("expects deadlock after 2 steps but got %s after %d steps", in.Status(), n)
+	}
+	if err := in.Step(0); err != migocheck.ErrNoStep {
+		t.Errorf("expects no step enabled but got %v", err)
+	}
+	if err := in.Back(); err != nil || in.Status() != migocheck.StatusRunning || len(in.Trace()) != 4 {
+		t.Errorf("expects running after undoing to 4 steps but got %s after %d steps (%v)", in.Status(), len(in.Trace()), err)
+	}
+	in.Reset()
+	if err := in.Back(); err != migocheck.ErrNoHistory {
+		t.Errorf("expects no step to undo after reset but got %v", err)
+	}
+}
+
+// TestInterpreterSeed tests that random runs of the same seed take the same
+// steps.
+func TestInterpreterSeed(t *testing.T) {
+	prog := parse(t, `def main.main(): let ch = newchan ch, 0; spawn main.s(ch); spawn main.s(ch); recv ch; recv ch; def main.s(ch): send ch;`)
+	var traces []string
+	for i := 0; i < 2; i++ {
+		in, err := migocheck.New(prog).Interpreter()
+		if err != nil {
+			t.Fatalf("cannot run: %v", err)
+		}
+		in.SetSeed(42)
+		in.Run(0)
+		if in.Status() != migocheck.StatusDone {
+			t.Errorf("expects done but got %s", in.Status())
+		}
+		traces = append(traces, in.Trace().String())
+	}
+	if traces[0] != traces[1] {
+		t.Errorf("expects same trace of the same seed but got:\n%s\nand:\n%s", traces[0], traces[1])
+	}
+}
+
+// TestConform tests conformance to specifications, with channels renamed by
+// their newchan.
+func TestConform(t *testing.T) {
+	tests := []struct {
+		name     string
+		migo     string
+		spec     string
+		diverges string   // Diverging action of the program, if any.
+		expected []string // Expected actions of the spec.
+	}{
+		{
+			name: "Renamed definitions and channels",
+			migo: `def main.main(): let a = newchan a, 0; spawn main.s(a); recv a; def main.s(a): send a;`,
+			spec: `def main.main(): let x = newchan x, 0; spawn main.w(x); call main.r(x); def main.r(y): recv y; def main.w(y): send y;`,
+		},
+		{
+			name: "Spec with more branches",
+			migo: `def main.main(): let a = newchan a, 1; send a;`,
+			spec: `def main.main(): let x = newchan x, 1; if send x; else close x; endif;`,
+		},
+		{
+			name:     "Changed buffer size",
+			migo:     `def main.main(): let a = newchan a, 1; send a;`,
+			spec:     `def main.main(): let x = newchan x, 0; spawn main.r(x); send x; def main.r(x): recv x;`,
+			diverges: "#0 main.main: let a = newchan a, 1",
+			expected: []string{"newchan (size 0)"},
+		},
+		{
+			name:     "Extra branch",
+			migo:     `def main.main(): let a = newchan a, 1; if send a; else close a; endif;`,
+			spec:     `def main.main(): let x = newchan x, 1; send x;`,
+			diverges: "#0 main.main: close a",
+			expected: []string{"send c0"},
+		},
+		{
+			name:     "Swapped channels",
+			migo:     `def main.main(): let a = newchan a, 1; let b = newchan b, 1; send a;`,
+			spec:     `def main.main(): let x = newchan x, 1; let y = newchan y, 1; send y;`,
+			diverges: "#0 main.main: send a",
+			expected: []string{"send c1"},
+		},
+		{
+			name:     "Early return",
+			migo:     `def main.main(): let a = newchan a, 1; send a;`,
+			spec:     `def main.main(): let x = newchan x, 1; send x; recv x;`,
+			diverges: "#0 main.main: send a",
+			expected: []string{"recv c0"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checker := migocheck.New(parse(t, test.migo))
+			r, err := checker.Conform(parse(t, test.spec), "main.main")
+			if err != nil {
+				t.Fatalf("conformance check failed: %v", err)
+			}
+			if test.diverges == "" {
+				if !r.OK() {
+					t.Errorf("expects conformance but got divergence at %s", r.Divergence.Step())
+				}
+				return
+			}
+			if r.OK() {
+				t.Fatalf("expects divergence at %s but conforms", test.diverges)
+			}
+			if step := r.Divergence.Step().String(); step != test.diverges || strings.Join(r.Divergence.Expected, ",") != strings.Join(test.expected, ",") {
+				t.Errorf("expects divergence at %s expecting %v but got %s expecting %v", test.diverges, test.expected, step, r.Divergence.Expected)
+			}
+		})
+	}
+}
+
+// TestConformInferred tests the source position of the divergence of inferred
+// MiGo from a specification.
+func TestConformInferred(t *testing.T) {
+	prog, inferer := infer(t, "testdata/leak/main.go")
+	f, err := os.Open("testdata/leak/spec.migo")
+	if err != nil {
+		t.Fatalf("cannot open spec: %v", err)
+	}
+	defer f.Close()
+	spec, err := parser.Parse(f)
+	if err != nil {
+		t.Fatalf("cannot parse spec: %v", err)
+	}
+	checker := migocheck.New(prog)
+	checker.SetPositions(inferer.StmtPosition)
+	r, err := checker.Conform(spec, "main.main")
+	if err != nil {
+		t.Fatalf("conformance check failed: %v", err)
+	}
+	if r.OK() {
+		t.Fatal("expects divergence at timeout case but conforms")
+	}
+	e := r.Divergence.Step().Events[0]
+	if e.Kind != migocheck.EventRecv || e.Choice != "case 1" || e.Pos.Line != 10 {
+		t.Errorf("expects divergence at timeout case at line 10 but got %s at %s", e, e.Pos)
+	}
+	if diags := r.Diagnostics(); len(diags) != 1 || diags[0].Code != migocheck.CodeDivergence || diags[0].Pos.Line != 10 {
+		t.Errorf("expects divergence diagnostic at line 10 but got %v", diags)
+	}
+}
@@ -0,0 +1,284 @@
+package migocheck
+
+// Rendering of traces.
+//
+// A trace is rendered with its steps mapped back to the Go source (if the
+// positions of the statements are known), the identity of its goroutines (the
+// spawn site and its call-context path) and of its channels (the newchan,
+// i.e. make, site). The formats are plain text, JSON and sequence diagrams
+// (Mermaid and PlantUML).
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/nickng/migo"
+)
+
+// Goroutine is the identity of a process of a trace.
+type Goroutine struct {
+	ID      int            // ID of the process.
+	Func    string         // Definition of the process.
+	Parent  int            // ID of the spawning process, or -1 for the entry.
+	Spawn   token.Position // Source position of the go statement.
+	Context []string       // Call-context path of the go statement.
+}
+
+func (g Goroutine) String() string {
+	if g.Parent < 0 {
+		return fmt.Sprintf("#%d %s", g.ID, g.Func)
+	}
+	s := fmt.Sprintf("#%d %s, go at %s by #%d", g.ID, g.Func, shortPos(g.Spawn), g.Parent)
+	if len(g.Context) > 0 {
+		s += " in " + strings.Join(g.Context, " > ")
+	}
+	return s
+}
+
+// Channel is the identity of a channel instance of a trace.
+type Channel struct {
+	ID    int            // Unique ID of the channel in the trace.
+	Name  string         // Local name of the channel at the newchan.
+	Label string         // Label of the channel.
+	Size  int64          // Buffer size.
+	Make  token.Position // Source position of the newchan.
+}
+
+func (c Channel) String() string {
+	return fmt.Sprintf("c%d %s (%s, %d) made at %s", c.ID, c.Name, c.Label, c.Size, shortPos(c.Make))
+}
+
+// TraceView is a trace with the identities of its goroutines and channels.
+type TraceView struct {
+	Goroutines []Goroutine
+	Channels   []Channel
+	Steps      Trace
+}
+
+// NewTraceView returns the view of trace t from the entry definition.
+func NewTraceView(entry string, t Trace) *TraceView {
+	v := &TraceView{Goroutines: []Goroutine{{ID: 0, Func: entry, Parent: -1}}, Steps: t}
+	for _, step := range t {
+		for _, e := range step.Events {
+			switch stmt := e.Stmt.(type) {
+			case *migo.SpawnStatement:
+				if e.Peer >= 0 {
+					v.Goroutines = append(v.Goroutines, Goroutine{
+						ID:      e.Peer,
+						Func:    stmt.SimpleName(),
+						Parent:  e.Proc,
+						Spawn:   e.Pos,
+						Context: e.Context,
+					})
+				}
+			case *migo.NewChanStatement:
+				if e.Kind == EventNewChan {
+					v.Channels = append(v.Channels, Channel{
+						ID:    e.ChanID,
+						Name:  stmt.Name.Name(),
+						Label: strings.Replace(e.Chan, `"`, "", -1),
+						Size:  stmt.Size,
+						Make:  e.ChanPos,
+					})
+				}
+			}
+		}
+	}
+	return v
+}
+
+// shortPos returns pos with the base name of the file, or - if pos is invalid.
+func shortPos(pos token.Position) string {
+	if !pos.IsValid() {
+		return "-"
+	}
+	return fmt.Sprintf("%s:%d:%d", filepath.Base(pos.Filename), pos.Line, pos.Column)
+}
+
+// eventText returns the text of e with its channel.
+func eventText(e Event) string {
+	if e.ChanID >= 0 && e.Kind != EventNewChan {
+		return fmt.Sprintf("%s [c%d]", e.action(), e.ChanID)
+	}
+	return e.action()
+}
+
+// WriteText writes the view as text to w.
+func (v *TraceView) WriteText(w io.Writer) error {
+	var buf bytes.Buffer
+	buf.WriteString("goroutines:\n")
+	for _, g := range v.Goroutines {
+		buf.WriteString(fmt.Sprintf("      %s\n", g))
+	}
+	if len(v.Channels) > 0 {
+		buf.WriteString("channels:\n")
+		for _, c := range v.Channels {
+			buf.WriteString(fmt.Sprintf("      %s\n", c))
+		}
+	}
+	buf.WriteString("trace:\n")
+	for i, step := range v.Steps {
+		for j, e := range step.Events {
+			n := fmt.Sprintf("%4d", i+1)
+			if j > 0 {
+				n = " <->"
+			}
+			buf.WriteString(fmt.Sprintf("%s  %-20s #%d %s: %s\n", n, shortPos(e.Pos), e.Proc, e.Func, eventText(e)))
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// jsonPos is the JSON encoding of a source position.
+type jsonPos struct {
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+func newJSONPos(pos token.Position) *jsonPos {
+	if !pos.IsValid() {
+		return nil
+	}
+	return &jsonPos{File: pos.Filename, Line: pos.Line, Column: pos.Column}
+}
+
+// MarshalJSON encodes the event as a JSON object.
+func (e Event) MarshalJSON() ([]byte, error) {
+	type jsonEvent struct {
+		Goroutine int      `json:"goroutine"`
+		Func      string   `json:"func"`
+		Kind      string   `json:"kind"`
+		Stmt      string   `json:"stmt"`
+		Chan      *int     `json:"chan,omitempty"`
+		Peer      *int     `json:"peer,omitempty"`
+		Choice    string   `json:"choice,omitempty"`
+		Pos       *jsonPos `json:"pos,omitempty"`
+	}
+	je := jsonEvent{Goroutine: e.Proc, Func: e.Func, Kind: string(e.Kind), Stmt: e.action(), Choice: e.Choice, Pos: newJSONPos(e.Pos)}
+	if e.ChanID >= 0 {
+		je.Chan = &e.ChanID
+	}
+	if e.Peer >= 0 {
+		je.Peer = &e.Peer
+	}
+	return json.Marshal(je)
+}
+
+// MarshalJSON encodes the view as a JSON object.
+func (v *TraceView) MarshalJSON() ([]byte, error) {
+	type jsonGoroutine struct {
+		ID      int      `json:"id"`
+		Func    string   `json:"func"`
+		Parent  *int     `json:"parent,omitempty"`
+		Spawn   *jsonPos `json:"spawn,omitempty"`
+		Context []string `json:"context,omitempty"`
+	}
+	type jsonChannel struct {
+		ID    int      `json:"id"`
+		Name  string   `json:"name"`
+		Label string   `json:"label"`
+		Size  int64    `json:"size"`
+		Make  *jsonPos `json:"make,omitempty"`
+	}
+	jv := struct {
+		Goroutines []jsonGoroutine `json:"goroutines"`
+		Channels   []jsonChannel   `json:"channels"`
+		Steps      [][]Event       `json:"steps"`
+	}{Goroutines: []jsonGoroutine{}, Channels: []jsonChannel{}, Steps: [][]Event{}}
+	for _, g := range v.Goroutines {
+		jg := jsonGoroutine{ID: g.ID, Func: g.Func, Spawn: newJSONPos(g.Spawn), Context: g.Context}
+		if g.Parent >= 0 {
+			parent := g.Parent
+			jg.Parent = &parent
+		}
+		jv.Goroutines = append(jv.Goroutines, jg)
+	}
+	for _, c := range v.Channels {
+		jv.Channels = append(jv.Channels, jsonChannel{ID: c.ID, Name: c.Name, Label: c.Label, Size: c.Size, Make: newJSONPos(c.Make)})
+	}
+	for _, step := range v.Steps {
+		jv.Steps = append(jv.Steps, step.Events)
+	}
+	return json.Marshal(jv)
+}
+
+// WriteJSON writes the view as JSON to w.
+func (v *TraceView) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// diagram is a sequence diagram syntax.
+type diagram struct {
+	begin, end  string
+	participant string // Format of participant by ID and name.
+	message     string // Format of message by sender, receiver and text.
+	note        string // Format of note by participant and text.
+	escape      *strings.Replacer
+}
+
+var (
+	mermaid = diagram{
+		begin:       "sequenceDiagram\n",
+		participant: "    participant g%d as %s\n",
+		message:     "    g%d->>g%d: %s\n",
+		note:        "    Note over g%d: %s\n",
+		escape:      strings.NewReplacer(";", ",", "#", "", "\n", " "),
+	}
+	plantUML = diagram{
+		begin:       "@startuml\n",
+		end:         "@enduml\n",
+		participant: "participant \"%[2]s\" as g%[1]d\n",
+		message:     "g%d -> g%d : %s\n",
+		note:        "note over g%d : %s\n",
+		escape:      strings.NewReplacer("\"", "'", "\n", " "),
+	}
+)
+
+// WriteMermaid writes the view as a Mermaid sequence diagram to w.
+func (v *TraceView) WriteMermaid(w io.Writer) error {
+	return v.writeDiagram(w, mermaid)
+}
+
+// WritePlantUML writes the view as a PlantUML sequence diagram to w.
+func (v *TraceView) WritePlantUML(w io.Writer) error {
+	return v.writeDiagram(w, plantUML)
+}
+
+// writeDiagram writes the view as a sequence diagram to w, where the
+// participants are the goroutines. Synchronisations and spawns are messages,
+// the other communication events are notes, and calls, branches and tau are
+// not shown.
+func (v *TraceView) writeDiagram(w io.Writer, d diagram) error {
+	var buf bytes.Buffer
+	buf.WriteString(d.begin)
+	for _, g := range v.Goroutines {
+		buf.WriteString(fmt.Sprintf(d.participant, g.ID, d.escape.Replace(fmt.Sprintf("G%d %s", g.ID, g.Func))))
+	}
+	for _, step := range v.Steps {
+		e := step.Events[0]
+		at := fmt.Sprintf(" (%s)", shortPos(e.Pos))
+		switch {
+		case len(step.Events) == 2:
+			recv := step.Events[1]
+			text := fmt.Sprintf("%s / %s%s", eventText(e), recv.action(), at)
+			buf.WriteString(fmt.Sprintf(d.message, e.Proc, recv.Proc, d.escape.Replace(text)))
+		case e.Kind == EventSpawn && e.Peer >= 0:
+			buf.WriteString(fmt.Sprintf(d.message, e.Proc, e.Peer, d.escape.Replace(e.action()+at)))
+		case e.Kind == EventCall || e.Kind == EventIf || (e.Kind == EventTau && e.Choice == ""):
+		default:
+			buf.WriteString(fmt.Sprintf(d.note, e.Proc, d.escape.Replace(eventText(e)+at)))
+		}
+	}
+	buf.WriteString(d.end)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
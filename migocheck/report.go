@@ -0,0 +1,120 @@
+package migocheck
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+func (d *Deadlock) String() string {
+	return d.header() + "trace:\n" + d.Trace.String()
+}
+
+// header returns the description of the deadlock without its trace.
+func (d *Deadlock) header() string {
+	var buf bytes.Buffer
+	buf.WriteString(possible(d.Loop))
+	buf.WriteString("deadlock, blocked at:\n")
+	for _, e := range d.Blocked {
+		buf.WriteString(fmt.Sprintf("      %s\n", e))
+	}
+	return buf.String()
+}
+
+// WriteText writes the result as text to w.
+func (r *Result) WriteText(w io.Writer) error {
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("%s: %d states explored", r.Entry, r.States))
+	if r.Bounded {
+		buf.WriteString(" (bounded, result may be incomplete)")
+	}
+	buf.WriteString("\n")
+	for _, e := range r.Unbound {
+		buf.WriteString(fmt.Sprintf("unbound channel %s at:\n      %s\n", e.Chan, e))
+	}
+	for _, p := range r.problems() {
+		buf.WriteString(p.header)
+		if err := p.view.WriteText(&buf); err != nil {
+			return err
+		}
+	}
+	switch {
+	case r.OK() && len(r.Unbound) > 0:
+		buf.WriteString("no problem found, operations on unbound channels are not checked\n")
+	case r.OK():
+		buf.WriteString("no problem found\n")
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// problem is a problem found with the view of its trace.
+type problem struct {
+	code   string // Diagnostic code of the problem.
+	header string // Description of the problem without trace.
+	view   *TraceView
+}
+
+// problems returns the problems found in the order of WriteText.
+func (r *Result) problems() []problem {
+	var ps []problem
+	for _, d := range r.Deadlocks {
+		ps = append(ps, problem{code: CodeDeadlock, header: d.header(), view: NewTraceView(r.Entry, d.Trace)})
+	}
+	for _, v := range r.Violations {
+		ps = append(ps, problem{code: v.Code, header: v.header(), view: NewTraceView(r.Entry, v.Trace)})
+	}
+	for _, l := range r.Leaks {
+		ps = append(ps, problem{code: CodeLeak, header: l.header(), view: NewTraceView(r.Entry, l.Trace)})
+	}
+	return ps
+}
+
+// WriteJSON writes the result as JSON to w, the problems are written with
+// their description and the view of their trace.
+func (r *Result) WriteJSON(w io.Writer) error {
+	type jsonProblem struct {
+		Code  string     `json:"code"`
+		Text  string     `json:"text"`
+		Trace *TraceView `json:"trace"`
+	}
+	jr := struct {
+		Entry    string        `json:"entry"`
+		States   int           `json:"states"`
+		Bounded  bool          `json:"bounded"`
+		Problems []jsonProblem `json:"problems"`
+		Unbound  []Event       `json:"unbound,omitempty"`
+	}{Entry: r.Entry, States: r.States, Bounded: r.Bounded, Problems: []jsonProblem{}, Unbound: r.Unbound}
+	for _, p := range r.problems() {
+		jr.Problems = append(jr.Problems, jsonProblem{Code: p.code, Text: strings.TrimSuffix(p.header, "\n"), Trace: p.view})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(jr)
+}
+
+// WriteMermaid writes the traces of the problems as Mermaid sequence diagrams
+// to w, each preceded by a comment line of the problem.
+func (r *Result) WriteMermaid(w io.Writer) error {
+	for _, p := range r.problems() {
+		fmt.Fprintf(w, "%%%% %s: %s\n", r.Entry, p.code)
+		if err := p.view.WriteMermaid(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WritePlantUML writes the traces of the problems as PlantUML sequence
+// diagrams to w, each preceded by a comment line of the problem.
+func (r *Result) WritePlantUML(w io.Writer) error {
+	for _, p := range r.problems() {
+		fmt.Fprintf(w, "' %s: %s\n", r.Entry, p.code)
+		if err := p.view.WritePlantUML(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package migocheck
+
+// Channel safety.
+//
+// Some channel operations panic in Go: send on a closed channel, close of a
+// closed channel and close of a nil channel. A reachable state where a process
+// executes such an operation is a violation, the program crashes.
+
+import (
+	"fmt"
+
+	"github.com/nickng/gospal/migoinfer"
+)
+
+// Diagnostic codes of problems.
+const (
+	CodeDeadlock    = "deadlock"        // Global deadlock.
+	CodeSendClosed  = "send-on-closed"  // Send on closed channel.
+	CodeCloseClosed = "close-of-closed" // Close of closed channel.
+	CodeCloseNil    = "close-of-nil"    // Close of nil channel.
+	CodeUnbound     = "unbound-channel" // Operation on unbound channel.
+)
+
+// Violation is a reachable channel operation which panics.
+type Violation struct {
+	Code  string // Kind of violation, see Code constants.
+	Op    Event  // Send or close which panics.
+	Close *Event // Close of the channel (nil if the channel is nil).
+	Trace Trace  // Steps from the initial state, the last step is Op.
+	Loop  *Event // First approximated loop of the trace, see Deadlock.
+}
+
+// Possible returns true if the violation is reached through an approximated
+// loop.
+func (v *Violation) Possible() bool {
+	return v.Loop != nil
+}
+
+// Message returns the panic message of the violation.
+func (v *Violation) Message() string {
+	switch v.Code {
+	case CodeSendClosed:
+		return "send on closed channel"
+	case CodeCloseClosed:
+		return "close of closed channel"
+	case CodeCloseNil:
+		return "close of nil channel"
+	}
+	return v.Code
+}
+
+func (v *Violation) String() string {
+	return v.header() + "trace:\n" + v.Trace.String()
+}
+
+// header returns the description of the violation without its trace.
+func (v *Violation) header() string {
+	s := possible(v.Loop) + fmt.Sprintf("%s at:\n      %s\n", v.Message(), v.Op)
+	if v.Close != nil {
+		s += fmt.Sprintf("closed at:\n      %s\n", v.Close)
+	}
+	return s
+}
+
+// Diagnostics returns the problems found as diagnostics, the deadlocks at the
+// first blocked statement, the violations at the operation which panics and
+// the leaks at the blocked statement, followed by the operations on unbound
+// channels.
+func (r *Result) Diagnostics() []migoinfer.Diagnostic {
+	var diags []migoinfer.Diagnostic
+	for _, d := range r.Deadlocks {
+		diag := migoinfer.Diagnostic{
+			Severity: migoinfer.SeverityError,
+			Code:     CodeDeadlock,
+			Message:  fmt.Sprintf("deadlock (%d blocked, trace of %d steps)", len(d.Blocked), len(d.Trace)),
+		}
+		if len(d.Blocked) > 0 {
+			diag.Pos, diag.Func = d.Blocked[0].Pos, d.Blocked[0].Func
+			diag.Message = fmt.Sprintf("deadlock at %s (%d blocked, trace of %d steps)", d.Blocked[0].action(), len(d.Blocked), len(d.Trace))
+		}
+		if d.Possible() {
+			diag.Severity = migoinfer.SeverityWarning
+			diag.Message = fmt.Sprintf("possible %s, loop approximated at %s", diag.Message, shortPos(d.Loop.Pos))
+		}
+		diags = append(diags, diag)
+	}
+	for _, v := range r.Violations {
+		diag := migoinfer.Diagnostic{
+			Severity: migoinfer.SeverityError,
+			Code:     v.Code,
+			Pos:      v.Op.Pos,
+			Func:     v.Op.Func,
+			Message:  fmt.Sprintf("%s at %s", v.Message(), v.Op.action()),
+		}
+		if v.Close != nil {
+			at := "-"
+			if v.Close.Pos.IsValid() {
+				at = v.Close.Pos.String()
+			}
+			diag.Message += fmt.Sprintf(", closed at %s (%s in %s)", at, v.Close.action(), v.Close.Func)
+		}
+		if v.Possible() {
+			diag.Severity = migoinfer.SeverityWarning
+			diag.Message = fmt.Sprintf("possible %s, loop approximated at %s", diag.Message, shortPos(v.Loop.Pos))
+		}
+		diags = append(diags, diag)
+	}
+	diags = append(diags, r.leakDiagnostics()...)
+	for _, e := range r.Unbound {
+		diags = append(diags, migoinfer.Diagnostic{
+			Severity: migoinfer.SeverityWarning,
+			Code:     CodeUnbound,
+			Pos:      e.Pos,
+			Func:     e.Func,
+			Message:  fmt.Sprintf("%s on unbound channel %s, explored as if it succeeds", e.Kind, e.Chan),
+		})
+	}
+	return diags
+}
@@ -0,0 +1,626 @@
+package migocheck
+
+// Operational semantics of MiGo.
+
+import (
+	"fmt"
+	"go/token"
+	"sort"
+	"strings"
+
+	"github.com/nickng/migo"
+)
+
+// def is a compiled MiGo definition.
+type def struct {
+	name   string   // Simple name of the definition.
+	params []string // Names of the parameters.
+	body   *block
+}
+
+// block is a compiled statement list, e.g. a definition body or a branch.
+type block struct {
+	id    int
+	def   *def
+	nodes []*node
+}
+
+// node is a compiled statement.
+type node struct {
+	stmt   migo.Statement
+	callee *def     // Callee of call and spawn, nil if not in the program.
+	args   []string // Arguments of call and spawn.
+	then   *block   // Then branch of if.
+	els    *block   // Else branch of if.
+	cases  []*block // Cases of select, the first statement is the guard.
+	loop   bool     // ifFor with a constant bound.
+	trips  int64    // Iterations of ifFor with a constant bound.
+}
+
+// machine is the compiled program and the bounds of the exploration.
+type machine struct {
+	defs   map[string]*def
+	blocks int         // Number of blocks.
+	pos    PosFunc     // Source positions of statements (nil if unknown).
+	ctx    ContextFunc // Call-context paths of spawns (nil if unknown).
+	approx ApproxFunc  // If statements approximating loops (nil if none).
+	bound  BoundFunc   // Iterations of ifFor loops (nil if unknown).
+	Config
+
+	unbound []Event                 // Operations on unbound channels.
+	seen    map[migo.Statement]bool // Statements of unbound.
+}
+
+func newMachine(prog *migo.Program, conf Config, pos PosFunc, ctx ContextFunc, approx ApproxFunc, bound BoundFunc) (*machine, error) {
+	m := &machine{defs: make(map[string]*def), pos: pos, ctx: ctx, approx: approx, bound: bound, Config: conf, seen: make(map[migo.Statement]bool)}
+	for _, f := range prog.Funcs {
+		d := &def{name: f.SimpleName()}
+		for _, p := range f.Params {
+			d.params = append(d.params, p.Callee.Name())
+		}
+		m.defs[f.Name] = d
+		if _, ok := m.defs[d.name]; !ok {
+			m.defs[d.name] = d
+		}
+	}
+	for _, f := range prog.Funcs {
+		d := m.defs[f.Name]
+		d.body = m.compile(d, f.Stmts)
+	}
+	return m, nil
+}
+
+// lookup returns the definition with the name (or simple name).
+func (m *machine) lookup(name string) (*def, bool) {
+	d, ok := m.defs[name]
+	if !ok {
+		d, ok = m.defs[strings.NewReplacer("\"", "", "(", "", ")", "", "*", "").Replace(name)]
+	}
+	return d, ok
+}
+
+func (m *machine) compile(d *def, stmts []migo.Statement) *block {
+	b := &block{id: m.blocks, def: d}
+	m.blocks++
+	for _, stmt := range stmts {
+		n := &node{stmt: stmt}
+		switch stmt := stmt.(type) {
+		case *migo.CallStatement:
+			n.callee, _ = m.lookup(stmt.Name)
+			n.args = argNames(stmt.Params)
+		case *migo.SpawnStatement:
+			n.callee, _ = m.lookup(stmt.Name)
+			n.args = argNames(stmt.Params)
+		case *migo.IfStatement:
+			n.then, n.els = m.compile(d, stmt.Then), m.compile(d, stmt.Else)
+		case *migo.IfForStatement:
+			n.then, n.els = m.compile(d, stmt.Then), m.compile(d, stmt.Else)
+			n.trips, n.loop = m.loopTrips(stmt)
+		case *migo.SelectStatement:
+			for _, c := range stmt.Cases {
+				n.cases = append(n.cases, m.compile(d, c))
+			}
+		}
+		b.nodes = append(b.nodes, n)
+	}
+	return b
+}
+
+func argNames(params []*migo.Parameter) []string {
+	var args []string
+	for _, p := range params {
+		args = append(args, p.Caller.Name())
+	}
+	return args
+}
+
+// binding binds a local name to a channel.
+type binding struct {
+	name string
+	ch   int
+}
+
+// env is the local channels of a frame, sorted by name.
+type env []binding
+
+func (e env) lookup(name string) (int, bool) {
+	i := sort.Search(len(e), func(i int) bool { return e[i].name >= name })
+	if i < len(e) && e[i].name == name {
+		return e[i].ch, true
+	}
+	return 0, false
+}
+
+// bind returns a copy of e with name bound to ch.
+func (e env) bind(name string, ch int) env {
+	i := sort.Search(len(e), func(i int) bool { return e[i].name >= name })
+	bound := make(env, 0, len(e)+1)
+	bound = append(bound, e[:i]...)
+	bound = append(bound, binding{name: name, ch: ch})
+	if i < len(e) && e[i].name == name {
+		i++
+	}
+	return append(bound, e[i:]...)
+}
+
+// frame is a block being executed.
+type frame struct {
+	b   *block
+	pc  int // Index of the next statement.
+	env env
+}
+
+// proc is a process. Procs are not modified once in a state.
+type proc struct {
+	id    int
+	stack []frame
+	spawn *Event    // Spawn of the process (nil for the entry).
+	loops []counter // Counters of the loops being iterated.
+}
+
+// counter is the iterations of a loop (an ifFor with a constant bound).
+type counter struct {
+	n *node
+	i int64
+}
+
+// counter returns the iterations of the loop n, false if the loop is not
+// being iterated.
+func (p *proc) counter(n *node) (int64, bool) {
+	for _, c := range p.loops {
+		if c.n == n {
+			return c.i, true
+		}
+	}
+	return 0, false
+}
+
+// setCounter returns p with the iterations of the loop n set to i, or removed
+// if done, i.e. the loop exits.
+func (p *proc) setCounter(n *node, i int64, done bool) *proc {
+	var loops []counter
+	for _, c := range p.loops {
+		if c.n != n {
+			loops = append(loops, c)
+		}
+	}
+	if !done {
+		loops = append(loops, counter{n: n, i: i})
+	}
+	return &proc{id: p.id, stack: p.stack, spawn: p.spawn, loops: loops}
+}
+
+// head returns the next statement of p and its frame.
+func (p *proc) head() (*node, frame) {
+	f := p.stack[len(p.stack)-1]
+	return f.b.nodes[f.pc], f
+}
+
+// advance returns p after the next statement, and with the frame f pushed if
+// f.b is not nil. Returned frames are dropped, so calls in tail position do not
+// grow the stack.
+func (p *proc) advance(f frame) *proc {
+	stack := make([]frame, len(p.stack), len(p.stack)+1)
+	copy(stack, p.stack)
+	stack[len(stack)-1].pc++
+	for len(stack) > 0 && stack[len(stack)-1].pc >= len(stack[len(stack)-1].b.nodes) {
+		stack = stack[:len(stack)-1]
+	}
+	if f.b != nil {
+		stack = append(stack, f)
+		for len(stack) > 0 && stack[len(stack)-1].pc >= len(stack[len(stack)-1].b.nodes) {
+			stack = stack[:len(stack)-1]
+		}
+	}
+	return &proc{id: p.id, stack: stack, spawn: p.spawn, loops: p.loops}
+}
+
+// chanState is the state of a channel.
+type chanState struct {
+	uid    int            // Unique ID of the channel in a trace.
+	made   token.Position // Source position of the newchan statement.
+	label  string
+	size   int
+	count  int // Number of buffered values.
+	closed bool
+	isNil  bool
+	closer *Event // Close of the channel.
+}
+
+// state is a state of the program.
+type state struct {
+	procs    []*proc
+	chans    []chanState
+	mainDone bool       // The entry has returned.
+	panic    *Violation // Operation which panics, the program crashed.
+	nextProc int        // Next process ID.
+	nextChan int        // Next channel unique ID.
+}
+
+// initial returns the initial state with entry as the only process.
+func (m *machine) initial(entry *def) *state {
+	s := &state{nextProc: 1}
+	if p := (&proc{id: 0, stack: []frame{{b: entry.body, pc: -1}}}).advance(frame{}); len(p.stack) > 0 {
+		s.procs = []*proc{p}
+	} else {
+		s.mainDone = true
+	}
+	return s
+}
+
+func (s *state) clone() *state {
+	c := *s
+	c.procs = append([]*proc(nil), s.procs...)
+	c.chans = append([]chanState(nil), s.chans...)
+	return &c
+}
+
+// normalise removes the returned processes and the unreferenced channels, and
+// numbers the channels in order of reference.
+func (s *state) normalise() {
+	var procs []*proc
+	for _, p := range s.procs {
+		if len(p.stack) > 0 {
+			procs = append(procs, p)
+		} else if p.id == 0 {
+			s.mainDone = true
+		}
+	}
+	s.procs = procs
+	remap := make(map[int]int)
+	var chans []chanState
+	identity := true
+	for _, p := range s.procs {
+		for _, f := range p.stack {
+			for _, b := range f.env {
+				if _, ok := remap[b.ch]; !ok {
+					remap[b.ch] = len(chans)
+					identity = identity && b.ch == len(chans)
+					chans = append(chans, s.chans[b.ch])
+				}
+			}
+		}
+	}
+	if identity && len(chans) == len(s.chans) {
+		return
+	}
+	s.chans = chans
+	for i, p := range s.procs {
+		stack := make([]frame, len(p.stack))
+		for j, f := range p.stack {
+			e := make(env, len(f.env))
+			for k, b := range f.env {
+				e[k] = binding{name: b.name, ch: remap[b.ch]}
+			}
+			stack[j] = frame{b: f.b, pc: f.pc, env: e}
+		}
+		s.procs[i] = &proc{id: p.id, stack: stack, spawn: p.spawn, loops: p.loops}
+	}
+}
+
+// key returns the key of the state, where states of the same key have the same
+// behaviour. Process and channel IDs are not in the key.
+func (s *state) key() string {
+	var buf strings.Builder
+	if s.mainDone {
+		buf.WriteString("done;")
+	}
+	if s.panic != nil {
+		fmt.Fprintf(&buf, "panic %s %p;", s.panic.Code, s.panic.Op.Stmt)
+	}
+	for _, p := range s.procs {
+		if p.id == 0 {
+			buf.WriteString("main")
+		}
+		buf.WriteString("[")
+		for _, f := range p.stack {
+			fmt.Fprintf(&buf, "%d.%d{", f.b.id, f.pc)
+			for _, b := range f.env {
+				fmt.Fprintf(&buf, "%s=%d,", b.name, b.ch)
+			}
+			buf.WriteString("}")
+		}
+		for _, c := range p.loops {
+			fmt.Fprintf(&buf, "%p=%d,", c.n, c.i)
+		}
+		buf.WriteString("]")
+	}
+	for _, ch := range s.chans {
+		fmt.Fprintf(&buf, "(%s,%d,%d,%t,%t)", ch.label, ch.size, ch.count, ch.closed, ch.isNil)
+	}
+	return buf.String()
+}
+
+// transition is a step and the state after the step.
+type transition struct {
+	step Step
+	next *state
+}
+
+// Kinds of offers.
+const (
+	offerLocal = iota // Step of the process alone.
+	offerSend         // Send on an unbuffered channel.
+	offerRecv         // Receive on an unbuffered channel.
+)
+
+// offer is a possible event of a process.
+type offer struct {
+	kind  int
+	ch    int
+	event Event
+	guard bool           // Offer is a communication case of a select.
+	dflt  bool           // Offer is the default case of a select.
+	apply func(s *state) // Applies the event to the cloned state.
+}
+
+// successors returns the transitions from s. If some transitions are not
+// possible because of the bounds, cut is true. The spawned processes continue
+// after the entry returns, so processes blocked forever are found.
+func (m *machine) successors(s *state) (ts []transition, cut bool) {
+	if s.panic != nil {
+		return nil, false
+	}
+	offers := make([][]offer, len(s.procs))
+	for i := range s.procs {
+		var c bool
+		offers[i], c = m.offers(s, i)
+		cut = cut || c
+	}
+	ready := make([]bool, len(s.procs)) // Process has a ready select case.
+	for i := range s.procs {
+		for _, o := range offers[i] {
+			if o.kind == offerLocal && !o.dflt {
+				ts = append(ts, m.local(s, i, o))
+				ready[i] = ready[i] || o.guard
+			}
+			if o.kind != offerSend {
+				continue
+			}
+			for j := range s.procs {
+				if j == i {
+					continue
+				}
+				for _, o2 := range offers[j] {
+					if o2.kind == offerRecv && o2.ch == o.ch {
+						ts = append(ts, m.sync(s, i, o, j, o2))
+						ready[i] = ready[i] || o.guard
+						ready[j] = ready[j] || o2.guard
+					}
+				}
+			}
+		}
+	}
+	for i := range s.procs {
+		for _, o := range offers[i] {
+			if o.dflt && !ready[i] {
+				ts = append(ts, m.local(s, i, o))
+			}
+		}
+	}
+	return ts, cut
+}
+
+func (m *machine) local(s *state, i int, o offer) transition {
+	next := s.clone()
+	o.apply(next)
+	next.normalise()
+	return transition{step: Step{Events: []Event{o.event}}, next: next}
+}
+
+func (m *machine) sync(s *state, i int, send offer, j int, recv offer) transition {
+	next := s.clone()
+	send.apply(next)
+	recv.apply(next)
+	next.normalise()
+	send.event.Peer, recv.event.Peer = s.procs[j].id, s.procs[i].id
+	return transition{step: Step{Events: []Event{send.event, recv.event}}, next: next}
+}
+
+// event returns the event of stmt executed by process p in frame f.
+func (m *machine) event(p *proc, f frame, stmt migo.Statement, kind EventKind) Event {
+	e := Event{Proc: p.id, Func: f.b.def.name, Stmt: stmt, Kind: kind, Peer: -1, ChanID: -1}
+	if m.pos != nil {
+		e.Pos, _ = m.pos(stmt)
+	}
+	return e
+}
+
+// offers returns the possible events of process i in s.
+func (m *machine) offers(s *state, i int) (offers []offer, cut bool) {
+	p := s.procs[i]
+	n, f := p.head()
+	ev := m.event(p, f, n.stmt, EventTau)
+	// next advances process i to after the statement, pushing the frame next.
+	next := func(s *state, push frame) {
+		s.procs[i] = s.procs[i].advance(push)
+	}
+	switch stmt := n.stmt.(type) {
+	case *migo.NewChanStatement:
+		if m.MaxChans > 0 && len(s.chans) >= m.MaxChans {
+			return nil, true
+		}
+		ev.Kind = EventNewChan
+		ev.setChan(chanState{uid: s.nextChan, made: ev.Pos, label: stmt.Chan})
+		return []offer{{kind: offerLocal, event: ev, apply: func(s *state) {
+			s.chans = append(s.chans, chanState{
+				uid:   s.nextChan,
+				made:  ev.Pos,
+				label: stmt.Chan,
+				size:  int(stmt.Size),
+				isNil: stmt.Chan == "nilchan",
+			})
+			s.nextChan++
+			p := s.procs[i]
+			stack := append([]frame(nil), p.stack...)
+			stack[len(stack)-1].env = stack[len(stack)-1].env.bind(stmt.Name.Name(), len(s.chans)-1)
+			s.procs[i] = (&proc{id: p.id, stack: stack, spawn: p.spawn, loops: p.loops}).advance(frame{})
+		}}}, false
+	case *migo.SendStatement:
+		ev.Kind = EventSend
+		return m.comm(s, f, stmt.Chan, ev, false, next), false
+	case *migo.RecvStatement:
+		ev.Kind = EventRecv
+		return m.comm(s, f, stmt.Chan, ev, false, next), false
+	case *migo.CloseStatement:
+		ev.Kind = EventClose
+		ch, ok := f.env.lookup(stmt.Chan)
+		if !ok {
+			m.unboundChan(ev, stmt.Chan)
+			return []offer{{kind: offerLocal, event: ev, apply: func(s *state) { next(s, frame{}) }}}, false
+		}
+		ev.setChan(s.chans[ch])
+		return []offer{{kind: offerLocal, event: ev, apply: func(s *state) {
+			switch {
+			case s.chans[ch].isNil:
+				s.panic = &Violation{Code: CodeCloseNil, Op: ev}
+			case s.chans[ch].closed:
+				s.panic = &Violation{Code: CodeCloseClosed, Op: ev, Close: s.chans[ch].closer}
+			default:
+				s.chans[ch].closed = true
+				s.chans[ch].closer = &ev
+				next(s, frame{})
+			}
+		}}}, false
+	case *migo.CallStatement:
+		ev.Kind = EventCall
+		if n.callee == nil || n.callee.body == nil {
+			return []offer{{kind: offerLocal, event: ev, apply: func(s *state) { next(s, frame{}) }}}, false
+		}
+		if m.MaxCalls > 0 && len(p.stack) >= m.MaxCalls {
+			return nil, true
+		}
+		callee := frame{b: n.callee.body, env: calleeEnv(f.env, n.args, n.callee.params)}
+		return []offer{{kind: offerLocal, event: ev, apply: func(s *state) { next(s, callee) }}}, false
+	case *migo.SpawnStatement:
+		ev.Kind = EventSpawn
+		if n.callee == nil || n.callee.body == nil {
+			return []offer{{kind: offerLocal, event: ev, apply: func(s *state) { next(s, frame{}) }}}, false
+		}
+		if m.MaxProcs > 0 && len(s.procs) >= m.MaxProcs {
+			return nil, true
+		}
+		ev.Peer = s.nextProc
+		if m.ctx != nil {
+			ev.Context = m.ctx(stmt)
+		}
+		callee := frame{b: n.callee.body, pc: -1, env: calleeEnv(f.env, n.args, n.callee.params)}
+		return []offer{{kind: offerLocal, event: ev, apply: func(s *state) {
+			next(s, frame{})
+			s.procs = append(s.procs, (&proc{id: s.nextProc, stack: []frame{callee}, spawn: &ev}).advance(frame{}))
+			s.nextProc++
+		}}}, false
+	case *migo.IfStatement, *migo.IfForStatement:
+		ev.Kind = EventIf
+		if n.loop { // Loop with a constant bound, the branch is known.
+			iter, _ := p.counter(n)
+			if iter < n.trips {
+				ev.Choice = "then"
+				return []offer{{kind: offerLocal, event: ev, apply: func(s *state) {
+					next(s, frame{b: n.then, env: f.env})
+					s.procs[i] = s.procs[i].setCounter(n, iter+1, false)
+				}}}, false
+			}
+			ev.Choice = "else"
+			return []offer{{kind: offerLocal, event: ev, apply: func(s *state) {
+				next(s, frame{b: n.els, env: f.env})
+				s.procs[i] = s.procs[i].setCounter(n, 0, true)
+			}}}, false
+		}
+		_, ev.Approx = n.stmt.(*migo.IfForStatement)
+		if m.approx != nil && m.approx(n.stmt) {
+			ev.Approx = true
+		}
+		branch := func(choice string, b *block) offer {
+			ev := ev
+			ev.Choice = choice
+			return offer{kind: offerLocal, event: ev, apply: func(s *state) { next(s, frame{b: b, env: f.env}) }}
+		}
+		return []offer{branch("then", n.then), branch("else", n.els)}, false
+	case *migo.SelectStatement:
+		for k, c := range n.cases {
+			choice := fmt.Sprintf("case %d", k)
+			if len(c.nodes) == 0 {
+				ev := ev
+				ev.Kind, ev.Choice = EventSelect, choice
+				offers = append(offers, offer{kind: offerLocal, dflt: true, event: ev, apply: func(s *state) { next(s, frame{}) }})
+				continue
+			}
+			ev := m.event(p, f, c.nodes[0].stmt, EventTau)
+			ev.Choice = choice
+			cont := frame{b: c, pc: 1, env: f.env}
+			caseNext := func(s *state, _ frame) { next(s, cont) }
+			switch guard := c.nodes[0].stmt.(type) {
+			case *migo.SendStatement:
+				ev.Kind = EventSend
+				offers = append(offers, m.comm(s, f, guard.Chan, ev, true, caseNext)...)
+			case *migo.RecvStatement:
+				ev.Kind = EventRecv
+				offers = append(offers, m.comm(s, f, guard.Chan, ev, true, caseNext)...)
+			default:
+				offers = append(offers, offer{kind: offerLocal, dflt: true, event: ev, apply: func(s *state) { caseNext(s, frame{}) }})
+			}
+		}
+		return offers, false
+	}
+	// tau and other statements, e.g. comments.
+	return []offer{{kind: offerLocal, event: ev, apply: func(s *state) { next(s, frame{}) }}}, false
+}
+
+// comm returns the offers of a send or receive (ev.Kind) on the channel name of
+// frame f, where next advances the process after the communication.
+func (m *machine) comm(s *state, f frame, name string, ev Event, guard bool, next func(*state, frame)) []offer {
+	ch, ok := f.env.lookup(name)
+	if !ok {
+		m.unboundChan(ev, name)
+		return []offer{{kind: offerLocal, event: ev, guard: guard, apply: func(s *state) { next(s, frame{}) }}}
+	}
+	c := s.chans[ch]
+	ev.setChan(c)
+	local := func(apply func(s *state)) []offer {
+		return []offer{{kind: offerLocal, ch: ch, event: ev, guard: guard, apply: func(s *state) { apply(s) }}}
+	}
+	switch {
+	case c.isNil:
+		return nil
+	case ev.Kind == EventSend && c.closed:
+		return local(func(s *state) { s.panic = &Violation{Code: CodeSendClosed, Op: ev, Close: c.closer} })
+	case ev.Kind == EventSend && c.size > 0:
+		if c.count < c.size {
+			return local(func(s *state) { s.chans[ch].count++; next(s, frame{}) })
+		}
+		return nil
+	case ev.Kind == EventSend:
+		return []offer{{kind: offerSend, ch: ch, event: ev, guard: guard, apply: func(s *state) { next(s, frame{}) }}}
+	case c.count > 0:
+		return local(func(s *state) { s.chans[ch].count--; next(s, frame{}) })
+	case c.closed:
+		return local(func(s *state) { next(s, frame{}) })
+	case c.size == 0:
+		return []offer{{kind: offerRecv, ch: ch, event: ev, guard: guard, apply: func(s *state) { next(s, frame{}) }}}
+	}
+	return nil
+}
+
+// unboundChan records the operation ev on the name not bound to a channel,
+// e.g. a parameter of the entry. The operation is explored as if it succeeds,
+// so the result may be incomplete.
+func (m *machine) unboundChan(ev Event, name string) {
+	if !m.seen[ev.Stmt] {
+		m.seen[ev.Stmt] = true
+		ev.Chan = name
+		m.unbound = append(m.unbound, ev)
+	}
+}
+
+// calleeEnv returns the environment of a callee with params bound to the
+// channels of args in the caller environment e.
+func calleeEnv(e env, args, params []string) env {
+	var callee env
+	for k := 0; k < len(args) && k < len(params); k++ {
+		if ch, ok := e.lookup(args[k]); ok {
+			callee = callee.bind(params[k], ch)
+		}
+	}
+	return callee
+}
@@ -0,0 +1,15 @@
+package main
+
+func worker(ch chan int, done chan struct{}) {
+	ch <- 1
+	close(done)
+}
+
+func main() {
+	ch := make(chan int, 1)
+	done := make(chan struct{})
+	go worker(ch, done)
+	close(ch)
+	<-done
+	close(done)
+}
@@ -0,0 +1,16 @@
+package main
+
+// The loops have constant bounds, so they are followed exactly and the
+// deadlock found is a deadlock of the program.
+
+func main() {
+	ch := make(chan int)
+	go func() {
+		for i := 0; i < 3; i++ {
+			ch <- i
+		}
+	}()
+	for i := 0; i < 4; i++ {
+		<-ch
+	}
+}
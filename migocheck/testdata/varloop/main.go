@@ -0,0 +1,18 @@
+package main
+
+// The loops have a variable bound, so they are approximated and the deadlocks
+// found are possible deadlocks.
+
+var n = 3
+
+func main() {
+	ch := make(chan int)
+	go func() {
+		for i := 0; i < n; i++ {
+			ch <- i
+		}
+	}()
+	for i := 0; i < n; i++ {
+		<-ch
+	}
+}
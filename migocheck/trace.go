@@ -0,0 +1,104 @@
+package migocheck
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"strings"
+
+	"github.com/nickng/migo"
+)
+
+// EventKind is the kind of an event.
+type EventKind string
+
+// Kinds of events.
+const (
+	EventSend    EventKind = "send"
+	EventRecv    EventKind = "recv"
+	EventClose   EventKind = "close"
+	EventNewChan EventKind = "newchan"
+	EventSpawn   EventKind = "spawn"
+	EventCall    EventKind = "call"
+	EventIf      EventKind = "if"
+	EventSelect  EventKind = "select"
+	EventTau     EventKind = "tau"
+)
+
+// Event is the execution of a statement by a process.
+type Event struct {
+	Proc   int            // ID of the process (0 is the entry).
+	Func   string         // Name of the definition of the statement.
+	Stmt   migo.Statement // Statement executed.
+	Kind   EventKind      // Kind of the event.
+	Chan   string         // Channel label of send, recv, close and newchan.
+	Peer   int            // ID of the synchronising or spawned process, or -1.
+	Choice string         // Branch of if (then, else) or case of select.
+	Pos    token.Position // Source position of the statement (invalid if unknown).
+
+	ChanID  int            // Unique ID of the channel in the trace, or -1.
+	ChanPos token.Position // Source position of the newchan of the channel.
+	Context []string       // Call-context path of the spawn (nil if unknown).
+	Approx  bool           // The choice of ifFor (or if) approximates a loop.
+}
+
+// setChan sets the channel of the event to c.
+func (e *Event) setChan(c chanState) {
+	e.Chan, e.ChanID, e.ChanPos = c.label, c.uid, c.made
+}
+
+func (e Event) String() string {
+	return fmt.Sprintf("#%d %s: %s", e.Proc, e.Func, e.action())
+}
+
+// action returns the text of the statement executed.
+func (e Event) action() string {
+	switch stmt := e.Stmt.(type) {
+	case *migo.IfStatement:
+		if e.Approx {
+			return fmt.Sprintf("if (%s, approximated)", e.Choice)
+		}
+		return fmt.Sprintf("if (%s)", e.Choice)
+	case *migo.IfForStatement:
+		if e.Approx {
+			return fmt.Sprintf("ifFor (int %s) (%s, approximated)", stmt.ForCond, e.Choice)
+		}
+		return fmt.Sprintf("ifFor (int %s) (%s)", stmt.ForCond, e.Choice)
+	case *migo.SelectStatement:
+		if e.Choice == "" {
+			return "select"
+		}
+		return fmt.Sprintf("select (%s)", e.Choice)
+	case nil:
+		return string(e.Kind)
+	}
+	if e.Choice != "" {
+		return fmt.Sprintf("select (%s) %s", e.Choice, e.Stmt)
+	}
+	return e.Stmt.String()
+}
+
+// Step is a transition of the program, i.e. an event of a process, or the
+// synchronisation of a send and a receive (sender first).
+type Step struct {
+	Events []Event
+}
+
+func (s Step) String() string {
+	var events []string
+	for _, e := range s.Events {
+		events = append(events, e.String())
+	}
+	return strings.Join(events, " <-> ")
+}
+
+// Trace is a sequence of steps from the initial state.
+type Trace []Step
+
+func (t Trace) String() string {
+	var buf bytes.Buffer
+	for i, step := range t {
+		buf.WriteString(fmt.Sprintf("%4d  %s\n", i+1, step))
+	}
+	return buf.String()
+}
@@ -0,0 +1,520 @@
+// Package migodiff compares the MiGo programs of two versions of a program.
+//
+// The definitions of the two programs are matched by name, and the
+// statements of the matched definitions are compared after normalising the
+// channel names, i.e. parameters and local channels are named by position,
+// so renumbered SSA registers (and channel labels) do not show as changes.
+// The block definitions (e.g. main.main#3) are inlined in their callers and
+// the branches of if statements with the same statements are merged, so
+// edits of the control flow only do not show as changes either.
+// The changes reported are the definitions added or removed, the channel
+// operations, spawns, calls and branches added or removed, the buffer sizes
+// changed and the cases of select statements added or removed. Comments and
+// tau statements are ignored.
+package migodiff
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/nickng/gospal/migoinfer"
+	"github.com/nickng/migo"
+)
+
+// Action is the kind of a change.
+type Action string
+
+// Actions of changes.
+const (
+	Added   Action = "added"
+	Removed Action = "removed"
+	Changed Action = "changed"
+)
+
+// Kinds of statements changed besides the MiGo statements.
+const (
+	KindFunc = "def"  // Definition.
+	KindCase = "case" // Case of select.
+)
+
+// Change is a change of the new version.
+type Change struct {
+	Action Action         `json:"action"`
+	Kind   string         `json:"kind"` // Kind of statement, e.g. send, or def.
+	Func   string         `json:"func"` // Name of the definition.
+	Old    string         `json:"old,omitempty"`
+	New    string         `json:"new,omitempty"`
+	OldPos token.Position `json:"-"` // Source position of the old statement.
+	NewPos token.Position `json:"-"` // Source position of the new statement.
+}
+
+func (c Change) String() string {
+	var s string
+	switch c.Action {
+	case Added:
+		s = fmt.Sprintf("%s: added %s", c.Func, c.New)
+	case Removed:
+		s = fmt.Sprintf("%s: removed %s", c.Func, c.Old)
+	default:
+		s = fmt.Sprintf("%s: changed %s to %s", c.Func, c.Old, c.New)
+	}
+	if pos := c.NewPos; pos.IsValid() || c.OldPos.IsValid() {
+		if c.Action == Removed || !pos.IsValid() {
+			pos = c.OldPos
+		}
+		s += fmt.Sprintf(" (%s:%d:%d)", filepath.Base(pos.Filename), pos.Line, pos.Column)
+	}
+	return s
+}
+
+// MarshalJSON encodes the change as a JSON object, with the source positions.
+func (c Change) MarshalJSON() ([]byte, error) {
+	type change Change
+	return json.Marshal(struct {
+		change
+		OldPos *migoinfer.Position `json:"oldPos,omitempty"`
+		NewPos *migoinfer.Position `json:"newPos,omitempty"`
+	}{change: change(c), OldPos: position(c.OldPos), NewPos: position(c.NewPos)})
+}
+
+func position(pos token.Position) *migoinfer.Position {
+	if !pos.IsValid() {
+		return nil
+	}
+	return &migoinfer.Position{File: pos.Filename, Line: pos.Line, Column: pos.Column}
+}
+
+// PosFunc returns the source position of a MiGo statement.
+type PosFunc func(migo.Statement) (token.Position, bool)
+
+// Differ compares two versions of a MiGo program.
+type Differ struct {
+	Old, New       *migo.Program
+	OldPos, NewPos PosFunc // Source positions of statements (nil if unknown).
+}
+
+// New returns a new Differ of the versions old and new.
+func New(old, new *migo.Program) *Differ {
+	return &Differ{Old: old, New: new}
+}
+
+// SetPositions sets the source positions of the statements of the versions,
+// e.g. the positions of the statements inferred by migoinfer.
+func (d *Differ) SetPositions(old, new PosFunc) {
+	d.OldPos, d.NewPos = old, new
+}
+
+// Diff returns the changes from the old to the new version, by name of
+// definition and in order of statements.
+func (d *Differ) Diff() []Change {
+	olds, news := funcs(d.Old), funcs(d.New)
+	var names []string
+	for name := range olds {
+		names = append(names, name)
+	}
+	for name := range news {
+		if _, ok := olds[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var changes []Change
+	for _, name := range names {
+		of, nf := olds[name], news[name]
+		switch {
+		case of == nil:
+			changes = append(changes, Change{Action: Added, Kind: KindFunc, Func: name, New: funcText(nf)})
+		case nf == nil:
+			changes = append(changes, Change{Action: Removed, Kind: KindFunc, Func: name, Old: funcText(of)})
+		default:
+			cf := &funcDiff{Differ: d, name: name}
+			cf.stmts(normalise(d.Old, of), normalise(d.New, nf))
+			changes = append(changes, cf.changes...)
+		}
+	}
+	return changes
+}
+
+// funcs returns the non-empty definitions of prog by name, except the block
+// definitions, which are compared inlined in their callers.
+func funcs(prog *migo.Program) map[string]*migo.Function {
+	fs := make(map[string]*migo.Function)
+	if prog == nil {
+		return fs
+	}
+	for _, f := range prog.Funcs {
+		if !f.IsEmpty() && !blockDef.MatchString(f.SimpleName()) {
+			fs[f.SimpleName()] = f
+		}
+	}
+	return fs
+}
+
+func funcText(f *migo.Function) string {
+	return fmt.Sprintf("def %s(%s)", f.SimpleName(), migo.CalleeParameterString(f.Params))
+}
+
+// funcDiff is the comparison of a definition.
+type funcDiff struct {
+	*Differ
+	name    string
+	changes []Change
+}
+
+func (d *funcDiff) pos(f PosFunc, s migo.Statement) token.Position {
+	if f == nil || s == nil {
+		return token.Position{}
+	}
+	pos, _ := f(s)
+	return pos
+}
+
+// added records the statement n as added, and the statements nested in n.
+func (d *funcDiff) added(n *stmt, kind string) {
+	d.changes = append(d.changes, Change{Action: Added, Kind: kind, Func: d.name, New: n.text, NewPos: d.pos(d.NewPos, n.orig)})
+	switch n.kind {
+	case "select":
+		d.cases(&stmt{}, n)
+	default:
+		for _, b := range n.branches {
+			d.stmts(nil, b)
+		}
+	}
+}
+
+// removed records the statement o as removed, and the statements nested in o.
+func (d *funcDiff) removed(o *stmt, kind string) {
+	d.changes = append(d.changes, Change{Action: Removed, Kind: kind, Func: d.name, Old: o.text, OldPos: d.pos(d.OldPos, o.orig)})
+	switch o.kind {
+	case "select":
+		d.cases(o, &stmt{})
+	default:
+		for _, b := range o.branches {
+			d.stmts(b, nil)
+		}
+	}
+}
+
+// stmts compares the statement lists olds and news, the statements are matched
+// by the longest common subsequence of their keys.
+func (d *funcDiff) stmts(olds, news []*stmt) {
+	lcs := make([][]int, len(olds)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(news)+1)
+	}
+	for i := len(olds) - 1; i >= 0; i-- {
+		for j := len(news) - 1; j >= 0; j-- {
+			switch {
+			case olds[i].key == news[j].key:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(olds) && j < len(news) {
+		switch {
+		case olds[i].key == news[j].key:
+			d.matched(olds[i], news[j])
+			i, j = i+1, j+1
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			d.removed(olds[i], olds[i].kind)
+			i++
+		default:
+			d.added(news[j], news[j].kind)
+			j++
+		}
+	}
+	for ; i < len(olds); i++ {
+		d.removed(olds[i], olds[i].kind)
+	}
+	for ; j < len(news); j++ {
+		d.added(news[j], news[j].kind)
+	}
+}
+
+// matched compares the matched statements o and n.
+func (d *funcDiff) matched(o, n *stmt) {
+	switch o.kind {
+	case "newchan":
+		if o.size != n.size {
+			d.changes = append(d.changes, Change{
+				Action: Changed, Kind: o.kind, Func: d.name,
+				Old: o.text, New: n.text,
+				OldPos: d.pos(d.OldPos, o.orig), NewPos: d.pos(d.NewPos, n.orig),
+			})
+		}
+	case "if", "ifFor":
+		for k := range o.branches {
+			d.stmts(o.branches[k], n.branches[k])
+		}
+	case "select":
+		d.cases(o, n)
+	}
+}
+
+// cases compares the cases of the select statements o and n, the cases are
+// matched by their guards.
+func (d *funcDiff) cases(o, n *stmt) {
+	used := make([]bool, len(n.branches))
+	for k, oc := range o.branches {
+		match := -1
+		for l, nc := range n.branches {
+			if !used[l] && guardKey(oc) == guardKey(nc) {
+				match = l
+				break
+			}
+		}
+		if match < 0 {
+			d.removed(&stmt{text: caseText(oc), orig: caseOrig(oc, o.orig), branches: [][]*stmt{caseBody(oc)}}, KindCase)
+			continue
+		}
+		used[match] = true
+		d.stmts(caseBody(o.branches[k]), caseBody(n.branches[match]))
+	}
+	for l, nc := range n.branches {
+		if !used[l] {
+			d.added(&stmt{text: caseText(nc), orig: caseOrig(nc, n.orig), branches: [][]*stmt{caseBody(nc)}}, KindCase)
+		}
+	}
+}
+
+// guardKey returns the key of the guard of case c, where an empty case is a
+// tau case.
+func guardKey(c []*stmt) string {
+	if len(c) == 0 {
+		return "tau"
+	}
+	return c[0].key
+}
+
+func caseText(c []*stmt) string {
+	if len(c) == 0 {
+		return "select case tau"
+	}
+	return "select case " + c[0].text
+}
+
+// caseOrig returns the guard of case c, or the select statement if c is empty.
+func caseOrig(c []*stmt, sel migo.Statement) migo.Statement {
+	if len(c) == 0 || c[0].orig == nil {
+		return sel
+	}
+	return c[0].orig
+}
+
+func caseBody(c []*stmt) []*stmt {
+	if len(c) == 0 {
+		return nil
+	}
+	return c[1:]
+}
+
+// stmt is a normalised statement.
+type stmt struct {
+	kind     string
+	key      string // Text of the statement with normalised names.
+	text     string // Text of the statement.
+	size     int64  // Buffer size of newchan.
+	orig     migo.Statement
+	branches [][]*stmt // Branches of if, or cases of select.
+}
+
+// blockDef matches the names of block definitions, i.e. the definitions of
+// the basic blocks of a function, e.g. main.main#3.
+var blockDef = regexp.MustCompile(`#\d+$`)
+
+// maxInline is the maximum number of calls to block definitions inlined in a
+// definition, as the blocks joining branches are inlined in each branch.
+const maxInline = 1000
+
+// normaliser normalises the definitions of a program.
+type normaliser struct {
+	defs    map[string]*migo.Function // Definitions by simple name.
+	locals  int                       // Number of local channels named.
+	depth   int                       // Nesting depth of branches.
+	inlined int                       // Number of calls inlined.
+	stack   []inline                  // Block definitions being inlined.
+}
+
+// inline is a block definition being inlined at a nesting depth.
+type inline struct {
+	name  string
+	depth int
+}
+
+func newNormaliser(prog *migo.Program) *normaliser {
+	z := &normaliser{defs: make(map[string]*migo.Function)}
+	for _, f := range prog.Funcs {
+		z.defs[f.SimpleName()] = f
+	}
+	return z
+}
+
+// normalise returns the normalised statements of f, where the parameters are
+// named p0, p1, ... and the local channels l0, l1, ... in order of newchan.
+//
+// The calls to block definitions are inlined, so the statements of f do not
+// depend on how the control flow is split into blocks, and a call back to a
+// block being inlined (a loop) is a continue statement of the nesting depth
+// of the loop. The branches of an if with the same statements are a single
+// branch, so a branch which only changes the control flow, e.g. with no
+// channel operation, is not a change.
+func normalise(prog *migo.Program, f *migo.Function) []*stmt {
+	z := newNormaliser(prog)
+	names := make(map[string]string)
+	for i, p := range f.Params {
+		names[p.Callee.Name()] = fmt.Sprintf("p%d", i)
+	}
+	return z.stmts(f.Stmts, names)
+}
+
+// stmts returns the normalised statements ss, where names is the normalised
+// names in scope.
+func (z *normaliser) stmts(ss []migo.Statement, names map[string]string) []*stmt {
+	rename := func(name string) string {
+		if n, ok := names[name]; ok {
+			return n
+		}
+		return name
+	}
+	args := func(params []*migo.Parameter) string {
+		var as []string
+		for _, p := range params {
+			as = append(as, rename(p.Caller.Name()))
+		}
+		return strings.Join(as, ", ")
+	}
+	branches := func(bs ...[]migo.Statement) [][]*stmt {
+		z.depth++
+		defer func() { z.depth-- }()
+		// The local channels of each branch are named from the same number.
+		start, end := z.locals, z.locals
+		var ns [][]*stmt
+		for _, b := range bs {
+			z.locals = start
+			ns = append(ns, z.stmts(b, names))
+			if z.locals > end {
+				end = z.locals
+			}
+		}
+		z.locals = end
+		return ns
+	}
+	var ns []*stmt
+	for _, s := range ss {
+		n := &stmt{orig: s}
+		if s != nil {
+			n.text = s.String()
+		}
+		switch s := s.(type) {
+		case *migo.SendStatement:
+			n.kind, n.key = "send", "send "+rename(s.Chan)
+		case *migo.RecvStatement:
+			n.kind, n.key = "recv", "recv "+rename(s.Chan)
+		case *migo.CloseStatement:
+			n.kind, n.key = "close", "close "+rename(s.Chan)
+		case *migo.NewChanStatement:
+			name := fmt.Sprintf("l%d", z.locals)
+			z.locals++
+			names[s.Name.Name()] = name
+			// Size is not in the key, so changed sizes are matched.
+			n.kind, n.key, n.size = "newchan", "newchan "+name, s.Size
+		case *migo.SpawnStatement:
+			n.kind, n.key = "spawn", fmt.Sprintf("spawn %s(%s)", s.SimpleName(), args(s.Params))
+		case *migo.CallStatement:
+			if inlined, ok := z.call(s, rename); ok {
+				ns = append(ns, inlined...)
+				continue
+			}
+			n.kind, n.key = "call", fmt.Sprintf("call %s(%s)", s.SimpleName(), args(s.Params))
+			for _, in := range z.stack {
+				if in.name == s.SimpleName() {
+					n.key = fmt.Sprintf("continue %d(%s)", z.depth-in.depth, args(s.Params))
+				}
+			}
+		case *migo.IfStatement:
+			n.kind, n.key, n.text = "if", "if", "if"
+			n.branches = branches(s.Then, s.Else)
+			if deepKey(n.branches[0]) == deepKey(n.branches[1]) {
+				ns = append(ns, n.branches[0]...)
+				continue
+			}
+		case *migo.IfForStatement:
+			n.kind, n.key, n.text = "ifFor", "ifFor", fmt.Sprintf("ifFor (int %s)", s.ForCond)
+			n.branches = branches(s.Then, s.Else)
+		case *migo.SelectStatement:
+			n.kind, n.key, n.text = "select", "select", "select"
+			var cases [][]migo.Statement
+			var guards [][]*stmt
+			for _, c := range s.Cases {
+				var guard []*stmt
+				if len(c) > 0 {
+					if _, ok := c[0].(*migo.TauStatement); ok { // Default case.
+						guard, c = []*stmt{{kind: "tau", key: "tau", text: "tau", orig: c[0]}}, c[1:]
+					}
+				}
+				cases, guards = append(cases, c), append(guards, guard)
+			}
+			for k, c := range branches(cases...) {
+				n.branches = append(n.branches, append(guards[k], c...))
+			}
+		default: // tau and comments.
+			continue
+		}
+		ns = append(ns, n)
+	}
+	return ns
+}
+
+// call returns the inlined statements of the call s to a block definition, or
+// false if s is not inlined, i.e. the callee is not a block definition, is
+// being inlined, or too many calls are inlined. The calls to undefined (empty)
+// block definitions are inlined as no statement.
+func (z *normaliser) call(s *migo.CallStatement, rename func(string) string) ([]*stmt, bool) {
+	name := s.SimpleName()
+	if !blockDef.MatchString(name) || z.inlined >= maxInline {
+		return nil, false
+	}
+	for _, in := range z.stack {
+		if in.name == name {
+			return nil, false
+		}
+	}
+	f, ok := z.defs[name]
+	if !ok {
+		return nil, true
+	}
+	z.inlined++
+	names := make(map[string]string)
+	for k := 0; k < len(s.Params) && k < len(f.Params); k++ {
+		names[f.Params[k].Callee.Name()] = rename(s.Params[k].Caller.Name())
+	}
+	z.stack = append(z.stack, inline{name: name, depth: z.depth})
+	defer func() { z.stack = z.stack[:len(z.stack)-1] }()
+	return z.stmts(f.Stmts, names), true
+}
+
+// deepKey returns the key of the statements ss with their nested statements.
+func deepKey(ss []*stmt) string {
+	var buf strings.Builder
+	for _, s := range ss {
+		buf.WriteString(s.key)
+		if s.kind == "newchan" {
+			fmt.Fprintf(&buf, " %d", s.size)
+		}
+		for _, b := range s.branches {
+			buf.WriteString("{" + deepKey(b) + "}")
+		}
+		buf.WriteString(";")
+	}
+	return buf.String()
+}
@@ -0,0 +1,128 @@
+package migodiff_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nickng/gospal/migodiff"
+	"github.com/nickng/gospal/migoinfer"
+	"github.com/nickng/gospal/ssa/build"
+	"github.com/nickng/migo"
+	"github.com/nickng/migo/parser"
+)
+
+func parse(t *testing.T, s string) *migo.Program {
+	prog, err := parser.Parse(strings.NewReader(s))
+	if err != nil {
+		t.Fatalf("cannot parse MiGo: %v", err)
+	}
+	return prog
+}
+
+// TestDiff tests the changes between MiGo programs.
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		old     string
+		new     string
+		changes []string
+	}{
+		{
+			name: "Renamed registers",
+			old:  `def main.main(): let t0 = newchan main.main0.t0_chan0, 0; spawn main.w(t0); recv t0; def main.w(ch): send ch;`,
+			new:  `def main.main(): let t3 = newchan main.main0.t3_chan0, 0; spawn main.w(t3); tau; recv t3; def main.w(c): send c;`,
+		},
+		{
+			name:    "Changed buffer size",
+			old:     `def main.main(): let t0 = newchan ch, 0; send t0;`,
+			new:     `def main.main(): let t0 = newchan ch, 2; send t0;`,
+			changes: []string{"main.main: changed let t0 = newchan ch, 0 to let t0 = newchan ch, 2"},
+		},
+		{
+			name: "Added and removed operations",
+			old:  `def main.main(): let t0 = newchan ch, 1; send t0; close t0;`,
+			new:  `def main.main(): let t0 = newchan ch, 1; spawn main.w(t0); send t0; def main.w(ch): recv ch;`,
+			changes: []string{
+				"main.main: added spawn main.w(t0)",
+				"main.main: removed close t0",
+				"main.w: added def main.w(ch)",
+			},
+		},
+		{
+			name: "Changed select cases",
+			old:  `def main.main(a, b): select case recv a; case recv b; send a; endselect;`,
+			new:  `def main.main(x, y): select case recv y; send x; case tau; endselect;`,
+			changes: []string{
+				"main.main: removed select case recv a",
+				"main.main: added select case tau",
+			},
+		},
+		{
+			name: "Changed branches",
+			old:  `def main.main(a): if send a; else endif;`,
+			new:  `def main.main(a): if send a; else close a; endif;`,
+			changes: []string{
+				"main.main: added close a",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var changes []string
+			for _, c := range migodiff.New(parse(t, test.old), parse(t, test.new)).Diff() {
+				changes = append(changes, c.String())
+			}
+			if strings.Join(changes, "\n") != strings.Join(test.changes, "\n") {
+				t.Errorf("expects changes:\n%s\nbut got:\n%s", strings.Join(test.changes, "\n"), strings.Join(changes, "\n"))
+			}
+		})
+	}
+}
+
+func infer(t *testing.T, file string) (*migo.Program, *migoinfer.Inferer) {
+	info, err := build.FromFiles(file).Default().Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	inferer := migoinfer.New(info, nil)
+	inferer.SetStable()
+	prog, err := inferer.Analyse()
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+	return prog, inferer
+}
+
+// TestDiffInferred tests the changes between the inferred MiGo of two versions
+// of a program, with their source positions.
+func TestDiffInferred(t *testing.T) {
+	old, oldInferer := infer(t, "testdata/v1/main.go")
+	new, newInferer := infer(t, "testdata/v2/main.go")
+	d := migodiff.New(old, new)
+	d.SetPositions(oldInferer.StmtPosition, newInferer.StmtPosition)
+	var changes []string
+	for _, c := range d.Diff() {
+		changes = append(changes, c.String())
+	}
+	expected := []string{
+		"main.fetch: changed let results = newchan main.fetch.results, 0 to let results = newchan main.fetch.results, 1 (main.go:8:17)",
+		"main.main: removed close timeout (main.go:20:7)",
+		"main.main: added let done = newchan main.main.done, 0 (main.go:20:14)",
+		"main.main: added spawn main.main$1(timeout, done) (main.go:21:2)",
+		"main.main: added recv done (main.go:25:2)",
+		"main.main$1: added def main.main$1(timeout, done)",
+	}
+	if strings.Join(changes, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expects changes:\n%s\nbut got:\n%s", strings.Join(expected, "\n"), strings.Join(changes, "\n"))
+	}
+}
+
+// TestDiffControlFlow tests that an edit of the control flow only, i.e. a
+// branch without channel operation, is not a change.
+func TestDiffControlFlow(t *testing.T) {
+	old, _ := infer(t, "testdata/debug/v1/main.go")
+	new, _ := infer(t, "testdata/debug/v2/main.go")
+	if changes := migodiff.New(old, new).Diff(); len(changes) > 0 {
+		t.Errorf("expects no change but got %v", changes)
+	}
+}
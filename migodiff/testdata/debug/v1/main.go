@@ -0,0 +1,17 @@
+package main
+
+// A loop receiving from a goroutine.
+
+var debug bool
+
+func main() {
+	ch := make(chan int)
+	go func() {
+		for i := 0; i < 3; i++ {
+			ch <- i
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		<-ch
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+// A loop receiving from a goroutine, after a branch which only prints.
+
+var debug bool
+
+func main() {
+	ch := make(chan int)
+	go func() {
+		for i := 0; i < 3; i++ {
+			ch <- i
+		}
+	}()
+	if debug {
+		println("x")
+	}
+	for i := 0; i < 3; i++ {
+		<-ch
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+func work(results chan int) {
+	results <- 42
+}
+
+func fetch(timeout chan struct{}) int {
+	results := make(chan int)
+	go work(results)
+	select {
+	case r := <-results:
+		return r
+	case <-timeout:
+		return 0
+	}
+}
+
+func main() {
+	timeout := make(chan struct{})
+	close(timeout)
+	fetch(timeout)
+}
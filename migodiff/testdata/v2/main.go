@@ -0,0 +1,27 @@
+package main
+
+func work(results chan int) {
+	results <- 42
+}
+
+func fetch(timeout chan struct{}) int {
+	results := make(chan int, 1)
+	go work(results)
+	select {
+	case r := <-results:
+		return r
+	case <-timeout:
+		return 0
+	}
+}
+
+func main() {
+	timeout := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		close(timeout)
+		close(done)
+	}()
+	<-done
+	fetch(timeout)
+}
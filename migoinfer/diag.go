@@ -0,0 +1,68 @@
+package migoinfer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/nickng/gospal/migoinfer/internal/migoinfer"
+)
+
+// Diagnostic is an error or warning reported during analysis, with severity,
+// code, source position, function and message.
+type Diagnostic = migoinfer.Diagnostic
+
+// Severity is the severity of a Diagnostic.
+type Severity = migoinfer.Severity
+
+const (
+	SeverityInfo    = migoinfer.SeverityInfo
+	SeverityWarning = migoinfer.SeverityWarning
+	SeverityError   = migoinfer.SeverityError
+)
+
+// Diagnostic codes.
+const (
+	CodeAnalysisFailed   = migoinfer.CodeAnalysisFailed
+	CodeInternal         = migoinfer.CodeInternal
+	CodeNonStaticBuffer  = migoinfer.CodeNonStaticBuffer
+	CodeUnknownCallee    = migoinfer.CodeUnknownCallee
+	CodeUnknownInvoke    = migoinfer.CodeUnknownInvoke
+	CodeInvokeLimit      = migoinfer.CodeInvokeLimit
+	CodeNilCall          = migoinfer.CodeNilCall
+	CodeNilStruct        = migoinfer.CodeNilStruct
+	CodeUnexportedChan   = migoinfer.CodeUnexportedChan
+	CodeUndefinedArg     = migoinfer.CodeUndefinedArg
+	CodeCarriedAmbiguous = migoinfer.CodeCarriedAmbiguous
+	CodeSpawnLoop        = migoinfer.CodeSpawnLoop
+	CodeModel            = migoinfer.CodeModel
+	CodeBudget           = migoinfer.CodeBudget
+	CodeCallGraph        = migoinfer.CodeCallGraph
+)
+
+// Diagnostics returns the diagnostics collected during analysis, in the order
+// they are reported.
+func (i *Inferer) Diagnostics() []Diagnostic {
+	return i.Env.Diagnostics
+}
+
+// WriteDiagnostics writes the diagnostics to w, one per line.
+func (i *Inferer) WriteDiagnostics(w io.Writer) error {
+	for _, d := range i.Env.Diagnostics {
+		if _, err := fmt.Fprintln(w, d.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteDiagnosticsJSON writes the diagnostics to w as a JSON array.
+func (i *Inferer) WriteDiagnosticsJSON(w io.Writer) error {
+	diags := i.Env.Diagnostics
+	if diags == nil {
+		diags = []Diagnostic{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(diags)
+}
@@ -0,0 +1,157 @@
+package migoinfer
+
+// Graphviz rendering of MiGo.
+//
+// Each definition is a cluster of statement nodes connected in control flow
+// order, starting from an entry point node. Branches of if, for and select
+// statements are labelled edges, and the branches join at the next statement.
+// Call and spawn statements are linked to the cluster of the callee, solid for
+// calls and dashed for spawns.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/nickng/migo"
+)
+
+// WriteGraphviz writes the inferred MiGo program to w in graphviz dot format.
+// In split mode, the program of each entry is a separate graph.
+func (i *Inferer) WriteGraphviz(w io.Writer) error {
+	if i.Split && (i.Library || i.Tests) {
+		for _, entry := range i.Entries {
+			if err := writeGraphviz(w, entry.Name, entry.MiGo.Funcs); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return writeGraphviz(w, "migo", i.outputFuncs())
+}
+
+// WriteGraphviz writes prog to w in graphviz dot format.
+func WriteGraphviz(w io.Writer, prog *migo.Program) error {
+	return writeGraphviz(w, "migo", prog.Funcs)
+}
+
+// dotNode is a pending control flow edge from a node, with the edge label.
+type dotNode struct {
+	id    string
+	label string
+}
+
+// dotGraph is a graph being written.
+type dotGraph struct {
+	w       *bufio.Writer
+	entries map[string]string // Definition name → entry node.
+	cluster map[string]string // Definition name → cluster.
+	links   []string          // Call/spawn edges, written after the clusters.
+	nNode   int
+}
+
+func writeGraphviz(w io.Writer, name string, funcs []*migo.Function) error {
+	g := &dotGraph{
+		w:       bufio.NewWriter(w),
+		entries: make(map[string]string),
+		cluster: make(map[string]string),
+	}
+	var defs []*migo.Function
+	for _, f := range funcs {
+		if f.IsEmpty() {
+			continue
+		}
+		g.cluster[f.Name] = fmt.Sprintf("cluster_%d", len(defs))
+		g.entries[f.Name] = fmt.Sprintf("%s_entry", g.cluster[f.Name])
+		defs = append(defs, f)
+	}
+	g.w.WriteString(fmt.Sprintf("digraph %q {\n", name))
+	g.w.WriteString("  compound=true;\n")
+	g.w.WriteString("  node [shape=box];\n")
+	for _, f := range defs {
+		g.writeFunc(f)
+	}
+	for _, link := range g.links {
+		g.w.WriteString(link)
+	}
+	g.w.WriteString("}\n")
+	return g.w.Flush()
+}
+
+// writeFunc writes f as a cluster.
+func (g *dotGraph) writeFunc(f *migo.Function) {
+	var params []string
+	for _, p := range f.Params {
+		params = append(params, p.Callee.Name())
+	}
+	g.w.WriteString(fmt.Sprintf("  subgraph %q {\n", g.cluster[f.Name]))
+	g.w.WriteString(fmt.Sprintf("    label=%q;\n", fmt.Sprintf("%s(%s)", f.SimpleName(), strings.Join(params, ", "))))
+	entry := g.entries[f.Name]
+	g.w.WriteString(fmt.Sprintf("    %q [shape=point];\n", entry))
+	g.writeStmts(f.Stmts, []dotNode{{id: entry}})
+	g.w.WriteString("  }\n")
+}
+
+// writeStmts writes the nodes of stmts, where the first statement follows
+// preds, and returns the nodes the next statement follows.
+func (g *dotGraph) writeStmts(stmts []migo.Statement, preds []dotNode) []dotNode {
+	for _, stmt := range stmts {
+		preds = g.writeStmt(stmt, preds)
+	}
+	return preds
+}
+
+func (g *dotGraph) writeStmt(stmt migo.Statement, preds []dotNode) []dotNode {
+	switch stmt := stmt.(type) {
+	case *migo.IfStatement:
+		id := g.node("if", "diamond", preds)
+		then := g.writeStmts(stmt.Then, []dotNode{{id: id, label: "then"}})
+		return append(then, g.writeStmts(stmt.Else, []dotNode{{id: id, label: "else"}})...)
+	case *migo.IfForStatement:
+		id := g.node("if "+stmt.ForCond, "diamond", preds)
+		then := g.writeStmts(stmt.Then, []dotNode{{id: id, label: "then"}})
+		return append(then, g.writeStmts(stmt.Else, []dotNode{{id: id, label: "else"}})...)
+	case *migo.SelectStatement:
+		id := g.node("select", "diamond", preds)
+		var exits []dotNode
+		for k, c := range stmt.Cases {
+			exits = append(exits, g.writeStmts(c, []dotNode{{id: id, label: fmt.Sprintf("case %d", k)}})...)
+		}
+		return exits
+	case *migo.CallStatement:
+		id := g.node(stmt.String(), "box", preds)
+		g.link(id, stmt.Name, "solid")
+		return []dotNode{{id: id}}
+	case *migo.SpawnStatement:
+		id := g.node(stmt.String(), "box", preds)
+		g.link(id, stmt.Name, "dashed")
+		return []dotNode{{id: id}}
+	}
+	return []dotNode{{id: g.node(stmt.String(), "box", preds)}}
+}
+
+// node writes a node following preds, and returns its ID.
+func (g *dotGraph) node(label, shape string, preds []dotNode) string {
+	id := fmt.Sprintf("n%d", g.nNode)
+	g.nNode++
+	g.w.WriteString(fmt.Sprintf("    %q [label=%q, shape=%s];\n", id, label, shape))
+	for _, pred := range preds {
+		if pred.label != "" {
+			g.w.WriteString(fmt.Sprintf("    %q -> %q [label=%q];\n", pred.id, id, pred.label))
+		} else {
+			g.w.WriteString(fmt.Sprintf("    %q -> %q;\n", pred.id, id))
+		}
+	}
+	return id
+}
+
+// link adds a call/spawn edge from node id to the definition callee. Callees
+// not in the graph (e.g. removed without communication) are not linked.
+func (g *dotGraph) link(id, callee, style string) {
+	entry, ok := g.entries[callee]
+	if !ok {
+		return
+	}
+	g.links = append(g.links, fmt.Sprintf("  %q -> %q [lhead=%q, style=%s];\n", id, entry, g.cluster[callee], style))
+}
@@ -0,0 +1,31 @@
+package migoinfer
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/nickng/gospal/migoinfer/internal/migoinfer"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrNoEntryFunc = errors.New("cannot find entry function")
+	ErrNoTestFuncs = errors.New("cannot find test functions (check if test files are loaded)")
+	ErrPanic       = errors.New("analysis panicked")
+)
+
+// AnalysisError is an error which stops the analysis of a function, the
+// function is left out of (or partially analysed in) the inferred program.
+type AnalysisError = migoinfer.AnalysisError
+
+// AnalysisErrors is the errors of functions which failed to be analysed.
+type AnalysisErrors []error
+
+func (errs AnalysisErrors) Error() string {
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("%d error(s) during analysis", len(errs)))
+	for _, err := range errs {
+		buf.WriteString("\n\t" + err.Error())
+	}
+	return buf.String()
+}
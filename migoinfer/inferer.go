@@ -1,17 +1,16 @@
 package migoinfer
 
 import (
-	"fmt"
+	"context"
 	"io"
 	"io/ioutil"
-	"log"
 
-	"github.com/nickng/gospal/callctx"
 	"github.com/nickng/gospal/funcs"
 	"github.com/nickng/gospal/migoinfer/internal/migoinfer"
 	"github.com/nickng/gospal/ssa"
 	"github.com/nickng/gospal/store"
 	"github.com/nickng/migo"
+	"github.com/pkg/errors"
 )
 
 // Inferer is the main MiGo inference entry point.
@@ -23,6 +22,16 @@ type Inferer struct {
 
 	Raw bool
 
+	Library bool    // Analyse exported functions of library packages.
+	Tests   bool    // Analyse test functions.
+	Split   bool    // Output one MiGo program per entry in library/tests mode.
+	Entries []Entry // MiGo programs of entries in library/tests mode.
+	Workers int     // Number of entries analysed in parallel in library/tests mode.
+	Stable  bool    // Use stable names and ordering of definitions.
+
+	Positions bool   // Write source positions as trailing comments.
+	Format    string // Output format (FormatText, FormatJSON or FormatDOT).
+
 	outWriter io.Writer // Output stream.
 	errWriter io.Writer // Error stream.
 	*migoinfer.Logger
@@ -49,69 +58,215 @@ func (i *Inferer) SetEntryFunc(path string) {
 	i.EntryFunc = path
 }
 
-func (i *Inferer) Analyse() {
-	go i.Env.HandleErrors()
+// SetCallGraph sets the call graph algorithm used for resolving dynamic calls,
+// e.g. "cha", "pta", "rta" or "static".
+func (i *Inferer) SetCallGraph(algo string) {
+	i.Env.CallGraphAlgo = algo
+}
+
+// SetInvokeLimit sets the maximum number of implementations considered for
+// interface method calls which cannot be resolved (0 means no limit).
+func (i *Inferer) SetInvokeLimit(n int) {
+	i.Env.InvokeLimit = n
+}
+
+// SetInvokeAllow restricts the implementations considered for interface method
+// calls which cannot be resolved to methods of the given types
+// (format: import/path.TypeName).
+func (i *Inferer) SetInvokeAllow(types ...string) {
+	i.Env.InvokeAllow = types
+}
+
+// SetSpawnUnroll sets the maximum trip count of loops spawning goroutines
+// which are replicated instead of modelled as recursion (0 means never).
+func (i *Inferer) SetSpawnUnroll(n int) {
+	i.Env.SpawnUnroll = n
+}
+
+// SetMaxDepth sets the maximum call depth of the analysis (0 means no limit),
+// deeper calls are truncated to an opaque action.
+func (i *Inferer) SetMaxDepth(n int) {
+	i.Env.MaxDepth = n
+}
+
+// SetMaxSteps sets the maximum number of instruction visits of the analysis
+// (0 means no limit), calls after the limit is reached are truncated to an
+// opaque action.
+func (i *Inferer) SetMaxSteps(n int) {
+	i.Env.MaxSteps = n
+}
+
+// SetMaxContexts sets the maximum number of context variants of each function
+// (0 means no limit), calls needing more variants are truncated to an opaque
+// action.
+func (i *Inferer) SetMaxContexts(n int) {
+	i.Env.MaxContexts = n
+}
+
+// SetContext sets the context of the analysis, calls after ctx is done (e.g.
+// deadline exceeded) are truncated to an opaque action.
+func (i *Inferer) SetContext(ctx context.Context) {
+	i.Env.Ctx = ctx
+}
+
+// SetLibrary sets library mode, where every exported function and method of
+// the non-main packages is an entry. If split is true, the output is one MiGo
+// program per entry, otherwise a combined MiGo program.
+func (i *Inferer) SetLibrary(split bool) {
+	i.Library = true
+	i.Split = split
+}
+
+// SetTests sets tests mode, where every test function (TestXxx) of the
+// packages is an entry, and subtests are entries as well as called by their
+// tests. If split is true, the output is one MiGo program per entry,
+// otherwise a combined MiGo program. The program should be built with test
+// files.
+func (i *Inferer) SetTests(split bool) {
+	i.Tests = true
+	i.Split = split
+}
+
+// SetWorkers sets the number of entries analysed in parallel in library or
+// tests mode. With more than one worker, each entry is analysed independently
+// (i.e. calls are not memoised across entries, and budgets apply per entry)
+// and the results are merged in the order of the entries, so the output is
+// deterministic. The main functions of main packages are not analysed in
+// parallel.
+func (i *Inferer) SetWorkers(n int) {
+	i.Workers = n
+}
+
+// Output formats.
+const (
+	FormatText = "text" // MiGo text.
+	FormatJSON = "json" // JSON, see JSONProgram.
+	FormatDOT  = "dot"  // Graphviz dot, see WriteGraphviz.
+)
+
+// SetFormat sets the output format, FormatText (default), FormatJSON or
+// FormatDOT.
+func (i *Inferer) SetFormat(format string) {
+	i.Format = format
+}
+
+// SetStable sets stable naming and ordering of the MiGo output: local names
+// are the source variable names, block definitions are numbered in order of
+// calls, and definitions are sorted by name. Stable output changes little
+// for small source changes, so it is suitable for checking in and diffing.
+func (i *Inferer) SetStable() {
+	i.Stable = true
+}
+
+// Analyse infers the MiGo program and writes it to the output.
+//
+// If the analysis cannot start (e.g. there is no entry function), Analyse
+// returns a nil program and the error. If the analysis of some functions
+// failed, Analyse returns the program inferred without them and an
+// AnalysisErrors of the failures.
+func (i *Inferer) Analyse() (prog *migo.Program, err error) {
 	// Sync error ignored. See https://github.com/uber-go/zap/issues/328
 	defer i.Logger.Sync()
+	defer func() {
+		if r := recover(); r != nil {
+			prog, err = nil, errors.Wrapf(ErrPanic, "%v", r)
+		}
+	}()
 
-	pkg := migoinfer.NewPackage(&i.Env)
-	pkg.SetLogger(i.Logger)
-	// Package/global variables initialisation.
-	for _, p := range i.Info.Prog.AllPackages() {
-		pkg.InitGlobals(p)
-		pkg.VisitInit(p)
-	}
-	if i.EntryFunc == "" { // main.main
+	i.visitInits(&i.Env)
+	var entries []string
+	if i.Library || i.Tests {
+		fns, err := i.entryFuncs()
+		if err != nil {
+			return nil, err
+		}
+		if i.Workers > 1 {
+			entries = i.analyseParallel(fns)
+		} else {
+			entries = i.analyseEntries(fns)
+		}
+	} else if i.EntryFunc == "" { // main.main
 		// Find main packages to start analysis.
 		mains, err := ssa.MainPkgs(i.Info.Prog, false)
 		if err != nil {
-			log.Fatal("Cannot find main package:", err)
+			return nil, errors.Wrap(err, "cannot find main package")
 		}
 		// Call context
-		ctx := callctx.Toplevel()
+		ctx := i.Env.Toplevel
 		if l, ok := ctx.(store.Logger); ok {
 			l.SetLog(i.errWriter)
 		}
 		for _, main := range mains {
 			if mainFn := main.Func("main"); mainFn != nil {
-				mainDef := funcs.MakeCall(funcs.MakeDefinition(mainFn), nil, nil)
+				mainDef, _ := funcs.MakeCall(funcs.MakeDefinition(mainFn), nil, nil) // No call site to mismatch.
 				mainFnAnalyser := migoinfer.NewFunction(mainDef, ctx, &i.Env)
 				mainFnAnalyser.SetLogger(i.Logger)
+				mainFnAnalyser.DeclareGlobals()
 				mainFnAnalyser.EnterFunc(mainDef.Function())
 			}
 		}
 	} else {
 		fn, err := i.Info.FindFunc(i.EntryFunc)
-		if err != nil {
-			log.Fatalf("Cannot find entry function %s", i.EntryFunc)
+		if err != nil || fn == nil {
+			return nil, errors.Wrap(ErrNoEntryFunc, i.EntryFunc)
 		}
-		ctx := callctx.Toplevel()
+		ctx := i.Env.Toplevel
 		if l, ok := ctx.(store.Logger); ok {
 			l.SetLog(i.errWriter)
 		}
 		if fn != nil {
-			fnDef := funcs.MakeCall(funcs.MakeDefinition(fn), nil, nil)
+			fnDef, _ := funcs.MakeCall(funcs.MakeDefinition(fn), nil, nil) // No call site to mismatch.
 			fnAnalyser := migoinfer.NewFunction(fnDef, ctx, &i.Env)
 			fnAnalyser.SetLogger(i.Logger)
+			fnAnalyser.DeclareGlobals()
 			fnAnalyser.EnterFunc(fnDef.Function())
 		}
 	}
 	if !i.Raw {
+		markComm(i.Env.Prog)
 		i.Env.Prog.CleanUp()
+		removeInvalidLoopCalls(i.Env.Prog)
 	}
-	if i.EntryFunc == "" { // main.main
-		// Print main.main first.
-		for _, f := range i.Env.Prog.Funcs {
-			if f.SimpleName() == "main.main" {
-				fmt.Fprintf(i.outWriter, f.String())
-			}
+	if i.Stable {
+		i.Env.Prog = i.Env.StableProgram()
+	}
+	if i.Library || i.Tests {
+		i.setEntries(entries)
+	}
+	i.MiGo = i.Env.Prog
+	switch i.Format {
+	case FormatJSON:
+		if err := i.WriteJSON(i.outWriter); err != nil {
+			return i.MiGo, errors.Wrap(err, "cannot write JSON")
 		}
-		for _, f := range i.Env.Prog.Funcs {
-			if f.SimpleName() != "main.main" {
-				fmt.Fprintf(i.outWriter, f.String())
+	case FormatDOT:
+		if err := i.WriteGraphviz(i.outWriter); err != nil {
+			return i.MiGo, errors.Wrap(err, "cannot write graphviz")
+		}
+	default:
+		if i.Library || i.Tests {
+			i.writeEntries()
+		} else if i.EntryFunc == "" { // main.main
+			// Print main.main first.
+			for _, f := range i.outputFuncs() {
+				i.writeFunc(i.outWriter, f)
 			}
 		}
 	}
+	if len(i.Env.Errors) > 0 {
+		return i.MiGo, AnalysisErrors(i.Env.Errors)
+	}
+	return i.MiGo, nil
+}
+
+// visitInits initialises the package/global variables of all packages in env.
+func (i *Inferer) visitInits(env *migoinfer.Environment) {
+	pkg := migoinfer.NewPackage(env)
+	pkg.SetLogger(i.Logger)
+	for _, p := range i.Info.Prog.AllPackages() {
+		pkg.InitGlobals(p)
+		pkg.VisitInit(p)
+	}
 }
 
 // AddLogFiles extends current Logger and writes additional log to files.
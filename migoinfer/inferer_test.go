@@ -2,15 +2,20 @@ package migoinfer_test
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"io/ioutil"
 	"log"
 	"os"
 	"path"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/nickng/gospal/migoinfer"
+	"github.com/nickng/gospal/migoinfer/models"
 	"github.com/nickng/gospal/ssa/build"
+	"github.com/nickng/migo"
 )
 
 func init() {
@@ -57,42 +62,436 @@ func TestPrimitives(t *testing.T) {
 		{"Select on nil channel", "nilchan2"},
 		{"Explicitly declared nil channel", "nilchan3"},
 		{"nil channel reuse with 2 channel", "nilchan4"},
+		{"Dynamic call through function values", "dynamic-call"},
+		{"Interfaces from parameter", "iface-cha"},
+		{"Context-sensitive calls", "context-variant"},
+		{"Package-level channels", "global-chan"},
+		{"Channels over channels", "chan-of-chan"},
+		{"Channels over channels through parameters", "carried-param"},
+		{"Channels over channels of the same type", "carried-choice"},
+		{"Multiple return values", "multi-return"},
+		{"Spawning inside loops", "spawn-loop"},
+		{"Spawning after breaking out of loops", "spawn-after-break"},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			testdir := path.Join(tdRoot, test.srcDir)
-			migofile := path.Join(testdir, MiGoExpect)
-			migob, err := ioutil.ReadFile(migofile)
-			if err != nil {
-				t.Errorf("cannot read output file: %v", err)
-			}
+			testInfer(t, path.Join(tdRoot, test.srcDir))
+		})
+	}
+}
+
+// TestStdlibModels tests the models of standard library functions, with the
+// standard library loaded from the stand-ins in stdlib.
+func TestStdlibModels(t *testing.T) {
+	tests := []struct {
+		name   string
+		srcDir string // Input Go source dirs.
+	}{
+		{"Timers and tickers", "model-time"},
+		{"Signals", "model-signal"},
+		{"sync.Once", "model-once"},
+		{"sync.Cond", "model-cond"},
+		{"Spawning modelled functions", "model-go"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := path.Join(tdRoot, test.srcDir)
+			conf := build.FromFiles(goFiles(t, dir)...).WithGOROOT(path.Join(tdRoot, "stdlib"))
+			testInferBuild(t, path.Join(dir, MiGoExpect), conf)
+		})
+	}
+}
+
+// TestConcurrentInferers tests that inferers are independent, i.e. running
+// them concurrently does not change the output.
+func TestConcurrentInferers(t *testing.T) {
+	for _, dir := range []string{"nilchan", "nilchan2", "select", "context-variant", "multi-return"} {
+		dir := dir
+		t.Run(dir, func(t *testing.T) {
+			t.Parallel()
+			testInfer(t, path.Join(tdRoot, dir))
+		})
+	}
+}
+
+// TestModels tests user-defined models of functions.
+func TestModels(t *testing.T) {
+	models.Register("main.notify", models.Func(func(s models.Site) {
+		if ch := s.Arg(0); ch != nil {
+			s.AddStmts(&migo.SendStatement{Chan: ch.Name()})
+		}
+	}))
+	models.Register("main.do", models.Func(func(s models.Site) {
+		s.Call(0)
+	}))
+	defer models.Unregister("main.notify")
+	defer models.Unregister("main.do")
+	testInfer(t, path.Join(tdRoot, "model"))
+}
+
+// TestLibrary tests library mode with combined and split output.
+func TestLibrary(t *testing.T) {
+	t.Run("Combined", func(t *testing.T) {
+		testInferExpect(t, path.Join(tdRoot, "library"), MiGoExpect, func(i *migoinfer.Inferer) {
+			i.SetLibrary(false)
+		})
+	})
+	t.Run("Split", func(t *testing.T) {
+		testInferExpect(t, path.Join(tdRoot, "library"), "migoinfer-split.expect", func(i *migoinfer.Inferer) {
+			i.SetLibrary(true)
+		})
+	})
+	t.Run("Parallel", func(t *testing.T) {
+		// Output is deterministic regardless of scheduling.
+		for n := 0; n < 5; n++ {
+			testInferExpect(t, path.Join(tdRoot, "library"), "migoinfer-parallel.expect", func(i *migoinfer.Inferer) {
+				i.SetLibrary(false)
+				i.SetWorkers(4)
+			})
+		}
+	})
+	t.Run("Globals", func(t *testing.T) {
+		// rta cannot build a call graph without a main package, global
+		// channels are tracked with cha instead.
+		var inferer *migoinfer.Inferer
+		testInferExpect(t, path.Join(tdRoot, "library-global"), MiGoExpect, func(i *migoinfer.Inferer) {
+			i.SetLibrary(false)
+			i.SetCallGraph("rta")
+			inferer = i
+		})
+		var found bool
+		for _, d := range inferer.Diagnostics() {
+			found = found || d.Code == migoinfer.CodeCallGraph
+		}
+		if !found {
+			t.Errorf("expects %s diagnostic but got %v", migoinfer.CodeCallGraph, inferer.Diagnostics())
+		}
+	})
+}
+
+// TestGoTests tests tests mode.
+func TestGoTests(t *testing.T) {
+	testInferExpect(t, path.Join(tdRoot, "gotest"), MiGoExpect, func(i *migoinfer.Inferer) {
+		i.SetTests(false)
+	})
+	t.Run("External", func(t *testing.T) {
+		// The test files, including the external test package, are loaded
+		// with the package.
+		dir := path.Join(tdRoot, "gotest-xtest")
+		conf := build.FromFiles(path.Join(dir, "queue.go")).WithTests().WithGOROOT(path.Join(tdRoot, "stdlib"))
+		testInferBuild(t, path.Join(dir, MiGoExpect), conf, func(i *migoinfer.Inferer) {
+			i.SetTests(false)
+		})
+	})
+}
+
+// TestAnalysisError tests that a failed function is reported and the rest of
+// the program is still inferred.
+func TestAnalysisError(t *testing.T) {
+	models.Register("main.fail", models.Func(func(s models.Site) {
+		panic("fail")
+	}))
+	defer models.Unregister("main.fail")
+	testdir := path.Join(tdRoot, "analysis-error")
+	info, err := build.FromFiles(path.Join(testdir, "main.go")).Default().Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	var buf bytes.Buffer
+	inferer := migoinfer.New(info, nil)
+	inferer.SetOutput(&buf)
+	prog, err := inferer.Analyse()
+	errs, ok := err.(migoinfer.AnalysisErrors)
+	if !ok || len(errs) != 1 {
+		t.Fatalf("expects 1 analysis error but got: %v", err)
+	}
+	if aerr, ok := errs[0].(migoinfer.AnalysisError); !ok || aerr.Func != "main.broken" {
+		t.Errorf("expects analysis error in main.broken but got: %v", errs[0])
+	}
+	if diags := inferer.Diagnostics(); len(diags) != 1 || diags[0].Code != migoinfer.CodeAnalysisFailed {
+		t.Errorf("expects 1 %s diagnostic but got: %v", migoinfer.CodeAnalysisFailed, diags)
+	}
+	if prog == nil {
+		t.Fatal("expects partial program")
+	}
+	migob, err := ioutil.ReadFile(path.Join(testdir, MiGoExpect))
+	if err != nil {
+		t.Fatalf("cannot read output file: %v", err)
+	}
+	if want, got := string(bytes.TrimSpace(migob)), strings.TrimSpace(buf.String()); want != got {
+		t.Errorf("Output does not match\nExpect:\n%s\nGot:\n%s\n", want, got)
+	}
+}
+
+// TestDiagnostics tests that approximations are reported as diagnostics.
+func TestDiagnostics(t *testing.T) {
+	var inferer *migoinfer.Inferer
+	testInferExpect(t, path.Join(tdRoot, "diagnostics"), MiGoExpect, func(i *migoinfer.Inferer) {
+		inferer = i
+	})
+	diags := inferer.Diagnostics()
+	if len(diags) != 1 {
+		t.Fatalf("expects 1 diagnostic but got %d: %v", len(diags), diags)
+	}
+	d := diags[0]
+	if d.Severity != migoinfer.SeverityWarning || d.Code != migoinfer.CodeNonStaticBuffer {
+		t.Errorf("expects %s warning but got %v", migoinfer.CodeNonStaticBuffer, d)
+	}
+	if d.Func != "main.work" || d.Pos.Line != 4 {
+		t.Errorf("expects diagnostic at main.work line 4 but got %v", d)
+	}
+	var buf bytes.Buffer
+	if err := inferer.WriteDiagnosticsJSON(&buf); err != nil {
+		t.Fatalf("cannot write diagnostics: %v", err)
+	}
+	var decoded []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("cannot decode diagnostics: %v", err)
+	}
+	if len(decoded) != 1 || decoded[0]["severity"] != "warning" || decoded[0]["code"] != migoinfer.CodeNonStaticBuffer {
+		t.Errorf("unexpected JSON diagnostics: %s", buf.String())
+	}
+}
 
-			files, err := ioutil.ReadDir(testdir)
-			if err != nil {
-				t.Errorf("cannot read dir: %v", err)
+// TestSpawnLoop tests that the spawning loops which are not replicated are
+// reported, and the loops of unknown bound are approximated by choices.
+func TestSpawnLoop(t *testing.T) {
+	tests := []struct {
+		dir    string
+		reason string // Empty if the loop does not spawn.
+		approx bool   // Approximated by a choice.
+	}{
+		{dir: "spawn-loop", reason: "unknown bound", approx: true},
+		{dir: "overwrite-chan", reason: "body of many blocks"},
+		{dir: "spawn-after-break"},
+	}
+	for _, test := range tests {
+		t.Run(test.dir, func(t *testing.T) {
+			var inferer *migoinfer.Inferer
+			testInferExpect(t, path.Join(tdRoot, test.dir), MiGoExpect, func(i *migoinfer.Inferer) {
+				inferer = i
+			})
+			var found bool
+			for _, d := range inferer.Diagnostics() {
+				found = found || d.Code == migoinfer.CodeSpawnLoop && strings.Contains(d.Message, test.reason)
 			}
-			var filenames []string
-			for _, file := range files {
-				if path.Ext(file.Name()) == ".go" {
-					filenames = append(filenames, path.Join(testdir, file.Name()))
-				}
+			if test.reason == "" && found {
+				t.Errorf("expects no %s diagnostic but got %v", migoinfer.CodeSpawnLoop, inferer.Diagnostics())
+			} else if test.reason != "" && !found {
+				t.Errorf("expects %s diagnostic of %s but got %v", migoinfer.CodeSpawnLoop, test.reason, inferer.Diagnostics())
 			}
-			if len(filenames) > 0 {
-				info, err := build.FromFiles(filenames...).Default().Build()
-				if err != nil {
-					t.Errorf("build failed: %v", err)
+			var approx bool
+			for _, f := range inferer.MiGo.Funcs {
+				for _, s := range f.Stmts {
+					approx = approx || inferer.LoopApprox(s)
 				}
-				var buf bytes.Buffer
-				inferer := migoinfer.New(info, nil)
-				inferer.Raw = false
-				inferer.SetOutput(&buf)
-				inferer.Analyse()
-				if want, got := string(bytes.TrimSpace(migob)), strings.TrimSpace(buf.String()); want != got {
-					t.Errorf("Output does not match\nExpect:\n%s\nGot:\n%s\n", want, got)
+			}
+			if approx != test.approx {
+				t.Errorf("expects loop approximated %t but got %t", test.approx, approx)
+			}
+		})
+	}
+}
+
+// TestStable tests that stable output does not change for source edits which
+// do not change communication.
+func TestStable(t *testing.T) {
+	for _, version := range []string{"v1", "v2"} {
+		t.Run(version, func(t *testing.T) {
+			testInferExpect(t, path.Join(tdRoot, "stable", version), path.Join("..", MiGoExpect), func(i *migoinfer.Inferer) {
+				i.SetStable()
+			})
+		})
+	}
+}
+
+// TestSourceMap tests source positions as trailing comments and the source
+// map of the statements.
+func TestSourceMap(t *testing.T) {
+	var inferer *migoinfer.Inferer
+	testInferExpect(t, path.Join(tdRoot, "srcmap"), MiGoExpect, func(i *migoinfer.Inferer) {
+		i.SetPositions()
+		inferer = i
+	})
+	srcMap := inferer.SourceMap()
+	main, ok := srcMap["main.main"]
+	if !ok {
+		t.Fatalf("expects main.main in source map but got: %v", srcMap)
+	}
+	if main.Pos == nil || main.Pos.Line != 8 {
+		t.Errorf("expects main.main at line 8 but got %v", main.Pos)
+	}
+	if len(main.Stmts) != 3 {
+		t.Fatalf("expects 3 statements in main.main but got %d", len(main.Stmts))
+	}
+	recv := main.Stmts[2]
+	if recv.Index != 2 || recv.Pos == nil || recv.Pos.Line != 11 || !strings.Contains(recv.Instr, "<-") {
+		t.Errorf("expects receive at line 11 but got %+v", recv)
+	}
+	var buf bytes.Buffer
+	if err := inferer.WriteSourceMap(&buf); err != nil {
+		t.Fatalf("cannot write source map: %v", err)
+	}
+	var decoded migoinfer.SourceMap
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("cannot decode source map: %v", err)
+	}
+	if got := decoded["main.producer"]; len(got.Stmts) != 2 || got.Stmts[1].Pos.Line != 5 {
+		t.Errorf("unexpected source map of main.producer: %+v", got)
+	}
+}
+
+// TestJSON tests the JSON encoding of the definitions and statement trees.
+func TestJSON(t *testing.T) {
+	var inferer *migoinfer.Inferer
+	testInferExpect(t, path.Join(tdRoot, "for-select"), MiGoExpect, func(i *migoinfer.Inferer) {
+		inferer = i
+	})
+	var buf bytes.Buffer
+	if err := inferer.WriteJSON(&buf); err != nil {
+		t.Fatalf("cannot write JSON: %v", err)
+	}
+	var prog migoinfer.JSONProgram
+	if err := json.Unmarshal(buf.Bytes(), &prog); err != nil {
+		t.Fatalf("cannot decode JSON: %v", err)
+	}
+	if len(prog.Funcs) != 4 || prog.Funcs[0].Name != "main.main" {
+		t.Fatalf("expects 4 definitions with main.main first but got %+v", prog.Funcs)
+	}
+	main := prog.Funcs[0]
+	if len(main.Stmts) != 3 || main.Stmts[0].Kind != migoinfer.KindNewChan || main.Stmts[0].Size == nil || *main.Stmts[0].Size != 1 {
+		t.Errorf("unexpected statements of main.main: %+v", main.Stmts)
+	}
+	if call := main.Stmts[2]; call.Kind != migoinfer.KindCall || call.Name != "main.main#1" || len(call.Args) != 2 {
+		t.Errorf("expects call to main.main#1 but got %+v", call)
+	}
+	if main.Stmts[0].Pos == nil || main.Stmts[0].Pos.Line == 0 {
+		t.Errorf("expects position of newchan but got %+v", main.Stmts[0].Pos)
+	}
+	loop := prog.Funcs[1]
+	if len(loop.Params) != 2 || len(loop.Stmts) != 1 || loop.Stmts[0].Kind != migoinfer.KindSelect {
+		t.Fatalf("expects select in %s but got %+v", loop.Name, loop)
+	}
+	cases := loop.Stmts[0].Cases
+	if len(cases) != 2 || cases[0][0].Kind != migoinfer.KindRecv || cases[1][0].Kind != migoinfer.KindSend || cases[1][0].Chan != "t1" {
+		t.Errorf("unexpected select cases: %+v", cases)
+	}
+}
+
+// TestGraphviz tests the graphviz rendering of definitions and call/spawn
+// edges.
+func TestGraphviz(t *testing.T) {
+	var inferer *migoinfer.Inferer
+	testInferExpect(t, path.Join(tdRoot, "srcmap"), MiGoExpect, func(i *migoinfer.Inferer) {
+		i.SetPositions()
+		inferer = i
+	})
+	var buf bytes.Buffer
+	if err := inferer.WriteGraphviz(&buf); err != nil {
+		t.Fatalf("cannot write graphviz: %v", err)
+	}
+	dot := buf.String()
+	for _, want := range []string{
+		`digraph "migo" {`,
+		`subgraph "cluster_0" {`,
+		`label="main.main()";`,
+		`label="main.producer(ch)";`,
+		`[label="recv t0", shape=box];`,
+		`-> "cluster_1_entry" [lhead="cluster_1", style=dashed];`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("expects %s in graphviz output but got:\n%s", want, dot)
+		}
+	}
+}
+
+// TestBudgets tests that calls are truncated when analysis budgets run out.
+func TestBudgets(t *testing.T) {
+	expired, cancel := context.WithDeadline(context.Background(), time.Unix(0, 0))
+	defer cancel()
+	tests := []struct {
+		name   string
+		expect string
+		setup  func(*migoinfer.Inferer)
+	}{
+		{"Depth", "migoinfer-depth.expect", func(i *migoinfer.Inferer) { i.SetMaxDepth(2) }},
+		{"Steps", "migoinfer-steps.expect", func(i *migoinfer.Inferer) { i.SetMaxSteps(10) }},
+		{"Contexts", "migoinfer-contexts.expect", func(i *migoinfer.Inferer) { i.SetMaxContexts(1) }},
+		{"Deadline", "migoinfer-deadline.expect", func(i *migoinfer.Inferer) { i.SetContext(expired) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var inferer *migoinfer.Inferer
+			testInferExpect(t, path.Join(tdRoot, "budget"), test.expect, test.setup, func(i *migoinfer.Inferer) {
+				inferer = i
+			})
+			diags := inferer.Diagnostics()
+			if len(diags) == 0 {
+				t.Fatal("expects truncated calls to be reported")
+			}
+			for _, d := range diags {
+				if d.Code != migoinfer.CodeBudget {
+					t.Errorf("expects %s diagnostic but got %v", migoinfer.CodeBudget, d)
 				}
-			} else {
-				t.Fail()
 			}
 		})
 	}
 }
+
+// testInfer runs inference on the Go source files in testdir and compares the
+// output with the expected MiGo.
+func testInfer(t *testing.T, testdir string) {
+	testInferExpect(t, testdir, MiGoExpect)
+}
+
+// testInferExpect runs inference on the Go source files in testdir with the
+// inferer configured by setup, and compares the output with the expected MiGo
+// in file expect.
+func testInferExpect(t *testing.T, testdir, expect string, setup ...func(*migoinfer.Inferer)) {
+	testInferBuild(t, path.Join(testdir, expect), build.FromFiles(goFiles(t, testdir)...), setup...)
+}
+
+// goFiles returns the Go source files in testdir.
+func goFiles(t *testing.T, testdir string) []string {
+	files, err := ioutil.ReadDir(testdir)
+	if err != nil {
+		t.Errorf("cannot read dir: %v", err)
+	}
+	var filenames []string
+	for _, file := range files {
+		if path.Ext(file.Name()) == ".go" {
+			filenames = append(filenames, path.Join(testdir, file.Name()))
+		}
+	}
+	if len(filenames) == 0 {
+		t.FailNow()
+	}
+	return filenames
+}
+
+// testInferBuild runs inference on the program of conf with the inferer
+// configured by setup, and compares the output with the expected MiGo in file
+// migofile.
+func testInferBuild(t *testing.T, migofile string, conf build.Configurer, setup ...func(*migoinfer.Inferer)) {
+	migob, err := ioutil.ReadFile(migofile)
+	if err != nil {
+		t.Errorf("cannot read output file: %v", err)
+	}
+	info, err := conf.Default().Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	var buf bytes.Buffer
+	inferer := migoinfer.New(info, nil)
+	inferer.Raw = false
+	inferer.SetOutput(&buf)
+	for _, f := range setup {
+		f(inferer)
+	}
+	if _, err := inferer.Analyse(); err != nil {
+		t.Errorf("analysis failed: %v", err)
+	}
+	if want, got := string(bytes.TrimSpace(migob)), strings.TrimSpace(buf.String()); want != got {
+		t.Errorf("Output does not match\nExpect:\n%s\nGot:\n%s\n", want, got)
+	}
+}
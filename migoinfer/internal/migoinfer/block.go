@@ -9,6 +9,7 @@ import (
 	"github.com/nickng/gospal/callctx"
 	"github.com/nickng/gospal/funcs"
 	"github.com/nickng/gospal/loop"
+	"github.com/nickng/gospal/store"
 	"github.com/nickng/migo"
 	"golang.org/x/tools/go/ssa"
 )
@@ -17,6 +18,7 @@ type BlockData struct {
 	visitNode *block.VisitNode
 	migoFunc  *migo.Function
 	emitted   bool // Ensures if-block only gets 1 MiGo statement.
+	inlined   bool // Replicated in the loop header, not a MiGo function.
 }
 
 // Block is an analyser of ssa.BasicBlock.
@@ -29,6 +31,7 @@ type Block struct {
 	Env             *Environment    // Program environment.
 
 	Loop      *loop.Detector // Loop detector.
+	decls     []store.Key    // Names declared at entry (not parameters).
 	*Exported                // Local variables.
 	*Logger
 }
@@ -52,6 +55,9 @@ func NewBlock(fn *funcs.Instance, ctx callctx.Context, env *Environment) *Block
 			}
 		}
 	}
+	for i, data := range blks {
+		env.recordFuncOrigin(data.migoFunc, fn.Function().Blocks[i])
+	}
 	b := Block{
 		VisitGraph: block.NewVisitGraph(false),
 		meta:       blks,
@@ -67,6 +73,9 @@ func (b *Block) EnterBlk(blk *ssa.BasicBlock) {
 	b.Debugf("%s Enter %s#%d",
 		b.Module(), b.Callee.UniqName(), blk.Index)
 	for _, name := range b.Exported.names {
+		if blk.Index == 0 && b.isDecl(name) {
+			continue
+		}
 		b.meta[blk.Index].migoFunc.AddParams(&migo.Parameter{Callee: name, Caller: name})
 	}
 	if !b.NodeVisited(b.meta[blk.Index].visitNode) {
@@ -75,6 +84,16 @@ func (b *Block) EnterBlk(blk *ssa.BasicBlock) {
 	}
 }
 
+// isDecl returns true if name is declared at entry of the function.
+func (b *Block) isDecl(name store.Key) bool {
+	for _, decl := range b.decls {
+		if decl == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (b *Block) JumpBlk(curr *ssa.BasicBlock, next *ssa.BasicBlock) {
 	b.Loop.Detect(curr, next)
 	b.Debugf("%s Jump %s#%d → %d",
@@ -103,7 +122,8 @@ func (b *Block) CurrBlk() *ssa.BasicBlock {
 
 func (b *Block) PrevBlk() *ssa.BasicBlock {
 	if b.Size() < 1 {
-		b.Warnf("%s Cannot find PrevBlk: %#v", b.Module(), b.LastNode())
+		b.Env.warn(b.Logger, b.Callee.Function(), CodeInternal, b.Callee.Function(),
+			"Cannot find PrevBlk: %#v", b.LastNode())
 		return nil
 	}
 	return b.LastNode().Prev.Blk()
@@ -127,6 +147,8 @@ func (b *Block) visitInstrs(blk *ssa.BasicBlock) {
 	blkBody.SetLogger(b.Logger)
 	// Handle control-flow instructions.
 	for _, instr := range blk.Instrs {
+		b.Env.steps++
+		nStmt := len(blkMeta.migoFunc.Stmts)
 		switch instr := instr.(type) { // These should be at the end of the blocks.
 		case *ssa.Jump:
 			blkBody.VisitJump(instr)
@@ -157,7 +179,15 @@ func (b *Block) visitInstrs(blk *ssa.BasicBlock) {
 						Then:    []migo.Statement{loopBody},
 						Else:    []migo.Statement{loopDone},
 					}
-					blkMeta.migoFunc.AddStmts(iffor)
+					b.Env.loopIndex[iffor] = l.Index()
+					if n, ok := l.TripCount(); ok {
+						b.Env.loopTrips[iffor] = n
+					}
+					if spawn := spawnIn(blk, blk.Parent().Blocks[l.BodyIdx()]); spawn != nil {
+						blkMeta.migoFunc.AddStmts(b.spawnLoop(blk, spawn, l, iffor, loopDone)...)
+					} else {
+						blkMeta.migoFunc.AddStmts(iffor)
+					}
 					blkMeta.emitted = true
 				} else if isSelCondBlk(instr.Cond) {
 					// Select case body block.
@@ -170,6 +200,12 @@ func (b *Block) visitInstrs(blk *ssa.BasicBlock) {
 						Then: []migo.Statement{callThen},
 						Else: []migo.Statement{callElse},
 					}
+					if blk.Comment == "for.loop" {
+						b.Env.loopApprox[ifstmt] = true
+					}
+					if spawn := spawnIn(blk, blk.Succs[0]); blk.Comment == "for.loop" && spawn != nil {
+						blkMeta.migoFunc.AddStmts(b.unboundedSpawn(blk, spawn, "unknown bound"))
+					}
 					blkMeta.migoFunc.AddStmts(ifstmt)
 					blkMeta.emitted = true
 				}
@@ -205,6 +241,7 @@ func (b *Block) visitInstrs(blk *ssa.BasicBlock) {
 				blkBody.VisitInstr(instr)
 			}
 		}
+		b.Env.recordOrigins(blkMeta.migoFunc, nStmt, instr)
 	}
 }
 
@@ -267,3 +304,107 @@ func (b *Block) mergePhi(data *BlockData, instr *ssa.Phi) {
 		}
 	}
 }
+
+// spawnIn returns the first goroutine spawned in the loop body starting at
+// body, or nil if there is none. The loop body consists of the blocks
+// reachable from body which reach a back edge to the loop header head, so
+// blocks after a break out of the loop are not part of it.
+func spawnIn(head, body *ssa.BasicBlock) *ssa.Go {
+	inLoop := map[*ssa.BasicBlock]bool{head: true}
+	var stack []*ssa.BasicBlock
+	for _, pred := range head.Preds {
+		if head.Dominates(pred) { // Back edge.
+			stack = append(stack, pred)
+		}
+	}
+	for len(stack) > 0 {
+		blk := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if !inLoop[blk] {
+			inLoop[blk] = true
+			stack = append(stack, blk.Preds...)
+		}
+	}
+	visited := map[*ssa.BasicBlock]bool{head: true}
+	queue := []*ssa.BasicBlock{body}
+	for len(queue) > 0 {
+		blk := queue[0]
+		queue = queue[1:]
+		if visited[blk] || !inLoop[blk] {
+			continue
+		}
+		visited[blk] = true
+		for _, instr := range blk.Instrs {
+			if spawn, ok := instr.(*ssa.Go); ok {
+				return spawn
+			}
+		}
+		queue = append(queue, blk.Succs...)
+	}
+	return nil
+}
+
+// spawnLoop returns the MiGo statements of loop l at header blk which spawns
+// goroutines. If the trip count of the loop is a constant no more than
+// Env.SpawnUnroll and the body is a single block, the body is replicated by
+// the trip count. If the trip count is a constant otherwise, the loop is kept
+// as iffor. If the trip count is unknown, the loop is approximated by
+// recursion, i.e. a nondeterministic choice between the body and the exit.
+// The approximations are recorded as diagnostics and comments.
+func (b *Block) spawnLoop(blk *ssa.BasicBlock, spawn *ssa.Go, l *loop.Info, iffor *migo.IfForStatement, done migo.Statement) []migo.Statement {
+	n, ok := l.TripCount()
+	if !ok {
+		choice := &migo.IfStatement{Then: iffor.Then, Else: iffor.Else}
+		b.Env.loopApprox[choice] = true
+		return []migo.Statement{b.unboundedSpawn(blk, spawn, "unknown bound"), choice}
+	}
+	if n > int64(b.Env.SpawnUnroll) {
+		return []migo.Statement{b.loopSpawn(blk, spawn, fmt.Sprintf("trip count %d over limit %d", n, b.Env.SpawnUnroll)), iffor}
+	}
+	body := b.meta[l.BodyIdx()]
+	stmts, ok := unrollable(body.migoFunc, b.meta[blk.Index].migoFunc.Name)
+	if !ok {
+		return []migo.Statement{b.loopSpawn(blk, spawn, "body of many blocks"), iffor}
+	}
+	var unrolled []migo.Statement
+	for i := int64(0); i < n; i++ {
+		unrolled = append(unrolled, stmts...)
+	}
+	body.inlined = true
+	return append(unrolled, done)
+}
+
+// unrollable returns the statements of loop body fn which can be replicated,
+// i.e. the body ends by calling the loop header head and declares no channels.
+func unrollable(fn *migo.Function, head string) ([]migo.Statement, bool) {
+	if len(fn.Stmts) == 0 {
+		return nil, false
+	}
+	last, ok := fn.Stmts[len(fn.Stmts)-1].(*migo.CallStatement)
+	if !ok || last.Name != head {
+		return nil, false
+	}
+	stmts := fn.Stmts[:len(fn.Stmts)-1]
+	for _, stmt := range stmts {
+		if _, ok := stmt.(*migo.NewChanStatement); ok {
+			return nil, false
+		}
+	}
+	return stmts, true
+}
+
+// unboundedSpawn records that the loop at header blk spawning goroutines is
+// approximated by unbounded recursion.
+func (b *Block) unboundedSpawn(blk *ssa.BasicBlock, spawn *ssa.Go, reason string) migo.Statement {
+	b.Env.warn(b.Logger, b.Callee.Function(), CodeSpawnLoop, spawn,
+		"Spawn loop %s#%d has %s, approximated by recursion", b.Callee.UniqName(), blk.Index, reason)
+	return &commentStatement{text: fmt.Sprintf("spawn loop with %s approximated by recursion", reason)}
+}
+
+// loopSpawn records that the loop at header blk spawning goroutines is not
+// replicated, and kept as a loop.
+func (b *Block) loopSpawn(blk *ssa.BasicBlock, spawn *ssa.Go, reason string) migo.Statement {
+	b.Env.warn(b.Logger, b.Callee.Function(), CodeSpawnLoop, spawn,
+		"Spawn loop %s#%d has %s, not replicated", b.Callee.UniqName(), blk.Index, reason)
+	return &commentStatement{text: fmt.Sprintf("spawn loop with %s not replicated", reason)}
+}
@@ -0,0 +1,51 @@
+package migoinfer
+
+// Analysis budgets.
+//
+// The analysis is bounded by budgets: the call depth, the number of
+// instruction visits, the number of context variants per function and a
+// deadline. When a budget is exhausted, a call which would otherwise be
+// analysed is truncated, i.e. the call is replaced by an opaque tau action and
+// a diagnostic is reported. Memoised calls are never truncated since they are
+// analysed already.
+
+import (
+	"fmt"
+
+	"github.com/nickng/gospal/funcs"
+	"github.com/nickng/migo"
+	"golang.org/x/tools/go/ssa"
+)
+
+// truncation returns the reason why the call at call site c is truncated, or
+// an empty string if the call is within budget.
+func (v *Instruction) truncation(c *ssa.CallCommon, call *funcs.Call) string {
+	if len(call.Function().Blocks) == 0 {
+		return "" // Nothing to analyse.
+	}
+	key := v.callKey(c, call)
+	if _, memoised := v.Env.Calls[key]; memoised {
+		return ""
+	}
+	env := v.Env
+	switch {
+	case env.Ctx != nil && env.Ctx.Err() != nil:
+		return fmt.Sprintf("deadline (%v)", env.Ctx.Err())
+	case env.MaxDepth > 0 && env.depth >= env.MaxDepth:
+		return fmt.Sprintf("max call depth (%d)", env.MaxDepth)
+	case env.MaxSteps > 0 && env.steps >= env.MaxSteps:
+		return fmt.Sprintf("max steps (%d)", env.MaxSteps)
+	case env.MaxContexts > 0 && !env.hasVariant(call.Function(), key.args) &&
+		len(env.variants[call.Function()]) >= env.MaxContexts:
+		return fmt.Sprintf("max contexts (%d) of %s", env.MaxContexts, call.Function())
+	}
+	return ""
+}
+
+// truncate replaces the call at call site c to fn by an opaque action.
+func (v *Instruction) truncate(c *ssa.CallCommon, fn *ssa.Function, reason string) {
+	v.warn(CodeBudget, c, "Call to %s truncated: %s", fn, reason)
+	v.MiGo.AddStmts(
+		&migo.TauStatement{},
+		&commentStatement{text: fmt.Sprintf("call to %s truncated: %s", fn.Name(), reason)})
+}
@@ -0,0 +1,129 @@
+package migoinfer
+
+// Diagnostics.
+//
+// Diagnostics are the errors and warnings (e.g. approximations) reported
+// during the analysis. Each diagnostic has a code for categorising the
+// diagnostics programmatically.
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/token"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// Severity is the severity of a Diagnostic.
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
+// MarshalText encodes the severity as its name.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Diagnostic codes.
+const (
+	CodeAnalysisFailed   = "analysis-failed"   // Function analysis failed.
+	CodeInternal         = "internal"          // Internal error.
+	CodeNonStaticBuffer  = "non-static-buffer" // Channel buffer size not constant.
+	CodeUnknownCallee    = "unknown-callee"    // Dynamic call not resolved.
+	CodeUnknownInvoke    = "unknown-invoke"    // Invoke call not resolved.
+	CodeInvokeLimit      = "invoke-limit"      // Too many invoke implementations.
+	CodeNilCall          = "nil-call"          // Call skipped.
+	CodeNilStruct        = "nil-struct"        // Field of a non-struct.
+	CodeUnexportedChan   = "unexported-chan"   // Channel not in scope.
+	CodeUndefinedArg     = "undefined-arg"     // Argument used as nil channel.
+	CodeCarriedAmbiguous = "carried-ambiguous" // Received one of many carried channels.
+	CodeSpawnLoop        = "spawn-loop"        // Spawning loop approximated.
+	CodeModel            = "model"             // Model misuse.
+	CodeBudget           = "budget"            // Call truncated by budget.
+	CodeCallGraph        = "call-graph"        // Call graph not built.
+)
+
+// Diagnostic is an error or warning reported during analysis.
+type Diagnostic struct {
+	Severity Severity       // Severity of the diagnostic.
+	Code     string         // Category of the diagnostic, see Code constants.
+	Pos      token.Position // Source position (invalid if unknown).
+	Func     string         // Function being analysed (empty if unknown).
+	Message  string         // Human readable message.
+}
+
+func (d Diagnostic) String() string {
+	pos := "-"
+	if d.Pos.IsValid() {
+		pos = d.Pos.String()
+	}
+	if d.Func != "" {
+		return fmt.Sprintf("%s: %s: %s (%s in %s)", pos, d.Severity, d.Message, d.Code, d.Func)
+	}
+	return fmt.Sprintf("%s: %s: %s (%s)", pos, d.Severity, d.Message, d.Code)
+}
+
+// MarshalJSON encodes the diagnostic as a JSON object.
+func (d Diagnostic) MarshalJSON() ([]byte, error) {
+	type position struct {
+		File   string `json:"file"`
+		Line   int    `json:"line"`
+		Column int    `json:"column"`
+	}
+	var pos *position
+	if d.Pos.IsValid() {
+		pos = &position{File: d.Pos.Filename, Line: d.Pos.Line, Column: d.Pos.Column}
+	}
+	return json.Marshal(struct {
+		Severity Severity  `json:"severity"`
+		Code     string    `json:"code"`
+		Pos      *position `json:"pos,omitempty"`
+		Func     string    `json:"func,omitempty"`
+		Message  string    `json:"message"`
+	}{d.Severity, d.Code, pos, d.Func, d.Message})
+}
+
+// report records a diagnostic at pos in the analysis of fn (nil if unknown).
+func (env *Environment) report(sev Severity, code string, pos token.Pos, fn *ssa.Function, msg string) {
+	d := Diagnostic{Severity: sev, Code: code, Message: msg}
+	if pos.IsValid() {
+		d.Pos = env.Info.FSet.Position(pos)
+	}
+	if fn != nil {
+		d.Func = fn.String()
+	}
+	env.Diagnostics = append(env.Diagnostics, d)
+}
+
+// warn logs and reports a warning at the position of p (if not nil) in the
+// analysis of fn.
+func (env *Environment) warn(l *Logger, fn *ssa.Function, code string, p Poser, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	pos := token.NoPos
+	if p != nil {
+		pos = p.Pos()
+	}
+	l.Warnf("%s %s\n\t%s", l.Module(), msg, env.Info.FSet.Position(pos))
+	env.report(SeverityWarning, code, pos, fn, msg)
+}
+
+// warn logs and reports a warning at the position of p in the function.
+func (v *Instruction) warn(code string, p Poser, format string, args ...interface{}) {
+	v.Env.warn(v.Logger, v.Callee.Function(), code, p, format, args...)
+}
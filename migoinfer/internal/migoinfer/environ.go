@@ -1,10 +1,13 @@
 package migoinfer
 
 import (
+	"context"
 	"go/token"
-	"log"
-	"os"
+	"go/types"
+	"sync"
 
+	"github.com/nickng/gospal/callctx"
+	"github.com/nickng/gospal/funcs"
 	gssa "github.com/nickng/gospal/ssa"
 	"github.com/nickng/gospal/store"
 	"github.com/nickng/migo"
@@ -13,39 +16,161 @@ import (
 
 // Environment captures the global environment of the program shared across
 // functions.
+//
+// All analysis state is scoped in the environment, so analyses in different
+// environments (see Fork) can run concurrently.
 type Environment struct {
-	Prog        *migo.Program
-	Info        *gssa.Info
-	Globals     *store.Store
-	Errors      chan error
-	SkipPkg     map[*ssa.Package]bool
-	VisitedFunc map[*ssa.CallCommon]bool
+	Prog     *migo.Program
+	Info     *gssa.Info
+	Globals  *store.Store
+	Errors   []error // Errors during analysis.
+	SkipPkg  map[*ssa.Package]bool
+	Calls    map[callKey]*Function      // Memoised calls.
+	variants map[*ssa.Function][]string // Context variants of functions.
+
+	Toplevel  callctx.Context  // Top-level context of entry points.
+	Instances *funcs.Instances // Instances of functions.
+	nilChans  int              // Fresh nilchan count.
+
+	loopIndex  map[*migo.IfForStatement]ssa.Value // Index variables of loops.
+	loopApprox map[*migo.IfStatement]bool         // Choices approximating loops.
+	loopTrips  map[*migo.IfForStatement]int64     // Trip counts of loops with a constant bound.
+
+	stmtOrigins map[migo.Statement]Origin // Origins of statements.
+	funcOrigins map[*migo.Function]Origin // Origins of definitions.
+
+	Diagnostics []Diagnostic // Errors and warnings during analysis.
+
+	CallGraphAlgo string          // Call graph algorithm for dynamic calls.
+	callGraph     *lazyCallGraph  // Call graph, built on demand.
+
+	globalChans  []*globalChan                        // Package-level channels.
+	globalUses   map[*ssa.Function]map[ssa.Value]bool // Package-level channels used.
+	initialising bool                                 // Visiting package init.
+	carriedFound bool                                 // Carried channels found.
+	received     map[ssa.Value][]*globalChan          // Received one of many carried channels.
+
+	InvokeLimit int      // Max implementations for unresolved invoke (0: no limit).
+	InvokeAllow []string // Receiver types allowed for unresolved invoke (empty: all).
+
+	SpawnUnroll int // Max trip count of spawning loops to replicate.
+
+	MaxDepth    int             // Max call depth (0: no limit).
+	MaxSteps    int             // Max instruction visits (0: no limit).
+	MaxContexts int             // Max context variants per function (0: no limit).
+	Ctx         context.Context // Deadline of the analysis (nil: no deadline).
+	depth       int             // Current call depth.
+	steps       int             // Instruction visits so far.
 }
 
 // NewEnvironment initialises a new environment.
 func NewEnvironment(info *gssa.Info) Environment {
 	return Environment{
-		Prog:        migo.NewProgram(),
-		Info:        info,
-		Globals:     store.New(),
-		Errors:      make(chan error),
-		VisitedFunc: make(map[*ssa.CallCommon]bool),
+		Prog:          migo.NewProgram(),
+		Info:          info,
+		Globals:       store.New(),
+		Calls:         make(map[callKey]*Function),
+		variants:      make(map[*ssa.Function][]string),
+		Toplevel:      callctx.NewToplevel(),
+		Instances:     new(funcs.Instances),
+		loopIndex:     make(map[*migo.IfForStatement]ssa.Value),
+		loopApprox:    make(map[*migo.IfStatement]bool),
+		loopTrips:     make(map[*migo.IfForStatement]int64),
+		received:      make(map[ssa.Value][]*globalChan),
+		stmtOrigins:   make(map[migo.Statement]Origin),
+		funcOrigins:   make(map[*migo.Function]Origin),
+		CallGraphAlgo: "cha",
+		callGraph:     new(lazyCallGraph),
+		InvokeLimit:   8,
+		SpawnUnroll:   8,
 	}
 }
 
+// Fork returns a new environment of the same program and configuration as env
+// but without any analysis state, for analysing independently of env (e.g.
+// concurrently). The call graph is shared.
+func (env *Environment) Fork() *Environment {
+	fork := NewEnvironment(env.Info)
+	fork.CallGraphAlgo = env.CallGraphAlgo
+	fork.callGraph = env.callGraph
+	fork.InvokeLimit = env.InvokeLimit
+	fork.InvokeAllow = env.InvokeAllow
+	fork.SpawnUnroll = env.SpawnUnroll
+	fork.MaxDepth = env.MaxDepth
+	fork.MaxSteps = env.MaxSteps
+	fork.MaxContexts = env.MaxContexts
+	fork.Ctx = env.Ctx
+	return &fork
+}
+
+// Join records the errors, diagnostics, loops and origins of fork (see Fork)
+// in env, the program of fork is not merged.
+func (env *Environment) Join(fork *Environment) {
+	env.Errors = append(env.Errors, fork.Errors...)
+	env.Diagnostics = append(env.Diagnostics, fork.Diagnostics...)
+	for iffor, index := range fork.loopIndex {
+		env.loopIndex[iffor] = index
+	}
+	for choice := range fork.loopApprox {
+		env.loopApprox[choice] = true
+	}
+	for iffor, n := range fork.loopTrips {
+		env.loopTrips[iffor] = n
+	}
+	for s, o := range fork.stmtOrigins {
+		env.stmtOrigins[s] = o
+	}
+	for f, o := range fork.funcOrigins {
+		env.funcOrigins[f] = o
+	}
+}
+
+// lazyCallGraph is a call graph built on first use, safe for concurrent use.
+type lazyCallGraph struct {
+	once sync.Once
+	cg   *gssa.CallGraph
+	err  error
+}
+
+// CallGraph returns the call graph of the program for resolving dynamic
+// calls, the call graph is built on first use.
+func (env *Environment) CallGraph() (*gssa.CallGraph, error) {
+	env.callGraph.once.Do(func() {
+		env.callGraph.cg, env.callGraph.err = env.Info.BuildCallGraph(env.CallGraphAlgo, false)
+	})
+	return env.callGraph.cg, env.callGraph.err
+}
+
+// invokeAllowed returns true if methods of receiver type t can be used for
+// resolving invoke calls.
+func (env *Environment) invokeAllowed(t types.Type) bool {
+	if len(env.InvokeAllow) == 0 {
+		return true
+	}
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	for _, allowed := range env.InvokeAllow {
+		if t.String() == allowed {
+			return true
+		}
+	}
+	return false
+}
+
 type Poser interface {
 	Pos() token.Pos
 }
 
-func (env Environment) HandleErrors() {
-	logger := log.New(os.Stderr, "ERROR: ", 0)
-	for err := range env.Errors {
-		if p, ok := err.(Poser); ok {
-			logger.Printf("%s\n\t%s", err, env.getPos(p), err)
-		} else {
-			logger.Println(err)
-		}
+// Error records an error during analysis, and reports it as a diagnostic.
+func (env *Environment) Error(err error) {
+	env.Errors = append(env.Errors, err)
+	if aerr, ok := err.(AnalysisError); ok {
+		d := Diagnostic{Severity: SeverityError, Code: CodeAnalysisFailed, Pos: aerr.Pos, Func: aerr.Func, Message: aerr.Err.Error()}
+		env.Diagnostics = append(env.Diagnostics, d)
+		return
 	}
+	env.report(SeverityError, CodeInternal, token.NoPos, nil, err.Error())
 }
 
 // getPos returns a string representation of the given item.
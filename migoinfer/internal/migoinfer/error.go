@@ -8,14 +8,38 @@ import (
 )
 
 var (
-	ErrFnIsNil      = errors.New("function is nil")
-	ErrIncompatType = errors.New("incompatible type")
+	ErrFnIsNil        = errors.New("function is nil")
+	ErrIncompatType   = errors.New("incompatible type")
+	ErrUnhandledInstr = errors.New("unhandled instruction")
+	ErrUndefined      = errors.New("value is not defined")
+	ErrBadBuiltin     = errors.New("inconsistent builtin call")
+	ErrBadSelect      = errors.New("unrecognised select")
+	ErrRestore        = errors.New("cannot restore statements")
+	ErrUpdateCtx      = errors.New("cannot update context")
 )
 
-type ErrChanBufSzNonStatic struct {
-	Pos token.Position
+// AnalysisError is an error which stops the analysis of a function, the
+// analysis of its callers continues without the function.
+type AnalysisError struct {
+	Func string         // Function (instance) being analysed.
+	Pos  token.Position // Position of the error if known.
+	Err  error          // Cause of the error.
 }
 
-func (e ErrChanBufSzNonStatic) Error() string {
-	return fmt.Sprintf("%s: channel buffer size is not constant", e.Pos.String())
+func (e AnalysisError) Error() string {
+	if e.Pos.IsValid() {
+		return fmt.Sprintf("%s: %s: %v", e.Pos.String(), e.Func, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Func, e.Err)
+}
+
+// Cause returns the cause of the error.
+func (e AnalysisError) Cause() error {
+	return e.Err
+}
+
+// failure is the panic value for aborting the analysis of a function.
+type failure struct {
+	err error
+	pos token.Pos
 }
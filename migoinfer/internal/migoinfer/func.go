@@ -1,10 +1,14 @@
 package migoinfer
 
 import (
+	"go/types"
+
 	"github.com/fatih/color"
 	"github.com/nickng/gospal/block"
 	"github.com/nickng/gospal/callctx"
 	"github.com/nickng/gospal/funcs"
+	"github.com/nickng/gospal/store"
+	"github.com/nickng/gospal/store/chans"
 	"github.com/nickng/gospal/store/structs"
 	"github.com/pkg/errors"
 	"golang.org/x/tools/go/ssa"
@@ -21,6 +25,8 @@ type Function struct {
 	block.Analyser // Function body analyser.
 	*Exported
 	*Logger
+
+	failed bool // Analysis failed, the MiGo is partial.
 }
 
 // NewFunction creates a new function visitor.
@@ -33,7 +39,20 @@ type Function struct {
 // In particular, the caller context contains the caller *ssa.Function and
 // its corresponding call function.
 func NewFunction(call *funcs.Call, ctx callctx.Context, env *Environment) *Function {
-	callee := funcs.Instantiate(call)
+	return newFunctionVariant(call, 0, ctx, env)
+}
+
+// NewEntryFunction creates a new function visitor for an entry function
+// without callers, e.g. exported functions in library mode. The entry is a
+// context variant of the function distinct from calls to the function.
+func NewEntryFunction(call *funcs.Call, ctx callctx.Context, env *Environment) *Function {
+	return newFunctionVariant(call, env.variant(call.Function(), entryArgs), ctx, env)
+}
+
+// newFunctionVariant creates a new function visitor for a context variant of
+// the function, see Environment.variant.
+func newFunctionVariant(call *funcs.Call, variant int, ctx callctx.Context, env *Environment) *Function {
+	callee := env.Instances.InstantiateVariant(call, variant)
 	f := Function{
 		Callee:   callee,
 		Context:  callctx.Switch(ctx, callee),
@@ -52,9 +71,12 @@ func NewFunction(call *funcs.Call, ctx callctx.Context, env *Environment) *Funct
 // This should be the entry point of a function call.
 func (f *Function) EnterFunc(fn *ssa.Function) {
 	if fn == nil {
-		f.Env.Errors <- errors.Wrap(ErrFnIsNil, "When entering function")
+		f.Env.Error(errors.Wrap(ErrFnIsNil, "When entering function"))
 	}
 	defer f.ExitFunc(fn)
+	defer f.recoverFunc()
+	f.Env.depth++
+	defer func() { f.Env.depth-- }()
 	nBlock := len(f.Callee.Function().Blocks)
 	f.Debugf("%s Enter %s (%d blocks)", f.Module(), fn.Name(), nBlock)
 
@@ -64,6 +86,34 @@ func (f *Function) EnterFunc(fn *ssa.Function) {
 	}
 }
 
+// recoverFunc recovers from a failure (or panic) in the analysis of the
+// function, and records the error so the analysis of its callers continues.
+// The MiGo of the function is the partial result before the failure.
+func (f *Function) recoverFunc() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	aerr := AnalysisError{Func: f.Callee.Function().String()}
+	switch r := r.(type) {
+	case failure:
+		aerr.Err = r.err
+		if r.pos.IsValid() {
+			aerr.Pos = f.Env.Info.FSet.Position(r.pos)
+		}
+	case error:
+		aerr.Err = errors.Wrap(r, "panic")
+	default:
+		aerr.Err = errors.Errorf("panic: %v", r)
+	}
+	f.Warnf("%s Analysis of %s failed: %v", f.Module(), f.Callee.UniqName(), aerr.Err)
+	f.Env.Error(aerr)
+	f.failed = true
+	if b, ok := f.Analyser.(*Block); ok {
+		b.meta[0].migoFunc.AddStmts(&commentStatement{text: "analysis failed: " + aerr.Err.Error()})
+	}
+}
+
 // ExitFunc finalises analysis of a function.
 func (f *Function) ExitFunc(fn *ssa.Function) {
 	if fn != nil {
@@ -72,7 +122,9 @@ func (f *Function) ExitFunc(fn *ssa.Function) {
 	if b, ok := f.Analyser.(*Block); b != nil && ok {
 		// Since a function is complete analysed, we can print its content.
 		for _, data := range b.meta {
-			f.Env.Prog.AddFunction(data.migoFunc)
+			if !data.inlined {
+				f.Env.Prog.AddFunction(data.migoFunc)
+			}
 		}
 	}
 }
@@ -105,3 +157,50 @@ func (f *Function) exportParams() {
 		}
 	}
 }
+
+// DeclareParams declares fresh channels for the channel parameters of the
+// function (including the receiver) and the channel free variables of a
+// closure, and the channel fields of its struct parameters. This should be
+// used on entry functions without callers only, e.g. exported functions in
+// library mode.
+func (f *Function) DeclareParams() {
+	b, ok := f.Analyser.(*Block)
+	if !ok {
+		return
+	}
+	declare := func(k store.Key, v ssa.Value) {
+		ch := chans.New(f.Callee, v, 0)
+		f.Put(k, ch)
+		f.Export(k)
+		b.meta[0].migoFunc.AddStmts(migoNewChan(f.Logger, k, ch))
+		b.decls = append(b.decls, k)
+	}
+	for _, param := range f.Callee.Function().Params {
+		if isChan(param) {
+			declare(param, param)
+		} else if isStruct(param) {
+			paramStruct := structs.New(f.Callee, param)
+			f.Put(param, paramStruct)
+			for _, field := range paramStruct.Expand() {
+				if sf, ok := field.(structs.SField); ok && sf.Struct == paramStruct && isChan(sf) {
+					sf.Struct.Fields[sf.Index] = sf
+					declare(sf, paramField{Parameter: param, field: sf})
+				}
+			}
+		}
+	}
+	for _, fv := range f.Callee.Function().FreeVars {
+		if isChan(fv) {
+			declare(fv, fv)
+		}
+	}
+}
+
+// paramField is a placeholder value for a field of a struct parameter.
+type paramField struct {
+	*ssa.Parameter
+	field structs.SField
+}
+
+func (p paramField) Name() string     { return p.field.Name() }
+func (p paramField) Type() types.Type { return p.field.Type() }
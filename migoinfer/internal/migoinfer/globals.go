@@ -0,0 +1,456 @@
+package migoinfer
+
+// Package-level channels.
+//
+// Channels stored in package-level variables (or fields of package-level
+// struct variables) are program-wide instances. They are recorded in the
+// environment when stored, and are passed as implicit parameters to every
+// function which uses them directly or through its callees. Global channels
+// created during package initialisation are declared at the entry function.
+//
+// Channels carried by other channels, i.e. sent over a channel, are also
+// program-wide instances, since MiGo does not pass channels over channels.
+// The channel created at a MakeChan site which is sent is declared at the
+// entry function, and every receive of a channel of the same type is an
+// over-approximation which receives one of the carried channels: the channel
+// operations, calls and spawns using the channel received are a choice over
+// the carried channels.
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+
+	"github.com/nickng/gospal/store"
+	"github.com/nickng/gospal/store/chans"
+	"github.com/nickng/migo"
+	"github.com/pkg/errors"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+// globalChan is a program-wide channel stored in a package-level variable or
+// a field of a package-level struct variable, or a channel carried by other
+// channels.
+// A globalChan is also the key of the channel in the function contexts.
+type globalChan struct {
+	site  ssa.Value   // *ssa.Global or *ssa.MakeChan (carried channel).
+	field int         // Field index, -1 if the variable is not a struct.
+	ch    *chans.Chan // Channel instance.
+	init  bool        // Created before entry function.
+}
+
+func (g *globalChan) Name() string {
+	switch site := g.site.(type) {
+	case *ssa.Global:
+		name := fmt.Sprintf("%s.%s", site.Pkg.Pkg.Name(), site.Name())
+		if g.field >= 0 {
+			name = fmt.Sprintf("%s.%s", name, g.structType().Field(g.field).Name())
+		}
+		return name
+	case *ssa.MakeChan:
+		return fmt.Sprintf("%s.%s", funcScope{site.Parent()}.UniqName(), site.Name())
+	}
+	return g.site.Name()
+}
+
+func (g *globalChan) Type() types.Type {
+	if g.field >= 0 {
+		return g.structType().Field(g.field).Type()
+	}
+	if _, ok := g.site.(*ssa.Global); ok {
+		return g.site.Type().(*types.Pointer).Elem()
+	}
+	return g.site.Type()
+}
+
+func (g *globalChan) Pos() token.Pos {
+	return g.site.Pos()
+}
+
+func (g *globalChan) String() string {
+	return fmt.Sprintf("global %s", g.Name())
+}
+
+func (g *globalChan) structType() *types.Struct {
+	return g.site.Type().(*types.Pointer).Elem().Underlying().(*types.Struct)
+}
+
+// funcScope is the namespace of channels carried by other channels, named
+// after the function which creates them.
+type funcScope struct {
+	fn *ssa.Function
+}
+
+func (s funcScope) UniqName() string {
+	var recv string
+	if r := s.fn.Signature.Recv(); r != nil {
+		if t, ok := derefType(r.Type()).(*types.Named); ok {
+			recv = t.Obj().Name() + "."
+		}
+	}
+	if s.fn.Pkg == nil {
+		return recv + s.fn.Name()
+	}
+	return fmt.Sprintf("%s.%s%s", s.fn.Pkg.Pkg.Name(), recv, s.fn.Name())
+}
+
+// derefType returns the element type if t is a pointer.
+func derefType(t types.Type) types.Type {
+	if ptr, ok := t.(*types.Pointer); ok {
+		return ptr.Elem()
+	}
+	return t
+}
+
+// globalAddr returns the global variable and field index (-1 if not a field)
+// if addr is the address of a package-level variable or its field.
+func globalAddr(addr ssa.Value) (*ssa.Global, int, bool) {
+	switch addr := addr.(type) {
+	case *ssa.Global:
+		return addr, -1, true
+	case *ssa.FieldAddr:
+		if g, ok := addr.X.(*ssa.Global); ok {
+			return g, addr.Field, true
+		}
+	}
+	return nil, -1, false
+}
+
+// isGlobalChan returns true if g is a package-level variable which can hold
+// a channel directly or in its fields.
+func isGlobalChan(g *ssa.Global) bool {
+	t := g.Type().(*types.Pointer).Elem()
+	if isChan(store.MockKey{Typ: t}) {
+		return true
+	}
+	if s, ok := t.Underlying().(*types.Struct); ok {
+		for i := 0; i < s.NumFields(); i++ {
+			if isChan(store.MockKey{Typ: s.Field(i).Type()}) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// putGlobalChan records ch as the channel stored in global variable g (at
+// field if it is not -1).
+func (env *Environment) putGlobalChan(g *ssa.Global, field int, ch *chans.Chan) *globalChan {
+	for _, gch := range env.globalChans {
+		if gch.site == g && gch.field == field {
+			gch.ch = ch
+			return gch
+		}
+	}
+	gch := &globalChan{site: g, field: field, ch: ch, init: env.initialising}
+	env.globalChans = append(env.globalChans, gch)
+	return gch
+}
+
+// getGlobalChan returns the channel stored in global variable g (at field if
+// it is not -1), or nil if there is none.
+func (env *Environment) getGlobalChan(g *ssa.Global, field int) *globalChan {
+	for _, gch := range env.globalChans {
+		if gch.site == g && gch.field == field {
+			return gch
+		}
+	}
+	return nil
+}
+
+// findCarried records the channels sent over channels in the program as
+// program-wide channels. The channels sent are traced back to the MakeChan
+// sites which create them, through the parameters and free variables of the
+// functions sending them.
+func (env *Environment) findCarried() {
+	if env.carriedFound {
+		return
+	}
+	env.carriedFound = true
+	var sent []ssa.Value
+	args := make(map[*ssa.Parameter][]ssa.Value)   // Arguments of static calls.
+	bindings := make(map[*ssa.FreeVar][]ssa.Value) // Bindings of closures.
+	for fn := range ssautil.AllFunctions(env.Info.Prog) {
+		for _, blk := range fn.Blocks {
+			for _, instr := range blk.Instrs {
+				switch instr := instr.(type) {
+				case *ssa.Send:
+					sent = append(sent, instr.X)
+				case *ssa.Select:
+					for _, state := range instr.States {
+						if state.Dir == types.SendOnly {
+							sent = append(sent, state.Send)
+						}
+					}
+				case *ssa.MakeClosure:
+					closure := instr.Fn.(*ssa.Function)
+					for i, b := range instr.Bindings {
+						bindings[closure.FreeVars[i]] = append(bindings[closure.FreeVars[i]], b)
+					}
+				}
+				if call, ok := instr.(ssa.CallInstruction); ok {
+					if callee := call.Common().StaticCallee(); callee != nil {
+						for i, arg := range call.Common().Args {
+							if i < len(callee.Params) {
+								args[callee.Params[i]] = append(args[callee.Params[i]], arg)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	seen := make(map[ssa.Value]bool)
+	var carried func(v ssa.Value)
+	carried = func(v ssa.Value) {
+		if seen[v] {
+			return
+		}
+		seen[v] = true
+		switch v := v.(type) {
+		case *ssa.ChangeType:
+			carried(v.X)
+		case *ssa.Parameter:
+			for _, arg := range args[v] {
+				carried(arg)
+			}
+		case *ssa.FreeVar:
+			for _, b := range bindings[v] {
+				carried(b)
+			}
+		case *ssa.MakeChan:
+			if env.getCarried(v) != nil {
+				return
+			}
+			var size int64 = 1
+			if sz, ok := v.Size.(*ssa.Const); ok {
+				size = sz.Int64()
+			}
+			env.globalChans = append(env.globalChans, &globalChan{
+				site:  v,
+				field: -1,
+				ch:    chans.New(funcScope{v.Parent()}, v, size),
+				init:  true,
+			})
+		}
+	}
+	for _, v := range sent {
+		carried(v)
+	}
+}
+
+// getCarried returns the carried channel created at mc, or nil if channels
+// created at mc are not sent over channels.
+func (env *Environment) getCarried(mc *ssa.MakeChan) *globalChan {
+	for _, gch := range env.globalChans {
+		if gch.site == mc {
+			return gch
+		}
+	}
+	return nil
+}
+
+// carriedOf returns the carried channels which can be received as a value of
+// channel type t.
+func (env *Environment) carriedOf(t types.Type) []*globalChan {
+	env.findCarried()
+	ct, ok := t.Underlying().(*types.Chan)
+	if !ok {
+		return nil
+	}
+	var carried []*globalChan
+	for _, gch := range env.globalChans {
+		if _, ok := gch.site.(*ssa.MakeChan); ok {
+			if types.Identical(gch.Type().Underlying().(*types.Chan).Elem(), ct.Elem()) {
+				carried = append(carried, gch)
+			}
+		}
+	}
+	return carried
+}
+
+// globalsUsed returns the global channels used by fn or its callees.
+func (env *Environment) globalsUsed(l *Logger, fn *ssa.Function) []*globalChan {
+	env.findCarried()
+	if len(env.globalChans) == 0 {
+		return nil
+	}
+	if env.globalUses == nil {
+		env.globalUses = env.findGlobalUses(l)
+	}
+	var used []*globalChan
+	for _, gch := range env.globalChans {
+		if env.globalUses[fn][gch.site] {
+			used = append(used, gch)
+		}
+	}
+	return used
+}
+
+// findGlobalUses returns the package-level channel variables and carried
+// channels used by each function in the program directly or through its
+// callees. If the call graph of the configured algorithm cannot be built,
+// e.g. rta or pta without a main package, the cha call graph is used instead.
+func (env *Environment) findGlobalUses(l *Logger) map[*ssa.Function]map[ssa.Value]bool {
+	uses := make(map[*ssa.Function]map[ssa.Value]bool)
+	cg, err := env.CallGraph()
+	if err != nil {
+		env.warn(l, nil, CodeCallGraph, nil, "Call graph (%s) failed: %v, using cha for global channels", env.CallGraphAlgo, err)
+		if cg, err = env.Info.BuildCallGraph("cha", false); err != nil {
+			env.warn(l, nil, CodeCallGraph, nil, "Call graph (cha) failed: %v, global channels not tracked", err)
+			return uses
+		}
+	}
+	fns, err := cg.AllFunctions()
+	if err != nil {
+		env.warn(l, nil, CodeCallGraph, nil, "Call graph traversal failed: %v, global channels not tracked", err)
+		return uses
+	}
+	useCarried := func(fn *ssa.Function, t types.Type) {
+		for _, gch := range env.carriedOf(t) {
+			uses[fn][gch.site] = true
+		}
+	}
+	callees := make(map[*ssa.Function][]*ssa.Function)
+	for _, fn := range fns {
+		uses[fn] = make(map[ssa.Value]bool)
+		callees[fn] = cg.CalleesOf(fn)
+		for _, blk := range fn.Blocks {
+			for _, instr := range blk.Instrs {
+				for _, op := range instr.Operands(nil) {
+					if g, ok := (*op).(*ssa.Global); ok && isGlobalChan(g) {
+						uses[fn][g] = true
+					}
+				}
+				switch instr := instr.(type) {
+				case *ssa.MakeChan:
+					if env.getCarried(instr) != nil {
+						uses[fn][instr] = true
+					}
+				case *ssa.UnOp:
+					if instr.Op == token.ARROW {
+						useCarried(fn, instr.X.Type().Underlying().(*types.Chan).Elem())
+					}
+				case *ssa.Select:
+					for _, state := range instr.States {
+						if state.Dir == types.RecvOnly {
+							useCarried(fn, state.Chan.Type().Underlying().(*types.Chan).Elem())
+						}
+					}
+				}
+			}
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for fn, fnCallees := range callees {
+			for _, callee := range fnCallees {
+				for g := range uses[callee] {
+					if !uses[fn][g] {
+						uses[fn][g] = true
+						changed = true
+					}
+				}
+			}
+		}
+	}
+	return uses
+}
+
+// bindCarried binds the received channel value val to the carried channel
+// received. If there are more than one carried channels of the same type,
+// val is bound to the first one, and the instructions using val are analysed
+// for each of them as a nondeterministic choice (see carriedChoice).
+func (v *Instruction) bindCarried(val ssa.Value) {
+	carried := v.Env.carriedOf(val.Type())
+	switch len(carried) {
+	case 0:
+		v.Debugf("%s Received channel %s is not carried", v.Module(), val.Name())
+		return
+	case 1:
+	default:
+		v.warn(CodeCarriedAmbiguous, val, "Received channel %s can be one of %d carried channels, approximated by choice",
+			val.Name(), len(carried))
+		v.Env.received[val] = carried
+	}
+	v.Put(val, carried[0].ch)
+}
+
+// receivedOperand returns the operand of instr which is received as one of
+// many carried channels, and the carried channels, if instr is a channel
+// operation, call or spawn.
+func (v *Instruction) receivedOperand(instr ssa.Instruction) (ssa.Value, []*globalChan) {
+	switch instr := instr.(type) {
+	case *ssa.UnOp:
+		if instr.Op != token.ARROW {
+			return nil, nil
+		}
+	case *ssa.Send, *ssa.Select, *ssa.Call, *ssa.Go:
+	default:
+		return nil, nil
+	}
+	for _, op := range instr.Operands(nil) {
+		if op == nil || *op == nil {
+			continue
+		}
+		if carried, ok := v.Env.received[*op]; ok {
+			return *op, carried
+		}
+	}
+	return nil, nil
+}
+
+// carriedChoice analyses instr with val bound to each of the carried channels,
+// and combines their MiGo statements as a nondeterministic choice.
+func (v *Instruction) carriedChoice(instr ssa.Instruction, val ssa.Value, carried []*globalChan) {
+	var branches [][]migo.Statement
+	for _, gch := range carried {
+		v.MiGo.PutAway()
+		v.Put(val, gch.ch)
+		v.visitInstr(instr)
+		branches = append(branches, v.MiGo.Stmts)
+		stmts, err := v.MiGo.Restore()
+		if err != nil {
+			v.fail(errors.Wrap(ErrRestore, err.Error()), nil)
+		}
+		v.MiGo.Stmts = stmts
+	}
+	v.Put(val, carried[0].ch)
+	v.MiGo.AddStmts(migoChoice(branches))
+}
+
+// exportGlobals puts the global channels used by the function in its context,
+// and exports them as implicit parameters of the function.
+func (f *Function) exportGlobals() {
+	for _, gch := range f.Env.globalsUsed(f.Logger, f.Callee.Function()) {
+		f.putGlobal(gch)
+		f.Export(gch)
+	}
+}
+
+// DeclareGlobals declares the global channels created during package
+// initialisation and used by the function, this should be used on the entry
+// function of the analysis only.
+func (f *Function) DeclareGlobals() {
+	b, ok := f.Analyser.(*Block)
+	if !ok {
+		return
+	}
+	for _, gch := range f.Env.globalsUsed(f.Logger, f.Callee.Function()) {
+		if gch.init {
+			f.putGlobal(gch)
+			f.Export(gch)
+			b.meta[0].migoFunc.AddStmts(migoNewChan(f.Logger, gch, gch.ch))
+			b.decls = append(b.decls, gch)
+		}
+	}
+}
+
+// putGlobal puts global channel gch in the function context.
+func (f *Function) putGlobal(gch *globalChan) {
+	f.Put(gch, gch.ch)
+	if g, ok := gch.site.(*ssa.Global); ok && gch.field < 0 {
+		f.Put(g, gch.ch)
+	}
+}
@@ -1,6 +1,7 @@
 package migoinfer
 
 import (
+	"fmt"
 	"go/token"
 	"go/types"
 
@@ -8,6 +9,7 @@ import (
 	"github.com/nickng/gospal/callctx"
 	"github.com/nickng/gospal/fn"
 	"github.com/nickng/gospal/funcs"
+	"github.com/nickng/gospal/migoinfer/models"
 	"github.com/nickng/gospal/store"
 	"github.com/nickng/gospal/store/chans"
 	"github.com/nickng/gospal/store/structs"
@@ -38,6 +40,14 @@ func NewInstruction(callee *funcs.Instance, ctx callctx.Context, env *Environmen
 }
 
 func (v *Instruction) VisitInstr(instr ssa.Instruction) {
+	if val, carried := v.receivedOperand(instr); val != nil {
+		v.carriedChoice(instr, val, carried)
+		return
+	}
+	v.visitInstr(instr)
+}
+
+func (v *Instruction) visitInstr(instr ssa.Instruction) {
 	switch instr := instr.(type) {
 	case *ssa.Alloc:
 		v.Debugf("%s Alloc: %s = %s\n\t%s",
@@ -163,13 +173,18 @@ func (v *Instruction) VisitInstr(instr ssa.Instruction) {
 		v.VisitUnOp(instr)
 
 	default:
-		v.Fatalf("%s Unhandled instruction %q (%T)\n\t%s",
-			v.Module(), instr, instr, v.Env.getPos(instr))
+		v.fail(errors.Wrapf(ErrUnhandledInstr, "%q (%T)", instr, instr), instr)
 	}
 }
 
 func (v *Instruction) VisitAlloc(instr *ssa.Alloc) {
 	t := instr.Type().(*types.Pointer).Elem()
+	// A variable of pointer to channel-like type is not itself channel-like.
+	if _, ptr := t.(*types.Pointer); !ptr && models.IsChanType(t) {
+		v.Debugf("%s Allocate channel-like %s", v.Module(), t.String())
+		v.putChan(instr, chans.New(v.Callee, instr, 0))
+		return
+	}
 	switch t := t.Underlying().(type) {
 	case *types.Struct:
 		v.Debugf("%s Allocate struct: %T", v.Module(), t)
@@ -186,23 +201,33 @@ func (v *Instruction) VisitBinOp(instr *ssa.BinOp) {
 }
 
 func (v *Instruction) VisitCall(instr *ssa.Call) {
-	def := v.createDefinition(instr.Common())
-	if def == nil {
-		return
-	}
-	if _, ok := v.Env.VisitedFunc[instr.Common()]; ok {
+	defs := v.createDefinitions(instr.Common(), instr)
+	if len(defs) == 0 {
 		return
 	}
-	v.Env.VisitedFunc[instr.Common()] = true
-	v.doCall(instr, def)
+	v.doChoice(defs, func(def *funcs.Definition) {
+		if m, ok := models.Lookup(def.Function); ok {
+			v.applyModel(m, def, instr.Common(), instr)
+			return
+		}
+		v.doCall(instr.Common(), instr, def)
+	})
 }
 
 func (v *Instruction) VisitChangeInterface(instr *ssa.ChangeInterface) {
 }
 
 func (v *Instruction) VisitChangeType(instr *ssa.ChangeType) {
-	if _, ok := instr.X.Type().(*types.Chan); ok {
+	switch instr.X.Type().Underlying().(type) {
+	case *types.Chan:
 		v.Put(instr, v.Get(instr.X))
+	case *types.Signature:
+		// Function value converted to named function type.
+		if fn, ok := instr.X.(*ssa.Function); ok {
+			v.Put(instr, funcs.MakeDefinition(fn))
+		} else if def, ok := v.Get(instr.X).(*funcs.Definition); ok {
+			v.Put(instr, def)
+		}
 	}
 }
 
@@ -216,12 +241,37 @@ func (v *Instruction) VisitDefer(instr *ssa.Defer) {
 }
 
 func (v *Instruction) VisitExtract(instr *ssa.Extract) {
+	switch tuple := instr.Tuple.(type) {
+	case *ssa.Call: // Return value of call.
+		if t, ok := v.Get(tuple).(funcs.Tuple); ok {
+			if _, undefined := v.Get(instr).(store.MockValue); !undefined {
+				return // Defined by the call.
+			}
+			if val := t.At(instr.Index); val != nil {
+				v.Put(instr, val)
+			}
+		}
+	case *ssa.Select: // Received value of select case.
+		if instr.Index > selectCaseValue && isChan(instr) {
+			v.bindCarried(instr)
+		}
+	case *ssa.UnOp: // Received value of comma-ok receive.
+		if tuple.Op == token.ARROW && instr.Index == 0 && isChan(instr) {
+			v.bindCarried(instr)
+		}
+	}
 }
 
 func (v *Instruction) VisitField(instr *ssa.Field) {
 }
 
 func (v *Instruction) VisitFieldAddr(instr *ssa.FieldAddr) {
+	if g, ok := instr.X.(*ssa.Global); ok {
+		if gch := v.Env.getGlobalChan(g, instr.Field); gch != nil {
+			v.Put(instr, gch.ch)
+			return
+		}
+	}
 	switch struc := v.Get(instr.X).(type) {
 	case *structs.Struct:
 		if field := struc.Fields[instr.Field]; field != nil {
@@ -247,21 +297,23 @@ func (v *Instruction) VisitFieldAddr(instr *ssa.FieldAddr) {
 	case store.MockValue:
 		v.Debugf("%s struct undefined\n\t%s", v.Module(), v.Env.getPos(instr))
 	default:
-		v.Warnf("%s FieldAddr: %v is not a struct\t%s\n\t%s",
-			v.Module(), instr.X, instr.X.Type().Underlying(), v.Env.getPos(instr))
+		v.warn(CodeNilStruct, instr, "FieldAddr: %v is not a struct\t%s",
+			instr.X, instr.X.Type().Underlying())
 	}
 }
 
 func (v *Instruction) VisitGo(instr *ssa.Go) {
-	def := v.createDefinition(instr.Common())
-	if def == nil {
-		return
-	}
-	if _, ok := v.Env.VisitedFunc[instr.Common()]; ok {
+	defs := v.createDefinitions(instr.Common(), instr)
+	if len(defs) == 0 {
 		return
 	}
-	v.Env.VisitedFunc[instr.Common()] = true
-	v.doGo(instr, def)
+	v.doChoice(defs, func(def *funcs.Definition) {
+		if m, ok := models.Lookup(def.Function); ok {
+			v.goModel(m, def, instr)
+			return
+		}
+		v.doGo(instr, def)
+	})
 }
 
 func (v *Instruction) VisitIf(instr *ssa.If) {
@@ -280,8 +332,22 @@ func (v *Instruction) VisitLookup(instr *ssa.Lookup) {
 }
 
 func (v *Instruction) VisitMakeChan(instr *ssa.MakeChan) {
+	if gch := v.Env.getCarried(instr); gch != nil {
+		// Carried channel is declared at entry function.
+		v.Debugf("%s %s = MakeChan is carried %s", v.Module(), instr.Name(), gch.Name())
+		v.Put(instr, gch.ch)
+		return
+	}
 	newch := v.newChan(instr)
 	isReturnValue := v.Callee.Definition().IsReturn(instr)
+	if !isReturnValue && isReturnedThroughVar(v.Callee.Definition(), instr) {
+		// The caller declares the channel and passes it as a parameter.
+		v.Debugf("%s %s = MakeChan is returned through a variable", v.Module(), instr.Name())
+		v.Put(instr, newch)
+		v.Export(instr)
+		v.MiGo.AddStmts(&migo.TauStatement{})
+		return
+	}
 	var isParameter bool
 	str, field, isField := getStruct(instr)
 	if !isField { // Could be that the field is stored by *t0 = make(chan)
@@ -316,6 +382,29 @@ func (v *Instruction) VisitMakeChan(instr *ssa.MakeChan) {
 	v.MiGo.AddStmts(migoNewChan(v.Logger, instr, newch))
 }
 
+// isReturnedThroughVar returns true if the channel ch is stored in a variable
+// which is a return value of def, or which is bound to a closure returned by
+// def, e.g. a channel captured by a goroutine and returned.
+func isReturnedThroughVar(def *funcs.Definition, ch *ssa.MakeChan) bool {
+	for _, ref := range *ch.Referrers() {
+		store, ok := ref.(*ssa.Store)
+		if !ok || store.Val != ch {
+			continue
+		}
+		if def.IsReturn(store.Addr) {
+			return true
+		}
+		if refs := store.Addr.Referrers(); refs != nil {
+			for _, ref := range *refs {
+				if closure, ok := ref.(*ssa.MakeClosure); ok && def.IsReturn(closure) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
 func (v *Instruction) VisitMakeClosure(instr *ssa.MakeClosure) {
 	def := funcs.MakeClosureDefinition(instr.Fn.(*ssa.Function), instr.Bindings)
 	v.Put(instr, def)    // For calling the closure.
@@ -376,8 +465,14 @@ func (v *Instruction) VisitStore(instr *ssa.Store) {
 	val := v.Get(instr.Val)
 	if val != nil {
 		v.Put(instr.Addr, val)
+		if g, field, ok := globalAddr(instr.Addr); ok {
+			if ch, ok := val.(*chans.Chan); ok {
+				gch := v.Env.putGlobalChan(g, field, ch)
+				v.Debugf("%s Store to %s", v.Module(), gch.String())
+			}
+		}
 	} else {
-		v.Fatalf("Store: %s is not defined", instr.Val.Name())
+		v.fail(errors.Wrapf(ErrUndefined, "store %s", instr.Val.Name()), instr)
 	}
 }
 
@@ -389,15 +484,29 @@ func (v *Instruction) VisitUnOp(instr *ssa.UnOp) {
 	switch instr.Op {
 	case token.ARROW:
 		v.MiGo.AddStmts(migoRecv(v, instr.X, v.Get(instr.X)))
+		if isChan(instr) {
+			v.bindCarried(instr)
+		}
 	case token.MUL:
 		if _, err := callctx.Deref(v.Context, instr.X, instr); err != nil {
-			v.Env.Errors <- errors.WithStack(err) // internal error.
+			v.Env.Error(errors.WithStack(err)) // internal error.
 		}
 	default:
 		v.Debugf("%s UnOp", v.Module(), instr)
 	}
 }
 
+// fail aborts the analysis of the current function with err at the position
+// of p (if not nil), see Function.EnterFunc.
+func (v *Instruction) fail(err error, p Poser) {
+	fail := failure{err: err}
+	if p != nil {
+		fail.pos = p.Pos()
+	}
+	v.Errorf("%s %v", v.Module(), err)
+	panic(fail)
+}
+
 // SetLogger sets logger for Instruction.
 func (v *Instruction) SetLogger(l *Logger) {
 	v.Logger = &Logger{
@@ -420,13 +529,18 @@ func (v *Instruction) createDefinition(c *ssa.CallCommon) *funcs.Definition {
 		case *ssa.Builtin:
 			if fn.Name() == "close" {
 				if len(c.Args) != 1 {
-					v.Fatal("%s inconsistent: close should have 1 arg",
-						v.Module())
+					v.fail(errors.Wrap(ErrBadBuiltin, "close should have 1 arg"), c)
 				}
 				exported := v.FindExported(v.Context, v.Get(c.Args[0]))
 				v.MiGo.AddStmts(&migo.CloseStatement{Chan: exported.Name()})
 			}
 			v.Debugf("%s %v", v.Module(), fn)
+		default:
+			// Function value, e.g. parameter or closure in a variable.
+			if def, ok := v.Get(fn).(*funcs.Definition); ok {
+				v.Debugf("%s ↳ def %s (function value)", v.Module(), def.String())
+				return def
+			}
 		}
 		return nil
 	}
@@ -444,11 +558,11 @@ func (v *Instruction) createDefinition(c *ssa.CallCommon) *funcs.Definition {
 	if c.Value != nil {
 		implFn, err := fn.LookupImpl(v.Env.Info.Prog, c.Method, c.Value)
 		if err != nil {
-			v.Warnf("%s Cannot find method %v for invoke call: %v\n\tMeth: %s\n\tImpl: %s:%s",
+			v.Debugf("%s Cannot find method %v for invoke call: %v\n\tMeth: %s\n\tImpl: %s:%s",
 				v.Module(), c, err,
 				c.Method.String(),
 				c.Value.Name(), c.Value.Type().String())
-			return nil // skip
+			return nil // skip, see invokeDefinitions.
 		}
 		if implFn.Synthetic != "" {
 			implFn = fn.FindConcrete(v.Env.Info.Prog, implFn)
@@ -465,20 +579,151 @@ func (v *Instruction) createDefinition(c *ssa.CallCommon) *funcs.Definition {
 	return nil
 }
 
-func (v *Instruction) doCall(c *ssa.Call, def *funcs.Definition) {
-	call := funcs.MakeCall(def, c.Common(), c)
-	if call == nil {
-		v.Warnf("%s Skipping nil call %s", v.Module(), c.Common())
+// createDefinitions returns the definitions of the possible callees of c at
+// call site. Calls through function values which cannot be resolved in the
+// current context are resolved using the call graph.
+func (v *Instruction) createDefinitions(c *ssa.CallCommon, site ssa.CallInstruction) []*funcs.Definition {
+	if def := v.createDefinition(c); def != nil {
+		return []*funcs.Definition{def}
+	}
+	if c.IsInvoke() {
+		return v.invokeDefinitions(c, site)
+	}
+	if c.StaticCallee() != nil {
+		return nil
+	}
+	if _, ok := c.Value.(*ssa.Builtin); ok {
+		return nil
+	}
+	return v.dynamicDefinitions(site)
+}
+
+// dynamicDefinitions returns the definitions of the callees of a dynamic call
+// site using the call graph.
+func (v *Instruction) dynamicDefinitions(site ssa.CallInstruction) []*funcs.Definition {
+	cg, err := v.Env.CallGraph()
+	if err != nil {
+		v.warn(CodeUnknownCallee, site, "Cannot resolve dynamic call %s: %v", site.Common(), err)
+		return nil
+	}
+	var defs []*funcs.Definition
+	for _, callee := range cg.Callees(site) {
+		def, ok := v.Get(callee).(*funcs.Definition)
+		if !ok {
+			if len(callee.FreeVars) > 0 {
+				v.Debugf("%s Skipping closure %s: bindings unknown", v.Module(), callee)
+				continue
+			}
+			def = funcs.MakeDefinition(callee)
+		}
+		v.Debugf("%s ↳ dynamic %s", v.Module(), def.String())
+		defs = append(defs, def)
+	}
+	if len(defs) == 0 {
+		v.warn(CodeUnknownCallee, site, "No callee found for dynamic call %s", site.Common())
+	}
+	return defs
+}
+
+// invokeDefinitions returns the definitions of all implementations of the
+// method of an invoke call c whose receiver cannot be traced.
+func (v *Instruction) invokeDefinitions(c *ssa.CallCommon, site ssa.CallInstruction) []*funcs.Definition {
+	iface, ok := c.Value.Type().Underlying().(*types.Interface)
+	if !ok {
+		return nil
+	}
+	var defs []*funcs.Definition
+	for _, impl := range fn.LookupImpls(v.Env.Info.Prog, c.Method, iface) {
+		if !v.Env.invokeAllowed(impl.Signature.Recv().Type()) {
+			continue
+		}
+		def, ok := v.Get(impl).(*funcs.Definition)
+		if !ok {
+			def = funcs.MakeDefinition(impl)
+			v.Put(impl, def)
+		}
+		v.Debugf("%s ↳ invoke (cha) %s", v.Module(), def.String())
+		defs = append(defs, def)
+	}
+	switch {
+	case len(defs) == 0:
+		v.warn(CodeUnknownInvoke, site, "Cannot find method %v for invoke call", c)
+		return nil
+	case v.Env.InvokeLimit > 0 && len(defs) > v.Env.InvokeLimit:
+		v.warn(CodeInvokeLimit, site, "Too many implementations (%d > %d) of method %v for invoke call",
+			len(defs), v.Env.InvokeLimit, c)
+		return nil
+	}
+	return defs
+}
+
+// doChoice analyses each of the alternative callees defs with visit, and
+// combines their MiGo statements as a nondeterministic choice.
+func (v *Instruction) doChoice(defs []*funcs.Definition, visit func(def *funcs.Definition)) {
+	if len(defs) == 1 {
+		visit(defs[0])
+		return
+	}
+	var branches [][]migo.Statement
+	for _, def := range defs {
+		v.MiGo.PutAway()
+		visit(def)
+		branches = append(branches, v.MiGo.Stmts)
+		stmts, err := v.MiGo.Restore()
+		if err != nil {
+			v.fail(errors.Wrap(ErrRestore, err.Error()), nil)
+		}
+		v.MiGo.Stmts = stmts
+	}
+	v.MiGo.AddStmts(migoChoice(branches))
+}
+
+// bindFuncArgs puts the definitions of function arguments in the context, so
+// they can be called through the parameters in the callee.
+func (v *Instruction) bindFuncArgs(c *ssa.CallCommon) {
+	for _, arg := range c.Args {
+		if fn, ok := arg.(*ssa.Function); ok {
+			if _, ok := v.Get(fn).(*funcs.Definition); !ok {
+				v.Put(fn, funcs.MakeDefinition(fn))
+			}
+		}
+	}
+}
+
+// skipCall replaces the call at call site c, which cannot be made because of
+// err, by an opaque action.
+func (v *Instruction) skipCall(c *ssa.CallCommon, err error) {
+	v.warn(CodeNilCall, c, "Skipping call %s: %v", c, err)
+	v.MiGo.AddStmts(
+		&migo.TauStatement{},
+		&commentStatement{text: fmt.Sprintf("call %s skipped: %v", c, err)})
+}
+
+// truncatedCallee records that the MiGo of callee fn is truncated at the call
+// site, i.e. the analysis of fn failed.
+func (v *Instruction) truncatedCallee(fn *Function) {
+	if fn.failed {
+		v.MiGo.AddStmts(&commentStatement{
+			text: fmt.Sprintf("call to %s truncated: analysis failed", fn.Callee.Function().Name())})
+	}
+}
+
+// doCall analyses a call to def, ret is the SSA value of the call if there is
+// one, otherwise nil.
+func (v *Instruction) doCall(c *ssa.CallCommon, ret ssa.Value, def *funcs.Definition) {
+	call, err := funcs.MakeCall(def, c, ret)
+	if err != nil {
+		v.skipCall(c, err)
 		return
 	}
 	v.Debugf("%s Definition: %v", v.Module(), def.String())
 	v.Debugf("%s      Call: %v", v.Module(), call.String())
-	fn := NewFunction(call, v.Context, v.Env)
-	fn.SetLogger(v.Logger)
-	v.Debugf("%s Context at caller: %v%v", v.Module(), v.Context, v.Exported)
-	v.Debugf("%s Context at callee: %v%v", v.Module(), fn.Context, fn.Exported)
-	fn.exportParams()
-
+	v.bindFuncArgs(c)
+	if reason := v.truncation(c, call); reason != "" {
+		v.truncate(c, call.Function(), reason)
+		return
+	}
+	fn, memoised := v.callee(c, call)
 	if len(call.Function().Blocks) == 0 {
 		// Since the function does not have body,
 		// calling it will not produce migo definitions.
@@ -486,16 +731,22 @@ func (v *Instruction) doCall(c *ssa.Call, def *funcs.Definition) {
 		return
 	}
 
-	fn.EnterFunc(call.Function())
+	if !memoised {
+		fn.EnterFunc(call.Function())
+	}
+	v.truncatedCallee(fn)
 	stmt := &migo.CallStatement{Name: fn.Callee.Name()}
 
 	v.bindCallParameters(call, fn)
 
 	// Before adding call statement, handle return values.
+	tuple := funcs.MakeTuple(call.NReturn())
+	var closureParams []*migo.Parameter
 	for i := range call.Parameters[call.NParam()+call.NBind():] {
 		callerName := call.Return(i)
 		caller := v.Get(callerName)
 		callee := fn.Get(call.Definition().Return(i))
+		tuple[i] = callee
 		if caller != callee {
 			v.Put(callerName, callee)
 			if isChan(callerName) { // Caller is a channel.
@@ -508,13 +759,19 @@ func (v *Instruction) doCall(c *ssa.Call, def *funcs.Definition) {
 					}
 				}
 			}
+			if closure, ok := callee.(*funcs.Definition); ok {
+				closureParams = append(closureParams, v.bindReturnedClosure(callerName, closure, fn)...)
+			}
 		}
 	}
+	if call.NReturn() > 1 && ret != nil {
+		v.Put(ret, tuple)
+	}
 
 	// Convert type Chan parameters to MiGo parameters.
-	migoParams := paramsToMigoParam(v, fn, call)
+	migoParams := append(paramsToMigoParam(v, fn, call), closureParams...)
 	stmt.AddParams(migoParams...)
-	if b, ok := fn.Analyser.(*Block); ok {
+	if b, ok := fn.Analyser.(*Block); ok && !memoised {
 		for _, data := range b.meta {
 			data.migoFunc.AddParams(migoParams...)
 		}
@@ -522,21 +779,72 @@ func (v *Instruction) doCall(c *ssa.Call, def *funcs.Definition) {
 	v.MiGo.AddStmts(stmt)
 }
 
-func (v *Instruction) doGo(g *ssa.Go, def *funcs.Definition) {
-	call := funcs.MakeCall(def, g.Common(), nil)
-	if call == nil {
-		v.Infof("%s Skipping nil go %s", v.Module(), g.Common())
-		return
+// bindReturnedClosure puts the channels bound to a closure returned by callee
+// fn in the current context, so they are available when the closure is called
+// by the caller through ret. The MiGo parameters to connect the channels with
+// the callee are returned.
+func (v *Instruction) bindReturnedClosure(ret store.Key, closure *funcs.Definition, fn *Function) []*migo.Parameter {
+	var params []*migo.Parameter
+	for _, binding := range closure.Bindings() {
+		if !isChan(binding) {
+			continue
+		}
+		if _, ok := v.Get(binding).(*chans.Chan); ok {
+			continue // Already defined.
+		}
+		if ch, ok := fn.Get(binding).(*chans.Chan); ok {
+			name := boundKey{Key: binding, name: fmt.Sprintf("%s_%s", ret.Name(), binding.Name())}
+			v.Put(binding, ch)
+			v.Put(name, ch)
+			v.MiGo.AddStmts(migoNewChan(v.Logger, name, ch))
+			v.Export(name)
+			exported := fn.FindExported(fn.Context, ch)
+			if _, ok := exported.(Unexported); !ok {
+				params = append(params, &migo.Parameter{Caller: name, Callee: exported})
+			}
+		}
 	}
-	v.Debugf("%s Definition: %v", v.Module(), def.String())
-	v.Debugf("%s    Go/Call: %v", v.Module(), call.String())
-	fn := NewFunction(call, v.Context, v.Env)
+	return params
+}
+
+// callee returns the function visitor of call at call site c, and whether it
+// is memoised, i.e. the call was analysed before in a matching context.
+// Unless memoised, the returned function is not yet analysed.
+func (v *Instruction) callee(c *ssa.CallCommon, call *funcs.Call) (*Function, bool) {
+	key := v.callKey(c, call)
+	if fn, ok := v.Env.Calls[key]; ok {
+		v.Debugf("%s Memoised call %s (%s)", v.Module(), fn.Callee.Name(), key.args)
+		return fn, true
+	}
+	variant := v.Env.variant(call.Function(), key.args)
+	fn := newFunctionVariant(call, variant, v.Context, v.Env)
 	fn.SetLogger(v.Logger)
 	v.Debugf("%s Context at caller: %v%v", v.Module(), v.Context, v.Exported)
 	v.Debugf("%s Context at callee: %v%v", v.Module(), fn.Context, fn.Exported)
 	fn.exportParams()
+	fn.exportGlobals()
+	v.Env.Calls[key] = fn
+	return fn, false
+}
 
-	fn.EnterFunc(call.Function())
+func (v *Instruction) doGo(g *ssa.Go, def *funcs.Definition) {
+	call, err := funcs.MakeCall(def, g.Common(), nil)
+	if err != nil {
+		v.skipCall(g.Common(), err)
+		return
+	}
+	v.Debugf("%s Definition: %v", v.Module(), def.String())
+	v.Debugf("%s    Go/Call: %v", v.Module(), call.String())
+	v.bindFuncArgs(g.Common())
+	if reason := v.truncation(g.Common(), call); reason != "" {
+		v.truncate(g.Common(), call.Function(), reason)
+		return
+	}
+	fn, memoised := v.callee(g.Common(), call)
+	if !memoised {
+		fn.EnterFunc(call.Function())
+	}
+	v.truncatedCallee(fn)
 	stmt := &migo.SpawnStatement{Name: fn.Callee.Name()}
 
 	v.bindCallParameters(call, fn)
@@ -544,12 +852,13 @@ func (v *Instruction) doGo(g *ssa.Go, def *funcs.Definition) {
 	// Convert type Chan parameters to MiGo parameters.
 	migoParams := paramsToMigoParam(v, fn, call)
 	stmt.AddParams(migoParams...)
-	if b, ok := fn.Analyser.(*Block); ok {
+	if b, ok := fn.Analyser.(*Block); ok && !memoised {
 		for _, data := range b.meta {
 			data.migoFunc.AddParams(migoParams...)
 		}
 	}
 	v.MiGo.AddStmts(stmt)
+	v.Env.recordSpawnOrigin(stmt, g, v.Context)
 }
 
 // getStruct returns the field variable and field index if the given value is a
@@ -583,7 +892,7 @@ func (v *Instruction) newChan(ch ssa.Value) *chans.Chan {
 	var bufSize int64
 	bufsz, ok := ch.(*ssa.MakeChan).Size.(*ssa.Const)
 	if !ok {
-		v.Env.Errors <- ErrChanBufSzNonStatic{Pos: v.Env.Info.FSet.Position(ch.Pos())}
+		v.warn(CodeNonStaticBuffer, ch, "Channel buffer size is not constant, using 1")
 		bufSize = 1
 	} else {
 		bufSize = bufsz.Int64()
@@ -592,7 +901,7 @@ func (v *Instruction) newChan(ch ssa.Value) *chans.Chan {
 	if updater, ok := v.Context.(callctx.Updater); ok {
 		updater.PutUniq(ch, newch)
 	} else {
-		v.Fatal("Cannot update context")
+		v.fail(ErrUpdateCtx, ch)
 	}
 	return newch
 }
@@ -643,8 +952,7 @@ func (v *Instruction) getSelectCases(sel *ssa.Select) migo.Statement {
 							}
 						}
 					default:
-						v.Fatal("%s Unexpected select-index test expression",
-							v.Module(), selTest.String())
+						v.fail(errors.Wrapf(ErrBadSelect, "unexpected select-index test expression %s", selTest), selTest)
 					}
 				}
 			}
@@ -662,8 +970,7 @@ func (v *Instruction) selBodyGuard(sel *ssa.Select, caseIdx int) migo.Statement
 	case types.RecvOnly:
 		return migoRecv(v, sel.States[caseIdx].Chan, v.Get(sel.States[caseIdx].Chan))
 	default:
-		v.Fatalf("%s Select case is guarded by neither send nor receive.\n\t%s",
-			v.Module(), v.Env.getPos(sel))
+		v.fail(errors.Wrap(ErrBadSelect, "select case is guarded by neither send nor receive"), sel)
 	}
 	return nil
 }
@@ -690,8 +997,7 @@ func (v *Instruction) selBodyBlock(sel *ssa.Select, caseIdx int, testBlk *ssa.Ba
 			v.Module(), v.Env.getPos(sel))
 		return nil, nil
 	default:
-		v.Fatalf("%s Select case has unrecognised last instruction in block.\n\t%s",
-			v.Module(), v.Env.getPos(inst))
+		v.fail(errors.Wrap(ErrBadSelect, "select case has unrecognised last instruction in block"), inst)
 	}
 	return nil, nil
 }
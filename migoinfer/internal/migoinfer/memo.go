@@ -0,0 +1,114 @@
+package migoinfer
+
+// Memoisation of function calls.
+//
+// A call is analysed once for each distinct abstract context, i.e. the call
+// site and the abstract values of the arguments. The abstract value of a
+// channel argument is its aliasing with other channel arguments, and the
+// abstract value of a function argument is its definition. Calls from the same
+// call site with matching abstract contexts reuse the analysed callee.
+// A nil channel is passed as a fresh nilchan, so it is not distinguished from
+// other non-aliased channels.
+//
+// Each distinct abstract context of a function is a context variant of the
+// function, which has its own MiGo definition.
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/nickng/gospal/funcs"
+	"github.com/nickng/gospal/store"
+	"github.com/nickng/gospal/store/chans"
+	"github.com/nickng/gospal/store/structs"
+	"golang.org/x/tools/go/ssa"
+)
+
+// callKey is the key of a memoised call.
+type callKey struct {
+	site *ssa.CallCommon // Call site.
+	fn   *ssa.Function   // Callee.
+	args string          // Abstract argument values.
+}
+
+// callKey returns the memoisation key of call at call site c in the current
+// context.
+func (v *Instruction) callKey(c *ssa.CallCommon, call *funcs.Call) callKey {
+	return callKey{site: c, fn: call.Function(), args: v.abstractArgs(call)}
+}
+
+// abstractArgs returns the abstract values of the arguments of call in the
+// current context as a string.
+func (v *Instruction) abstractArgs(call *funcs.Call) string {
+	var buf bytes.Buffer
+	var chs []store.Value // Distinct channels seen.
+	writeChan := func(val store.Value) {
+		if ch, ok := val.(*chans.Chan); ok {
+			for i, seen := range chs {
+				if seen == ch {
+					buf.WriteString(fmt.Sprintf("c%d", i))
+					return
+				}
+			}
+		}
+		// nil channels are passed as fresh nilchan, so never aliased.
+		buf.WriteString(fmt.Sprintf("c%d", len(chs)))
+		chs = append(chs, val)
+	}
+	for i, arg := range call.Parameters[:call.NParam()+call.NBind()] {
+		if i > 0 {
+			buf.WriteRune(',')
+		}
+		switch val := v.Get(arg).(type) {
+		case *funcs.Definition:
+			buf.WriteString(val.UniqName())
+		case *structs.Struct:
+			buf.WriteRune('{')
+			for _, field := range val.Expand() {
+				if sf, ok := field.(structs.SField); ok && isChan(sf) {
+					if sf.Key != nil {
+						writeChan(v.Get(sf.Key))
+					} else {
+						writeChan(nil)
+					}
+					buf.WriteRune(' ')
+				}
+			}
+			buf.WriteRune('}')
+		default:
+			if isChan(arg) {
+				writeChan(val)
+			} else {
+				buf.WriteRune('_')
+			}
+		}
+	}
+	return buf.String()
+}
+
+// entryArgs is the abstract arguments of an entry function without callers,
+// its channel parameters are fresh channels.
+const entryArgs = "entry"
+
+// hasVariant returns true if fn has a context variant for abstract arguments
+// args.
+func (env *Environment) hasVariant(fn *ssa.Function, args string) bool {
+	for _, variant := range env.variants[fn] {
+		if variant == args {
+			return true
+		}
+	}
+	return false
+}
+
+// variant returns the context variant of fn for abstract arguments args.
+// The first abstract context of a function is variant 0.
+func (env *Environment) variant(fn *ssa.Function, args string) int {
+	for i, variant := range env.variants[fn] {
+		if variant == args {
+			return i
+		}
+	}
+	env.variants[fn] = append(env.variants[fn], args)
+	return len(env.variants[fn]) - 1
+}
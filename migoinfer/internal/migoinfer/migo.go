@@ -10,6 +10,7 @@ import (
 
 	"github.com/nickng/gospal/callctx"
 	"github.com/nickng/gospal/funcs"
+	"github.com/nickng/gospal/migoinfer/models"
 	"github.com/nickng/gospal/store"
 	"github.com/nickng/gospal/store/chans"
 	"github.com/nickng/gospal/store/structs"
@@ -74,6 +75,30 @@ type Unexported struct {
 	Value store.Value
 }
 
+// commentStatement is a MiGo comment, e.g. for recording approximations.
+type commentStatement struct {
+	text string
+}
+
+func (s *commentStatement) String() string { return "-- " + s.text }
+
+// CommentText returns the text of s if s is a comment.
+func CommentText(s migo.Statement) (string, bool) {
+	if c, ok := s.(*commentStatement); ok {
+		return c.text, true
+	}
+	return "", false
+}
+
+// boundKey is a Key renamed to avoid clashing with local names, e.g. for names
+// from another function.
+type boundKey struct {
+	store.Key
+	name string
+}
+
+func (k boundKey) Name() string { return k.name }
+
 // migoCall returns a 'call' in MiGo using exported values.
 func migoCall(fn string, blk *ssa.BasicBlock, exported *Exported) migo.Statement {
 	var params []*migo.Parameter
@@ -102,6 +127,21 @@ func migoCall(fn string, blk *ssa.BasicBlock, exported *Exported) migo.Statement
 	return &migo.CallStatement{Name: fmt.Sprintf("%s#%d", fn, blk.Index), Params: params}
 }
 
+// migoChoice returns a nondeterministic choice between the branches in MiGo,
+// i.e. nested if-then-else statements.
+func migoChoice(branches [][]migo.Statement) migo.Statement {
+	for i := range branches {
+		if len(branches[i]) == 0 {
+			branches[i] = []migo.Statement{&migo.TauStatement{}}
+		}
+	}
+	choice := branches[len(branches)-1]
+	for i := len(branches) - 2; i >= 0; i-- {
+		choice = []migo.Statement{&migo.IfStatement{Then: branches[i], Else: choice}}
+	}
+	return choice[0]
+}
+
 // migoNewChan returns a 'newchan' in MiGo.
 func migoNewChan(v *Logger, name migo.NamedVar, ch *chans.Chan) migo.Statement {
 	v.Debugf("%s migo newchan name=%v value=%v", v.Module(), name, ch)
@@ -121,14 +161,13 @@ type freshNilChan struct {
 	typ   types.Type // Type of given nil chan.
 }
 
-func newFreshNilChan(t types.Type) freshNilChan {
-	defer func() { nextNilChan++ }()
-	return freshNilChan{count: nextNilChan, typ: t}
+// newFreshNilChan returns a new fresh nilchan, numbered in sequence of the
+// fresh nilchans in env.
+func newFreshNilChan(env *Environment, t types.Type) freshNilChan {
+	defer func() { env.nilChans++ }()
+	return freshNilChan{count: env.nilChans, typ: t}
 }
 
-// nextNilChan keeps track of current count of unnamed fresh nilchan.
-var nextNilChan int
-
 func (n freshNilChan) Name() string     { return fmt.Sprintf("nil%d", n.count) }
 func (n freshNilChan) Pos() token.Pos   { return token.NoPos }
 func (n freshNilChan) Type() types.Type { return n.typ }
@@ -140,6 +179,9 @@ func (n freshNilChan) String() string {
 func timeChan(ch store.Key) bool {
 	isTimeFunc := func(c *ssa.Call) bool {
 		fn := c.Call.StaticCallee()
+		if _, modelled := models.Lookup(fn); modelled {
+			return false // Channel is created by model.
+		}
 		return fn != nil && fn.Pkg != nil && fn.Pkg.Pkg.Path() == "time"
 	}
 	switch instr := ch.(type) {
@@ -167,7 +209,7 @@ func migoRecv(v *Instruction, local store.Key, ch store.Value) migo.Statement {
 	v.Debugf("%s migo recv name=%v, value=%s", v.Module(), local, ch.UniqName())
 	if c, ok := local.(*ssa.Const); ok {
 		if c.IsNil() {
-			nc := newFreshNilChan(local.Type())
+			nc := newFreshNilChan(v.Env, local.Type())
 			v.MiGo.AddStmts(migoNilChan(v, nc))
 			return &migo.RecvStatement{Chan: nc.Name()}
 		}
@@ -178,8 +220,8 @@ func migoRecv(v *Instruction, local store.Key, ch store.Value) migo.Statement {
 	}
 	switch exported := v.FindExported(v.Context, ch).(type) {
 	case Unexported:
-		v.Warnf("%s Channel %s/%s unavail. in current scope (unexported)\n\t%s",
-			v.Module(), local.Name(), ch.UniqName(), v.Env.getPos(local))
+		v.warn(CodeUnexportedChan, local, "Channel %s/%s unavail. in current scope (unexported)",
+			local.Name(), ch.UniqName())
 		if _, isField := local.(structs.SField); !isField { // If not defined as a struct-field.
 			v.MiGo.AddStmts(migoNilChan(v, local))
 		}
@@ -197,7 +239,7 @@ func migoSend(v *Instruction, local store.Key, ch store.Value) migo.Statement {
 	v.Debugf("%s migo send name=%v, value=%s", v.Module(), local, ch.UniqName())
 	if c, ok := local.(*ssa.Const); ok {
 		if c.IsNil() {
-			nc := newFreshNilChan(local.Type())
+			nc := newFreshNilChan(v.Env, local.Type())
 			v.MiGo.AddStmts(migoNilChan(v, nc))
 			return &migo.SendStatement{Chan: nc.Name()}
 		}
@@ -208,8 +250,8 @@ func migoSend(v *Instruction, local store.Key, ch store.Value) migo.Statement {
 	}
 	switch exported := v.FindExported(v.Context, ch).(type) {
 	case Unexported:
-		v.Warnf("%s Channel %s/%s unavail. in current scope (unexported)\n\t%s",
-			v.Module(), local.Name(), ch.UniqName(), v.Env.getPos(local))
+		v.warn(CodeUnexportedChan, local, "Channel %s/%s unavail. in current scope (unexported)",
+			local.Name(), ch.UniqName())
 		if _, isField := local.(structs.SField); !isField { // If not defined as a struct-field.
 			v.MiGo.AddStmts(migoNilChan(v, local))
 		}
@@ -271,16 +313,14 @@ func paramsToMigoParam(v *Instruction, fn *Function, call *funcs.Call) []*migo.P
 		switch ch := v.Get(arg).(type) {
 		case store.MockValue:
 			if _, isPhi := arg.(*ssa.Phi); isPhi {
-				v.Warnf("%s Undefined argument %s is Phi ⇔ %v",
-					v.Module(), arg,
-					&migo.Parameter{Caller: arg, Callee: param})
+				v.warn(CodeUndefinedArg, arg, "Undefined argument %s is Phi ⇔ %v",
+					arg, &migo.Parameter{Caller: arg, Callee: param})
 			} else {
 				field, isField := arg.(structs.SField)
 				if isField && field.Key != nil {
 					// Is field and is defined.
 				} else {
-					v.Warnf("%s Argument %v undefined → nil chan.\n\t%s",
-						v.Module(), arg, v.Env.getPos(arg))
+					v.warn(CodeUndefinedArg, arg, "Argument %v undefined → nil chan.", arg)
 					if isField && !isDefinedMiGoName(v, field) {
 						v.MiGo.AddStmts(migoNilChan(v, field))
 					} else if !isDefinedMiGoName(v, arg) {
@@ -353,6 +393,16 @@ func paramsToMigoParam(v *Instruction, fn *Function, call *funcs.Call) []*migo.P
 			migoParams = append(migoParams, migoParam)
 		}
 	}
+	// Global channels used by the callee are implicit parameters.
+	for _, gch := range v.Env.globalsUsed(v.Logger, call.Function()) {
+		if _, ok := fn.Get(gch).(*chans.Chan); !ok {
+			continue
+		}
+		exported := v.FindExported(v.Context, fn.Get(gch))
+		if _, ok := exported.(Unexported); !ok {
+			migoParams = append(migoParams, &migo.Parameter{Caller: exported, Callee: gch})
+		}
+	}
 	return migoParams
 }
 
@@ -0,0 +1,261 @@
+package migoinfer
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+
+	"github.com/nickng/gospal/callctx"
+	"github.com/nickng/gospal/funcs"
+	"github.com/nickng/gospal/migoinfer/models"
+	"github.com/nickng/gospal/store"
+	"github.com/nickng/gospal/store/chans"
+	"github.com/nickng/gospal/store/structs"
+	"github.com/nickng/migo"
+	"github.com/pkg/errors"
+	"golang.org/x/tools/go/ssa"
+)
+
+// modelSite is a call to a modelled function, it implements models.Site.
+type modelSite struct {
+	v    *Instruction // Caller.
+	call *funcs.Call  // Call to the modelled function.
+}
+
+// applyModel uses model m in place of analysing the function body of def.
+func (v *Instruction) applyModel(m models.Model, def *funcs.Definition, c *ssa.CallCommon, ret ssa.Value) {
+	call, err := funcs.MakeCall(def, c, ret)
+	if err != nil {
+		v.skipCall(c, err)
+		return
+	}
+	v.Debugf("%s Apply model of %s", v.Module(), def.Function.String())
+	m.Apply(&modelSite{v: v, call: call})
+}
+
+// goModel uses model m in place of analysing the function body of def spawned
+// by g. The statements of the model are in a new definition, which is spawned
+// with the names used by the statements as parameters.
+func (v *Instruction) goModel(m models.Model, def *funcs.Definition, g *ssa.Go) {
+	call, err := funcs.MakeCall(def, g.Common(), nil)
+	if err != nil {
+		v.skipCall(g.Common(), err)
+		return
+	}
+	v.Debugf("%s Apply model of %s in spawned definition", v.Module(), def.Function.String())
+	v.MiGo.PutAway()
+	m.Apply(&modelSite{v: v, call: call})
+	stmts := v.MiGo.Stmts
+	restored, err := v.MiGo.Restore()
+	if err != nil {
+		v.fail(errors.Wrap(ErrRestore, err.Error()), nil)
+	}
+	v.MiGo.Stmts = restored
+	if len(stmts) == 0 {
+		return // Nothing to spawn.
+	}
+	fn := migo.NewFunction(fmt.Sprintf("%s#go%d", v.Callee.Name(), goIndex(g)))
+	stmt := &migo.SpawnStatement{Name: fn.Name}
+	for _, name := range freeNames(stmts) {
+		fn.AddParams(&migo.Parameter{Caller: name, Callee: name})
+		stmt.AddParams(&migo.Parameter{Caller: name, Callee: name})
+	}
+	fn.AddStmts(stmts...)
+	v.Env.Prog.AddFunction(fn)
+	v.MiGo.AddStmts(stmt)
+	v.Env.recordSpawnOrigin(stmt, g, v.Context)
+}
+
+// goIndex returns the index of g among the go instructions of its function.
+func goIndex(g *ssa.Go) int {
+	n := 0
+	for _, blk := range g.Parent().Blocks {
+		for _, instr := range blk.Instrs {
+			if instr == g {
+				return n
+			}
+			if _, ok := instr.(*ssa.Go); ok {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// freeNames returns the names used but not created in stmts, in order of
+// first use.
+func freeNames(stmts []migo.Statement) []migo.NamedVar {
+	var names []migo.NamedVar
+	seen := make(map[string]bool)
+	use := func(name migo.NamedVar) {
+		if !seen[name.Name()] {
+			seen[name.Name()] = true
+			names = append(names, name)
+		}
+	}
+	var visit func(stmts []migo.Statement)
+	visit = func(stmts []migo.Statement) {
+		for _, stmt := range stmts {
+			switch stmt := stmt.(type) {
+			case *migo.NewChanStatement:
+				seen[stmt.Name.Name()] = true
+			case *migo.SendStatement:
+				use(models.Var(stmt.Chan))
+			case *migo.RecvStatement:
+				use(models.Var(stmt.Chan))
+			case *migo.CloseStatement:
+				use(models.Var(stmt.Chan))
+			case *migo.CallStatement:
+				for _, p := range stmt.Params {
+					use(p.Caller)
+				}
+			case *migo.SpawnStatement:
+				for _, p := range stmt.Params {
+					use(p.Caller)
+				}
+			case *migo.IfStatement:
+				visit(stmt.Then)
+				visit(stmt.Else)
+			case *migo.IfForStatement:
+				visit(stmt.Then)
+				visit(stmt.Else)
+			case *migo.SelectStatement:
+				for _, c := range stmt.Cases {
+					visit(c)
+				}
+			}
+		}
+	}
+	visit(stmts)
+	return names
+}
+
+func (s *modelSite) Function() *ssa.Function {
+	return s.call.Function()
+}
+
+func (s *modelSite) Arg(i int) migo.NamedVar {
+	if i >= s.call.NParam() {
+		return nil
+	}
+	arg := s.call.Param(i)
+	if !isChan(arg) {
+		return nil
+	}
+	ch := s.v.Get(arg)
+	if u, ok := arg.(*ssa.UnOp); ok && u.Op == token.MUL { // Deref
+		ch = s.v.Get(u.X)
+	}
+	switch exported := s.v.FindExported(s.v.Context, ch).(type) {
+	case Unexported:
+		s.v.warn(CodeModel, arg, "Model argument %s unavail. in current scope (unexported)", arg.Name())
+		return nil
+	default:
+		return exported
+	}
+}
+
+func (s *modelSite) NewChan(i int, size int64) migo.NamedVar {
+	if i >= s.call.NReturn() {
+		return nil
+	}
+	ret, ok := s.call.Return(i).(ssa.Value)
+	if !ok { // Unused return value.
+		return nil
+	}
+	ch := chans.New(s.v.Callee, ret, size)
+	s.v.putChan(ret, ch)
+	return ret
+}
+
+func (s *modelSite) NewChanField(i int, field string, size int64) migo.NamedVar {
+	if i >= s.call.NReturn() {
+		return nil
+	}
+	ret, ok := s.call.Return(i).(ssa.Value)
+	if !ok { // Unused return value.
+		return nil
+	}
+	str := structs.New(s.v.Callee, ret)
+	if str == nil {
+		return nil
+	}
+	idx := fieldIndex(ret.Type(), field)
+	if idx < 0 {
+		s.v.warn(CodeModel, ret, "Model field %s not found in %s", field, ret.Type())
+		return nil
+	}
+	if updater, ok := s.v.Context.(callctx.Updater); ok {
+		updater.PutUniq(ret, str)
+	}
+	key := structs.SField{Struct: str, Index: idx}
+	str.Fields[idx] = key
+	s.v.putChan(key, chans.New(s.v.Callee, ret, size))
+	return key
+}
+
+func (s *modelSite) Call(i int) {
+	s.CallWith(i)
+}
+
+func (s *modelSite) CallWith(i int, args ...int) {
+	if i >= s.call.NParam() {
+		return
+	}
+	fn, ok := s.call.Param(i).(ssa.Value)
+	if !ok {
+		return
+	}
+	c := &ssa.CallCommon{Value: fn}
+	for _, j := range args {
+		if j >= s.call.NParam() {
+			return
+		}
+		arg, ok := s.call.Param(j).(ssa.Value)
+		if !ok {
+			return
+		}
+		c.Args = append(c.Args, arg)
+	}
+	if def := s.v.createDefinition(c); def != nil {
+		if def.NParam != len(c.Args) {
+			s.v.warn(CodeModel, fn, "Model call of %s with %d arguments, expects %d",
+				fn.Name(), len(c.Args), def.NParam)
+			return
+		}
+		s.v.doCall(c, nil, def)
+	}
+}
+
+func (s *modelSite) AddStmts(stmts ...migo.Statement) {
+	s.v.MiGo.AddStmts(stmts...)
+}
+
+func (s *modelSite) AddFunction(fn *migo.Function) {
+	s.v.Env.Prog.AddFunction(fn)
+}
+
+// putChan binds a new channel ch to k, and creates the channel in MiGo.
+func (v *Instruction) putChan(k store.Key, ch *chans.Chan) {
+	if updater, ok := v.Context.(callctx.Updater); ok {
+		updater.PutUniq(k, ch)
+	}
+	v.Export(k)
+	v.MiGo.AddStmts(migoNewChan(v.Logger, k, ch))
+}
+
+// fieldIndex returns the index of the named field in struct (or pointer to
+// struct) type t, or -1 if not found.
+func fieldIndex(t types.Type, name string) int {
+	if ptr, ok := t.Underlying().(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	if s, ok := t.Underlying().(*types.Struct); ok {
+		for i := 0; i < s.NumFields(); i++ {
+			if s.Field(i).Name() == name {
+				return i
+			}
+		}
+	}
+	return -1
+}
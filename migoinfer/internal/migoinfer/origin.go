@@ -0,0 +1,158 @@
+package migoinfer
+
+// Source origins of MiGo.
+//
+// The origin of a MiGo statement is the SSA instruction (and its source
+// position) being visited when the statement is emitted; the origin of a
+// definition is the position of its function or block. Origins are kept in a
+// side table of the environment since MiGo statements do not have positions.
+// The origin of a spawn statement also has the call-context path of the go
+// statement, i.e. the functions called from the entry to the spawning
+// function, when the statement is emitted.
+
+import (
+	"go/token"
+
+	"github.com/nickng/gospal/callctx"
+	"github.com/nickng/migo"
+	"golang.org/x/tools/go/ssa"
+)
+
+// Origin is the Go source of a MiGo statement or definition.
+type Origin struct {
+	Pos     token.Position  // Source position (invalid if unknown).
+	Instr   ssa.Instruction // SSA instruction (nil for definitions).
+	Context []string        // Call-context path of spawn statements.
+}
+
+// StmtOrigin returns the origin of the MiGo statement s.
+func (env *Environment) StmtOrigin(s migo.Statement) (Origin, bool) {
+	o, ok := env.stmtOrigins[s]
+	return o, ok
+}
+
+// FuncOrigin returns the origin of the MiGo definition f.
+func (env *Environment) FuncOrigin(f *migo.Function) (Origin, bool) {
+	o, ok := env.funcOrigins[f]
+	return o, ok
+}
+
+// LoopApprox returns true if the MiGo statement s is a nondeterministic choice
+// which approximates a loop, e.g. a loop with unknown bound.
+func (env *Environment) LoopApprox(s migo.Statement) bool {
+	choice, ok := s.(*migo.IfStatement)
+	return ok && env.loopApprox[choice]
+}
+
+// LoopBound returns the trip count of the loop of the MiGo ifFor statement s,
+// false if the loop does not have a constant bound.
+func (env *Environment) LoopBound(s migo.Statement) (int64, bool) {
+	iffor, ok := s.(*migo.IfForStatement)
+	if !ok {
+		return 0, false
+	}
+	n, ok := env.loopTrips[iffor]
+	return n, ok
+}
+
+// recordOrigins records instr as the origin of the statements of f from index
+// from, and the statements nested in them, which do not have an origin.
+func (env *Environment) recordOrigins(f *migo.Function, from int, instr ssa.Instruction) {
+	if from > len(f.Stmts) {
+		from = 0 // Statements were put away.
+	}
+	o := Origin{Pos: env.Info.FSet.Position(instrPos(instr)), Instr: instr}
+	var record func(stmts []migo.Statement)
+	record = func(stmts []migo.Statement) {
+		for _, stmt := range stmts {
+			if _, ok := env.stmtOrigins[stmt]; ok {
+				continue
+			}
+			env.stmtOrigins[stmt] = o
+			switch stmt := stmt.(type) {
+			case *migo.IfStatement:
+				record(stmt.Then)
+				record(stmt.Else)
+			case *migo.IfForStatement:
+				record(stmt.Then)
+				record(stmt.Else)
+			case *migo.SelectStatement:
+				for _, c := range stmt.Cases {
+					record(c)
+				}
+			}
+		}
+	}
+	record(f.Stmts[from:])
+}
+
+// recordSpawnOrigin records the go instruction g analysed in context ctx as
+// the origin of the spawn statement s.
+func (env *Environment) recordSpawnOrigin(s *migo.SpawnStatement, g *ssa.Go, ctx callctx.Context) {
+	env.stmtOrigins[s] = Origin{
+		Pos:     env.Info.FSet.Position(instrPos(g)),
+		Instr:   g,
+		Context: contextPath(ctx),
+	}
+}
+
+// contextPath returns the functions of the callee contexts of ctx, outermost
+// first.
+func contextPath(ctx callctx.Context) []string {
+	var path []string
+	for callee, ok := ctx.(callctx.Callee); ok; callee, ok = callee.CallerCtx().(callctx.Callee) {
+		path = append([]string{callee.Call().Function().String()}, path...)
+	}
+	return path
+}
+
+// recordFuncOrigin records the origin of the MiGo definition f of block blk,
+// i.e. the function for the entry block, otherwise the first instruction.
+func (env *Environment) recordFuncOrigin(f *migo.Function, blk *ssa.BasicBlock) {
+	pos := blk.Parent().Pos()
+	if blk.Index > 0 {
+		for _, instr := range blk.Instrs {
+			if instr.Pos().IsValid() {
+				pos = instr.Pos()
+				break
+			}
+		}
+	}
+	env.funcOrigins[f] = Origin{Pos: env.Info.FSet.Position(pos)}
+}
+
+// copyOrigin records the origin of statement s as the origin of its copy.
+func (env *Environment) copyOrigin(s, copy migo.Statement) {
+	if o, ok := env.stmtOrigins[s]; ok {
+		env.stmtOrigins[copy] = o
+	}
+}
+
+// instrPos returns the position of instr, or the position of the closest
+// preceding instruction in the block (or the function) if it has none.
+func instrPos(instr ssa.Instruction) token.Pos {
+	if instr.Pos().IsValid() {
+		return instr.Pos()
+	}
+	if call, ok := instr.(ssa.CallInstruction); ok && call.Common().Pos().IsValid() {
+		return call.Common().Pos()
+	}
+	if blk := instr.Block(); blk != nil {
+		prev := token.NoPos
+		for _, i := range blk.Instrs {
+			if i == instr {
+				break
+			}
+			if i.Pos().IsValid() {
+				prev = i.Pos()
+			}
+		}
+		if prev.IsValid() {
+			return prev
+		}
+	}
+	if fn := instr.Parent(); fn != nil {
+		return fn.Pos()
+	}
+	return token.NoPos
+}
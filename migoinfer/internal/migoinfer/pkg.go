@@ -2,7 +2,6 @@ package migoinfer
 
 import (
 	"github.com/fatih/color"
-	"github.com/nickng/gospal/callctx"
 	"github.com/nickng/gospal/funcs"
 	"golang.org/x/tools/go/ssa"
 )
@@ -35,13 +34,15 @@ func (p *Package) InitGlobals(pkg *ssa.Package) {
 // VisitInit visits init function(s) in the package with a fresh context.
 func (p *Package) VisitInit(pkg *ssa.Package) {
 	if initFn := pkg.Func("init"); initFn != nil {
-		initDef := funcs.MakeCall(funcs.MakeDefinition(initFn), nil, nil)
-		fn := NewFunction(initDef, callctx.Toplevel(), p.Env)
+		initDef, _ := funcs.MakeCall(funcs.MakeDefinition(initFn), nil, nil) // No call site to mismatch.
+		fn := NewFunction(initDef, p.Env.Toplevel, p.Env)
 		fn.SetLogger(p.Logger)
+		p.Env.initialising = true
 		fn.EnterFunc(initDef.Function())
+		p.Env.initialising = false
 		return
 	}
-	p.Warnf("%s %s has no init", p.Module(), pkg.String())
+	p.Debugf("%s %s has no init", p.Module(), pkg.String())
 }
 
 // SetLogger sets logger for Package.
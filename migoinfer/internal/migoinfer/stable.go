@@ -0,0 +1,374 @@
+package migoinfer
+
+// Stable naming and ordering.
+//
+// The names in the inferred MiGo depend on the SSA numbering (e.g. registers
+// t0, block indices main.main#3) and the order of analysis, so a small edit
+// of the source changes a lot of names. A stable program renames them:
+//
+//   - Local names are the declared names of the source variables, or numbered
+//     in order of appearance in the definition if there is none (t0, nil0).
+//     Clashing names are disambiguated with a suffix (ch, ch_1).
+//   - Channels are labelled by the definition and the local name.
+//   - Block definitions are numbered in order of first call from their
+//     function (main.main#1, main.main#2).
+//   - Definitions are sorted by name, where numbered suffixes are ordered by
+//     their numbers.
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nickng/gospal/store"
+	"github.com/nickng/gospal/store/structs"
+	"github.com/nickng/migo"
+	"golang.org/x/tools/go/ssa"
+)
+
+var (
+	blockFuncName = regexp.MustCompile(`^(.*)#(\d+)$`)
+	tempName      = regexp.MustCompile(`^(t|nil)\d+$`)
+)
+
+// StableProgram returns a copy of the program in env with stable names and
+// ordering of definitions.
+func (env *Environment) StableProgram() *migo.Program {
+	funcNames := stableFuncNames(env.Prog)
+	prog := migo.NewProgram()
+	for _, f := range env.Prog.Funcs {
+		prog.AddFunction(env.stableFunc(f, funcNames))
+	}
+	sort.SliceStable(prog.Funcs, func(i, j int) bool {
+		return lessName(prog.Funcs[i].Name, prog.Funcs[j].Name)
+	})
+	return prog
+}
+
+// stableFuncNames returns the new names of block definitions, numbered in the
+// order of first call from their function.
+func stableFuncNames(prog *migo.Program) map[string]string {
+	names := make(map[string]string)
+	for _, f := range prog.Funcs {
+		if blockFuncName.MatchString(f.Name) {
+			continue
+		}
+		next := 1
+		var visit func(stmts []migo.Statement)
+		visitFn := func(name string) {
+			m := blockFuncName.FindStringSubmatch(name)
+			if m == nil || m[1] != f.Name {
+				return
+			}
+			if _, seen := names[name]; seen {
+				return
+			}
+			names[name] = fmt.Sprintf("%s#%d", f.Name, next)
+			next++
+			if g, ok := prog.Function(name); ok {
+				visit(g.Stmts)
+			}
+		}
+		visit = func(stmts []migo.Statement) {
+			for _, stmt := range stmts {
+				switch stmt := stmt.(type) {
+				case *migo.CallStatement:
+					visitFn(stmt.Name)
+				case *migo.SpawnStatement:
+					visitFn(stmt.Name)
+				case *migo.IfStatement:
+					visit(stmt.Then)
+					visit(stmt.Else)
+				case *migo.IfForStatement:
+					visit(stmt.Then)
+					visit(stmt.Else)
+				case *migo.SelectStatement:
+					for _, c := range stmt.Cases {
+						visit(c)
+					}
+				}
+			}
+		}
+		visit(f.Stmts)
+	}
+	return names
+}
+
+// localNames is the stable local names of a definition.
+type localNames struct {
+	names map[string]string // Original name → stable name.
+	used  map[string]bool   // Stable names used.
+	temps map[string]int    // Next number of temporary names by prefix.
+}
+
+// add assigns a stable name to the local variable v if it has none.
+func (l *localNames) add(v migo.NamedVar) {
+	if _, ok := l.names[v.Name()]; ok {
+		return
+	}
+	base := ""
+	if k, ok := v.(store.Key); ok {
+		base = sourceName(k)
+	}
+	if base == "" {
+		if m := tempName.FindStringSubmatch(v.Name()); m != nil {
+			for {
+				base = fmt.Sprintf("%s%d", m[1], l.temps[m[1]])
+				l.temps[m[1]]++
+				if !l.used[base] {
+					break
+				}
+			}
+		} else {
+			base = v.Name()
+		}
+	}
+	name := base
+	for i := 1; l.used[name]; i++ {
+		name = fmt.Sprintf("%s_%d", base, i)
+	}
+	l.used[name] = true
+	l.names[v.Name()] = name
+}
+
+// get returns the stable name of the local variable with original name.
+func (l *localNames) get(name string) string {
+	if stable, ok := l.names[name]; ok {
+		return stable
+	}
+	return name
+}
+
+// collect assigns stable names to the local variables declared in stmts, in
+// order of appearance.
+func (l *localNames) collect(env *Environment, stmts []migo.Statement) {
+	for _, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case *migo.NewChanStatement:
+			l.add(stmt.Name)
+		case *migo.CallStatement:
+			for _, p := range stmt.Params {
+				l.add(p.Caller)
+			}
+		case *migo.SpawnStatement:
+			for _, p := range stmt.Params {
+				l.add(p.Caller)
+			}
+		case *migo.IfStatement:
+			l.collect(env, stmt.Then)
+			l.collect(env, stmt.Else)
+		case *migo.IfForStatement:
+			if index, ok := env.loopIndex[stmt]; ok && index != nil {
+				l.add(index)
+			}
+			l.collect(env, stmt.Then)
+			l.collect(env, stmt.Else)
+		case *migo.SelectStatement:
+			for _, c := range stmt.Cases {
+				l.collect(env, c)
+			}
+		}
+	}
+}
+
+// stableFunc returns a copy of f with stable names.
+func (env *Environment) stableFunc(f *migo.Function, funcNames map[string]string) *migo.Function {
+	l := &localNames{names: make(map[string]string), used: make(map[string]bool), temps: make(map[string]int)}
+	for _, p := range f.Params {
+		l.add(p.Callee)
+	}
+	l.collect(env, f.Stmts)
+
+	name := f.Name
+	if stable, ok := funcNames[name]; ok {
+		name = stable
+	}
+	g := migo.NewFunction(name)
+	for _, p := range f.Params {
+		g.Params = append(g.Params, &migo.Parameter{Caller: p.Caller, Callee: renamed(p.Callee, l.get(p.Callee.Name()))})
+	}
+	g.Stmts = env.stableStmts(f.Stmts, name, l, funcNames)
+	g.HasComm = f.HasComm
+	if o, ok := env.funcOrigins[f]; ok {
+		env.funcOrigins[g] = o
+	}
+	return g
+}
+
+// stableStmts returns a copy of stmts in definition fn with stable names.
+func (env *Environment) stableStmts(stmts []migo.Statement, fn string, l *localNames, funcNames map[string]string) []migo.Statement {
+	funcName := func(name string) string {
+		if stable, ok := funcNames[name]; ok {
+			return stable
+		}
+		return name
+	}
+	callerParams := func(params []*migo.Parameter) []*migo.Parameter {
+		var ps []*migo.Parameter
+		for _, p := range params {
+			ps = append(ps, &migo.Parameter{Caller: renamed(p.Caller, l.get(p.Caller.Name())), Callee: p.Callee})
+		}
+		return ps
+	}
+	var out []migo.Statement
+	for _, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case *migo.NewChanStatement:
+			name := l.get(stmt.Name.Name())
+			ch := stmt.Chan
+			if ch != "nilchan" {
+				ch = fmt.Sprintf("%s.%s", fn, name)
+			}
+			out = append(out, &migo.NewChanStatement{Name: renamed(stmt.Name, name), Chan: ch, Size: stmt.Size})
+		case *migo.SendStatement:
+			out = append(out, &migo.SendStatement{Chan: l.get(stmt.Chan)})
+		case *migo.RecvStatement:
+			out = append(out, &migo.RecvStatement{Chan: l.get(stmt.Chan)})
+		case *migo.CloseStatement:
+			out = append(out, &migo.CloseStatement{Chan: l.get(stmt.Chan)})
+		case *migo.CallStatement:
+			out = append(out, &migo.CallStatement{Name: funcName(stmt.Name), Params: callerParams(stmt.Params)})
+		case *migo.SpawnStatement:
+			out = append(out, &migo.SpawnStatement{Name: funcName(stmt.Name), Params: callerParams(stmt.Params)})
+		case *migo.IfStatement:
+			choice := &migo.IfStatement{
+				Then: env.stableStmts(stmt.Then, fn, l, funcNames),
+				Else: env.stableStmts(stmt.Else, fn, l, funcNames),
+			}
+			if env.loopApprox[stmt] {
+				env.loopApprox[choice] = true
+			}
+			out = append(out, choice)
+		case *migo.IfForStatement:
+			cond := stmt.ForCond
+			if index, ok := env.loopIndex[stmt]; ok && index != nil {
+				word := regexp.MustCompile(`\b` + regexp.QuoteMeta(index.Name()) + `\b`)
+				cond = word.ReplaceAllLiteralString(cond, l.get(index.Name()))
+			}
+			iffor := &migo.IfForStatement{
+				ForCond: cond,
+				Then:    env.stableStmts(stmt.Then, fn, l, funcNames),
+				Else:    env.stableStmts(stmt.Else, fn, l, funcNames),
+			}
+			if n, ok := env.loopTrips[stmt]; ok {
+				env.loopTrips[iffor] = n
+			}
+			out = append(out, iffor)
+		case *migo.SelectStatement:
+			sel := &migo.SelectStatement{}
+			for _, c := range stmt.Cases {
+				sel.Cases = append(sel.Cases, env.stableStmts(c, fn, l, funcNames))
+			}
+			out = append(out, sel)
+		default:
+			out = append(out, stmt)
+		}
+		env.copyOrigin(stmt, out[len(out)-1])
+	}
+	return out
+}
+
+// stableVar is a NamedVar with a stable name.
+type stableVar struct {
+	migo.NamedVar
+	name string
+}
+
+func (v stableVar) Name() string { return v.name }
+
+// renamed returns v with the given name.
+func renamed(v migo.NamedVar, name string) migo.NamedVar {
+	if v.Name() == name {
+		return v
+	}
+	return stableVar{NamedVar: v, name: name}
+}
+
+// sourceName returns the name of the source variable of k, or an empty string
+// if k is not from a named source variable.
+func sourceName(k store.Key) string {
+	switch k := k.(type) {
+	case *ssa.Parameter:
+		return k.Name()
+	case *ssa.FreeVar:
+		return k.Name()
+	case *ssa.Global:
+		return k.Name()
+	case *ssa.Alloc:
+		return k.Comment
+	case *ssa.Phi:
+		if k.Comment != "" {
+			return k.Comment
+		}
+	case *ssa.UnOp:
+		if k.Op == token.MUL {
+			return sourceName(k.X)
+		}
+	case *ssa.FieldAddr:
+		return fieldName(sourceName(k.X), k.X.Type(), k.Field)
+	case *ssa.Field:
+		return fieldName(sourceName(k.X), k.X.Type(), k.Field)
+	case structs.SField:
+		if k.Key != nil {
+			return sourceName(k.Key)
+		}
+		if k.Struct != nil && k.Struct.Value != nil {
+			return fieldName(sourceName(k.Struct.Value), k.Struct.Value.Type(), k.Index)
+		}
+		return ""
+	case paramField:
+		return sourceName(k.field)
+	case boundKey:
+		return sourceName(k.Key)
+	}
+	if v, ok := k.(ssa.Value); ok {
+		if refs := v.Referrers(); refs != nil {
+			for _, instr := range *refs {
+				if ref, ok := instr.(*ssa.DebugRef); ok && !ref.IsAddr {
+					if id, ok := ast.Unparen(ref.Expr).(*ast.Ident); ok {
+						return id.Name
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// fieldName returns the name of field i of struct (or pointer to struct) type
+// t in variable v, or an empty string if v is not named.
+func fieldName(v string, t types.Type, i int) string {
+	if v == "" {
+		return ""
+	}
+	if ptr, ok := t.Underlying().(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	if s, ok := t.Underlying().(*types.Struct); ok && i < s.NumFields() {
+		return v + "_" + s.Field(i).Name()
+	}
+	return ""
+}
+
+// lessName compares definition names, where the parts separated by # are
+// compared numerically if they are numbers.
+func lessName(a, b string) bool {
+	as, bs := strings.Split(a, "#"), strings.Split(b, "#")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] == bs[i] {
+			continue
+		}
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		if aErr == nil && bErr == nil {
+			return an < bn
+		}
+		return as[i] < bs[i]
+	}
+	return len(as) < len(bs)
+}
@@ -5,14 +5,21 @@ package migoinfer
 import (
 	"go/types"
 
+	"github.com/nickng/gospal/migoinfer/models"
 	"github.com/nickng/gospal/store"
 )
 
 func isChan(k store.Key) bool {
+	if models.IsChanType(k.Type()) {
+		return true
+	}
 	switch t := k.Type().Underlying().(type) {
 	case *types.Chan:
 		return true
 	case *types.Pointer:
+		if models.IsChanType(t.Elem()) {
+			return true
+		}
 		switch t.Elem().Underlying().(type) {
 		case *types.Chan:
 			return true
@@ -22,6 +29,9 @@ func isChan(k store.Key) bool {
 }
 
 func isStruct(k store.Key) bool {
+	if models.IsChanType(k.Type()) {
+		return false
+	}
 	switch t := k.Type().Underlying().(type) {
 	case *types.Struct:
 		return true
@@ -0,0 +1,154 @@
+package migoinfer
+
+// JSON encoding of MiGo.
+//
+// The JSON encoding of a MiGo program is the definitions in output order,
+// each with its parameters and statement tree, and the source positions of
+// the definitions and statements (see SourceMap). In library and tests mode,
+// the entries and the names of the definitions reachable from each entry are
+// also encoded.
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/nickng/gospal/migoinfer/internal/migoinfer"
+	"github.com/nickng/migo"
+)
+
+// JSONProgram is the JSON encoding of a MiGo program.
+type JSONProgram struct {
+	Funcs   []JSONFunc  `json:"funcs"`             // Definitions.
+	Entries []JSONEntry `json:"entries,omitempty"` // Entries in library/tests mode.
+}
+
+// JSONEntry is the JSON encoding of an entry in library/tests mode.
+type JSONEntry struct {
+	Name  string   `json:"name"`  // Name of the entry definition.
+	Funcs []string `json:"funcs"` // Names of definitions reachable from entry.
+}
+
+// JSONFunc is the JSON encoding of a MiGo definition.
+type JSONFunc struct {
+	Name   string     `json:"name"`
+	Params []string   `json:"params"`
+	Pos    *Position  `json:"pos,omitempty"`
+	Stmts  []JSONStmt `json:"stmts"`
+}
+
+// Kinds of JSONStmt.
+const (
+	KindSend    = "send"
+	KindRecv    = "recv"
+	KindClose   = "close"
+	KindNewChan = "newchan"
+	KindSpawn   = "spawn"
+	KindCall    = "call"
+	KindIf      = "if"
+	KindFor     = "for"
+	KindSelect  = "select"
+	KindTau     = "tau"
+	KindComment = "comment"
+)
+
+// JSONStmt is the JSON encoding of a MiGo statement. The fields used depend
+// on the kind of the statement.
+type JSONStmt struct {
+	Kind  string       `json:"kind"`
+	Chan  string       `json:"chan,omitempty"`  // send/recv/close: channel, newchan: channel label.
+	Name  string       `json:"name,omitempty"`  // newchan: variable, call/spawn: definition.
+	Size  *int64       `json:"size,omitempty"`  // newchan: buffer size.
+	Args  []string     `json:"args,omitempty"`  // call/spawn: arguments.
+	Cond  string       `json:"cond,omitempty"`  // for: loop condition.
+	Then  []JSONStmt   `json:"then,omitempty"`  // if/for: then branch.
+	Else  []JSONStmt   `json:"else,omitempty"`  // if/for: else branch.
+	Cases [][]JSONStmt `json:"cases,omitempty"` // select: cases.
+	Text  string       `json:"text,omitempty"`  // comment: text.
+	Pos   *Position    `json:"pos,omitempty"`
+}
+
+// JSON returns the JSON encoding of the inferred MiGo program.
+func (i *Inferer) JSON() *JSONProgram {
+	p := &JSONProgram{Funcs: []JSONFunc{}}
+	for _, f := range i.outputFuncs() {
+		jf := JSONFunc{Name: f.SimpleName(), Params: []string{}, Stmts: i.jsonStmts(f.Stmts)}
+		for _, param := range f.Params {
+			jf.Params = append(jf.Params, param.Callee.Name())
+		}
+		if o, ok := i.Env.FuncOrigin(f); ok {
+			jf.Pos = newPosition(o.Pos)
+		}
+		p.Funcs = append(p.Funcs, jf)
+	}
+	for _, entry := range i.Entries {
+		je := JSONEntry{Name: entry.Name}
+		for _, f := range entry.MiGo.Funcs {
+			je.Funcs = append(je.Funcs, f.SimpleName())
+		}
+		p.Entries = append(p.Entries, je)
+	}
+	return p
+}
+
+// WriteJSON writes the JSON encoding of the inferred MiGo program to w.
+func (i *Inferer) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(i.JSON())
+}
+
+func (i *Inferer) jsonStmts(stmts []migo.Statement) []JSONStmt {
+	js := []JSONStmt{}
+	for _, stmt := range stmts {
+		js = append(js, i.jsonStmt(stmt))
+	}
+	return js
+}
+
+func (i *Inferer) jsonStmt(stmt migo.Statement) JSONStmt {
+	var s JSONStmt
+	switch stmt := stmt.(type) {
+	case *migo.SendStatement:
+		s = JSONStmt{Kind: KindSend, Chan: stmt.Chan}
+	case *migo.RecvStatement:
+		s = JSONStmt{Kind: KindRecv, Chan: stmt.Chan}
+	case *migo.CloseStatement:
+		s = JSONStmt{Kind: KindClose, Chan: stmt.Chan}
+	case *migo.NewChanStatement:
+		size := stmt.Size
+		s = JSONStmt{Kind: KindNewChan, Name: stmt.Name.Name(), Chan: stmt.Chan, Size: &size}
+	case *migo.SpawnStatement:
+		s = JSONStmt{Kind: KindSpawn, Name: stmt.SimpleName(), Args: jsonArgs(stmt.Params)}
+	case *migo.CallStatement:
+		s = JSONStmt{Kind: KindCall, Name: stmt.SimpleName(), Args: jsonArgs(stmt.Params)}
+	case *migo.IfStatement:
+		s = JSONStmt{Kind: KindIf, Then: i.jsonStmts(stmt.Then), Else: i.jsonStmts(stmt.Else)}
+	case *migo.IfForStatement:
+		s = JSONStmt{Kind: KindFor, Cond: stmt.ForCond, Then: i.jsonStmts(stmt.Then), Else: i.jsonStmts(stmt.Else)}
+	case *migo.SelectStatement:
+		s = JSONStmt{Kind: KindSelect, Cases: [][]JSONStmt{}}
+		for _, c := range stmt.Cases {
+			s.Cases = append(s.Cases, i.jsonStmts(c))
+		}
+	case *migo.TauStatement:
+		s = JSONStmt{Kind: KindTau}
+	default:
+		if text, ok := migoinfer.CommentText(stmt); ok {
+			s = JSONStmt{Kind: KindComment, Text: text}
+		} else {
+			s = JSONStmt{Kind: KindComment, Text: stmt.String()}
+		}
+	}
+	if o, ok := i.Env.StmtOrigin(stmt); ok {
+		s.Pos = newPosition(o.Pos)
+	}
+	return s
+}
+
+func jsonArgs(params []*migo.Parameter) []string {
+	var args []string
+	for _, p := range params {
+		args = append(args, p.Caller.Name())
+	}
+	return args
+}
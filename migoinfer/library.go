@@ -0,0 +1,234 @@
+package migoinfer
+
+// Library and tests mode.
+//
+// In library mode, every exported function and method of the non-main
+// packages is an entry of the analysis. Channel parameters (and channel fields
+// of struct parameters) of an entry are fresh channels declared at the entry,
+// so the MiGo of each entry is a protocol description of the API.
+//
+// In tests mode, every test function (TestXxx) is an entry of the analysis.
+// The testing package is modelled as a stub without communication, except
+// (*testing.T).Run which calls the subtest synchronously, so a subtest is part
+// of the MiGo of its test.
+
+import (
+	"fmt"
+
+	"github.com/nickng/gospal/callctx"
+	"github.com/nickng/gospal/funcs"
+	"github.com/nickng/gospal/migoinfer/internal/migoinfer"
+	"github.com/nickng/gospal/ssa"
+	"github.com/nickng/gospal/store"
+	"github.com/nickng/migo"
+	"github.com/pkg/errors"
+	gossa "golang.org/x/tools/go/ssa"
+)
+
+// Entry is the MiGo program of an entry function in library or tests mode.
+type Entry struct {
+	Name string        // Name of the entry MiGo function.
+	MiGo *migo.Program // Definitions reachable from the entry.
+}
+
+// entryFuncs returns the entry functions of library and/or tests mode.
+func (i *Inferer) entryFuncs() ([]*gossa.Function, error) {
+	var fns []*gossa.Function
+	if i.Library {
+		libs, err := i.Info.LibPkgs()
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot find library package")
+		}
+		for _, lib := range libs {
+			fns = append(fns, ssa.ExportedFuncs(lib)...)
+		}
+	}
+	if i.Tests {
+		var tests []*gossa.Function
+		for _, pkg := range i.Info.InitialPkgs() {
+			for _, test := range ssa.TestFuncs(pkg) {
+				tests = append(tests, test)
+				tests = append(tests, ssa.SubtestFuncs(test)...)
+			}
+		}
+		if len(tests) == 0 {
+			return nil, ErrNoTestFuncs
+		}
+		fns = append(fns, tests...)
+	}
+	return fns, nil
+}
+
+// analyseEntries analyses the entry functions fns one after another, and
+// returns the names of the entry MiGo functions.
+func (i *Inferer) analyseEntries(fns []*gossa.Function) []string {
+	ctx := i.Env.Toplevel
+	if l, ok := ctx.(store.Logger); ok {
+		l.SetLog(i.errWriter)
+	}
+	var entries []string
+	for _, fn := range fns {
+		entries = append(entries, i.analyseEntry(&i.Env, fn, ctx))
+	}
+	return entries
+}
+
+// analyseEntry analyses fn in env as an entry without callers, and returns the
+// name of the entry MiGo function.
+func (i *Inferer) analyseEntry(env *migoinfer.Environment, fn *gossa.Function, ctx callctx.Context) string {
+	fnDef, _ := funcs.MakeCall(funcs.MakeDefinition(fn), nil, nil) // No call site to mismatch.
+	fnAnalyser := migoinfer.NewEntryFunction(fnDef, ctx, env)
+	fnAnalyser.SetLogger(i.Logger)
+	fnAnalyser.DeclareGlobals()
+	fnAnalyser.DeclareParams()
+	fnAnalyser.EnterFunc(fnDef.Function())
+	return fnAnalyser.Callee.Name()
+}
+
+// setEntries sets the MiGo programs of entries. Entries removed by CleanUp
+// (i.e. no communication) are skipped.
+func (i *Inferer) setEntries(entries []string) {
+	i.Entries = nil
+	for _, name := range entries {
+		if f, ok := i.Env.Prog.Function(name); ok {
+			i.Entries = append(i.Entries, Entry{Name: f.SimpleName(), MiGo: reachable(i.Env.Prog, name)})
+		}
+	}
+}
+
+// writeEntries writes the MiGo of entries, either combined or one program per
+// entry.
+func (i *Inferer) writeEntries() {
+	if i.Split {
+		for _, entry := range i.Entries {
+			fmt.Fprintf(i.outWriter, "-- entry %s\n", entry.Name)
+			i.writeProgram(i.outWriter, entry.MiGo)
+		}
+		return
+	}
+	for _, f := range i.outputFuncs() {
+		i.writeFunc(i.outWriter, f)
+	}
+}
+
+// outputFuncs returns the MiGo definitions in output order, i.e. the entries
+// (or main.main) first, then in the order of the program.
+func (i *Inferer) outputFuncs() []*migo.Function {
+	var funcs []*migo.Function
+	written := make(map[*migo.Function]bool)
+	if i.Library || i.Tests {
+		for _, entry := range i.Entries {
+			funcs = append(funcs, entry.MiGo.Funcs[0])
+			written[entry.MiGo.Funcs[0]] = true
+		}
+	} else {
+		for _, f := range i.Env.Prog.Funcs {
+			if f.SimpleName() == "main.main" {
+				funcs = append(funcs, f)
+				written[f] = true
+			}
+		}
+	}
+	for _, f := range i.Env.Prog.Funcs {
+		if !written[f] {
+			funcs = append(funcs, f)
+		}
+	}
+	return funcs
+}
+
+// reachable returns a MiGo program with the definitions of prog reachable from
+// the function name, the function name first then in the order of prog.
+func reachable(prog *migo.Program, name string) *migo.Program {
+	seen := make(map[string]bool)
+	var visit func(stmts []migo.Statement)
+	visitFn := func(name string) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		if f, ok := prog.Function(name); ok {
+			visit(f.Stmts)
+		}
+	}
+	visit = func(stmts []migo.Statement) {
+		for _, stmt := range stmts {
+			switch stmt := stmt.(type) {
+			case *migo.CallStatement:
+				visitFn(stmt.Name)
+			case *migo.SpawnStatement:
+				visitFn(stmt.Name)
+			case *migo.IfStatement:
+				visit(stmt.Then)
+				visit(stmt.Else)
+			case *migo.IfForStatement:
+				visit(stmt.Then)
+				visit(stmt.Else)
+			case *migo.SelectStatement:
+				for _, c := range stmt.Cases {
+					visit(c)
+				}
+			}
+		}
+	}
+	visitFn(name)
+	p := migo.NewProgram()
+	if f, ok := prog.Function(name); ok {
+		p.AddFunction(f)
+	}
+	for _, f := range prog.Funcs {
+		if seen[f.Name] {
+			p.AddFunction(f)
+		}
+	}
+	return p
+}
+
+// markComm marks the functions of prog which communicate through their callees
+// as communicating, so they are kept by CleanUp, e.g. a function which only
+// spawns a communicating goroutine.
+func markComm(prog *migo.Program) {
+	var comm []*migo.Function
+	for _, f := range prog.Funcs {
+		for _, callee := range reachable(prog, f.Name).Funcs {
+			if callee.HasComm {
+				comm = append(comm, f)
+				break
+			}
+		}
+	}
+	for _, f := range comm {
+		f.HasComm = true
+	}
+}
+
+// removeInvalidLoopCalls removes the calls to the definitions removed by
+// CleanUp from the branches of the ifFor statements of prog, which CleanUp
+// does not visit.
+func removeInvalidLoopCalls(prog *migo.Program) {
+	var remove func(stmts []migo.Statement) []migo.Statement
+	remove = func(stmts []migo.Statement) []migo.Statement {
+		var valid []migo.Statement
+		for _, s := range stmts {
+			switch s := s.(type) {
+			case *migo.CallStatement:
+				if _, ok := prog.Function(s.Name); !ok {
+					continue
+				}
+			case *migo.IfStatement:
+				s.Then, s.Else = remove(s.Then), remove(s.Else)
+			case *migo.IfForStatement:
+				s.Then, s.Else = remove(s.Then), remove(s.Else)
+			case *migo.SelectStatement:
+				for i := range s.Cases {
+					s.Cases[i] = remove(s.Cases[i])
+				}
+			}
+			valid = append(valid, s)
+		}
+		return valid
+	}
+	for _, f := range prog.Funcs {
+		f.Stmts = remove(f.Stmts)
+	}
+}
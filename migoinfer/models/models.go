@@ -0,0 +1,177 @@
+// Package models provides a registry of MiGo summaries (models) for library
+// functions.
+//
+// A model replaces the analysis of a function body at call sites. This is
+// needed for functions that have no SSA body (e.g. in a blacklisted package or
+// implemented in assembly), or functions whose body does not reflect their
+// concurrent behaviour (e.g. timers backed by the runtime).
+//
+// Models are keyed by fully-qualified function name, which is the same as the
+// String() of the *ssa.Function, for example:
+//
+//  time.After
+//  os/signal.Notify
+//  (*sync.Once).Do
+//
+// Models for common standard library concurrency primitives are registered by
+// default, and user-defined models can be added with Register.
+package models
+
+import (
+	"go/types"
+	"sync"
+
+	"github.com/nickng/migo"
+	"golang.org/x/tools/go/ssa"
+)
+
+// A Model is a MiGo summary of a function.
+type Model interface {
+	// Apply emits the MiGo behaviour of a call to the modelled function
+	// through the call Site s.
+	Apply(s Site)
+}
+
+// Func is an adapter to allow the use of ordinary functions as Model.
+type Func func(s Site)
+
+// Apply calls f(s).
+func (f Func) Apply(s Site) { f(s) }
+
+// A Site is a call to a modelled function from the perspective of the caller.
+//
+// Argument and return value indices follow the SSA convention, i.e. the
+// receiver of a method is argument 0.
+type Site interface {
+	// Function returns the modelled function.
+	Function() *ssa.Function
+
+	// Arg returns the MiGo name of the i-th argument if it is a channel (or a
+	// channel-like type, see RegisterChanType), otherwise returns nil.
+	Arg(i int) migo.NamedVar
+
+	// NewChan creates a new channel of buffer size size for the i-th return
+	// value, and returns its MiGo name, or nil if the return value is unused.
+	NewChan(i int, size int64) migo.NamedVar
+
+	// NewChanField creates a new channel of buffer size size for field of the
+	// struct (or pointer to struct) i-th return value, and returns its MiGo
+	// name, or nil if the return value is unused.
+	NewChanField(i int, field string, size int64) migo.NamedVar
+
+	// Call analyses a call to the nullary function value in the i-th
+	// argument, e.g. the callback of (*sync.Once).Do.
+	Call(i int)
+
+	// CallWith analyses a call to the function value in the i-th argument
+	// with the arguments at indices args, e.g. the subtest of (*testing.T).Run
+	// is called with the receiver.
+	CallWith(i int, args ...int)
+
+	// AddStmts adds MiGo statements at the call site.
+	AddStmts(stmts ...migo.Statement)
+
+	// AddFunction adds an auxiliary MiGo definition to the program.
+	AddFunction(fn *migo.Function)
+}
+
+var registry = struct {
+	sync.RWMutex
+	models    map[string]Model
+	pkgs      map[string]Model
+	chanTypes map[string]bool
+}{
+	models:    make(map[string]Model),
+	pkgs:      make(map[string]Model),
+	chanTypes: make(map[string]bool),
+}
+
+// Register registers model m for the function with fully-qualified name name,
+// replacing the existing model if there is one.
+func Register(name string, m Model) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.models[name] = m
+}
+
+// Unregister removes the model for the function with fully-qualified name.
+func Unregister(name string) {
+	registry.Lock()
+	defer registry.Unlock()
+	delete(registry.models, name)
+}
+
+// RegisterPackage registers model m for all functions of the package with
+// import path path which do not have their own model, e.g. for stubbing out
+// a package.
+func RegisterPackage(path string, m Model) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.pkgs[path] = m
+}
+
+// UnregisterPackage removes the model for the package with import path path.
+func UnregisterPackage(path string) {
+	registry.Lock()
+	defer registry.Unlock()
+	delete(registry.pkgs, path)
+}
+
+// Lookup returns the model of fn if one is registered for fn or its package.
+func Lookup(fn *ssa.Function) (Model, bool) {
+	if fn == nil {
+		return nil, false
+	}
+	if m, ok := lookup(fn.String()); ok {
+		return m, ok
+	}
+	if obj := fn.Object(); obj != nil && obj.Pkg() != nil {
+		return lookupPackage(obj.Pkg().Path())
+	}
+	return nil, false
+}
+
+// lookup returns the model registered with name.
+func lookup(name string) (Model, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	m, ok := registry.models[name]
+	return m, ok
+}
+
+// lookupPackage returns the model registered for package path.
+func lookupPackage(path string) (Model, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	m, ok := registry.pkgs[path]
+	return m, ok
+}
+
+// RegisterChanType registers a named type (e.g. sync.Cond) to be treated as a
+// channel, pointers to the type are also treated as channel. The model of a
+// function creating the type should use NewChan to instantiate the channel.
+func RegisterChanType(name string) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.chanTypes[name] = true
+}
+
+// IsChanType returns true if t is a named type (or a pointer to a named type)
+// registered with RegisterChanType.
+func IsChanType(t types.Type) bool {
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	if named, ok := t.(*types.Named); ok {
+		registry.RLock()
+		defer registry.RUnlock()
+		return registry.chanTypes[named.String()]
+	}
+	return false
+}
+
+// Var is a MiGo name for use in auxiliary definitions of models.
+type Var string
+
+func (v Var) Name() string   { return string(v) }
+func (v Var) String() string { return string(v) }
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"go/types"
+	"testing"
+)
+
+// Tests that the standard library models are registered.
+func TestStdlibModels(t *testing.T) {
+	for _, name := range []string{
+		"time.After",
+		"time.Tick",
+		"time.NewTimer",
+		"time.NewTicker",
+		"os/signal.Notify",
+		"(*sync.Once).Do",
+		"sync.NewCond",
+		"(*sync.Cond).Wait",
+		"(*sync.Cond).Signal",
+		"(*sync.Cond).Broadcast",
+		"(*testing.T).Run",
+		"(*testing.B).Run",
+	} {
+		if _, ok := lookup(name); !ok {
+			t.Errorf("model of %s not registered", name)
+		}
+	}
+}
+
+// Tests registering and unregistering user-defined models.
+func TestRegister(t *testing.T) {
+	const name = "example.com/pkg.Func"
+	if _, ok := lookup(name); ok {
+		t.Fatalf("model of %s should not be registered", name)
+	}
+	Register(name, Func(nop))
+	if _, ok := lookup(name); !ok {
+		t.Errorf("model of %s not registered", name)
+	}
+	Unregister(name)
+	if _, ok := lookup(name); ok {
+		t.Errorf("model of %s not unregistered", name)
+	}
+}
+
+// Tests registering and unregistering package-wide models.
+func TestRegisterPackage(t *testing.T) {
+	const path = "example.com/pkg"
+	if _, ok := lookupPackage(path); ok {
+		t.Fatalf("model of package %s should not be registered", path)
+	}
+	RegisterPackage(path, Func(nop))
+	if _, ok := lookupPackage(path); !ok {
+		t.Errorf("model of package %s not registered", path)
+	}
+	UnregisterPackage(path)
+	if _, ok := lookupPackage(path); ok {
+		t.Errorf("model of package %s not unregistered", path)
+	}
+}
+
+// Tests sync.Cond (and *sync.Cond) are treated as channels.
+func TestChanType(t *testing.T) {
+	pkg := types.NewPackage("sync", "sync")
+	cond := types.NewNamed(types.NewTypeName(0, pkg, "Cond", nil), types.NewStruct(nil, nil), nil)
+	if !IsChanType(cond) {
+		t.Errorf("%s should be channel-like", cond)
+	}
+	if ptr := types.NewPointer(cond); !IsChanType(ptr) {
+		t.Errorf("%s should be channel-like", ptr)
+	}
+	mutex := types.NewNamed(types.NewTypeName(0, pkg, "Mutex", nil), types.NewStruct(nil, nil), nil)
+	if IsChanType(mutex) {
+		t.Errorf("%s should not be channel-like", mutex)
+	}
+}
@@ -0,0 +1,139 @@
+package models
+
+import "github.com/nickng/migo"
+
+// Models of standard library concurrency primitives.
+
+func init() {
+	// Timers: the runtime delivers to the channel (of buffer size 1), which is
+	// modelled by a (non-blocking) send after creating the channel.
+	Register("time.After", Func(timeAfter))
+	Register("time.NewTimer", Func(timeNewTimer))
+	Register("(*time.Timer).Stop", Func(nop))
+	Register("(*time.Timer).Reset", Func(nop))
+	// Tickers: the runtime delivers to the channel repeatedly.
+	Register("time.Tick", Func(timeTick))
+	Register("time.NewTicker", Func(timeNewTicker))
+	Register("(*time.Ticker).Stop", Func(nop))
+
+	// Signals may arrive any number of times.
+	Register("os/signal.Notify", Func(signalNotify))
+	Register("os/signal.Stop", Func(nop))
+
+	Register("(*sync.Once).Do", Func(onceDo))
+
+	// A sync.Cond is a channel where Wait receives and Signal/Broadcast sends
+	// to the waiting goroutine(s) if there are any.
+	RegisterChanType("sync.Cond")
+	Register("sync.NewCond", Func(condNew))
+	Register("(*sync.Cond).Wait", Func(condWait))
+	Register("(*sync.Cond).Signal", Func(condSignal))
+	Register("(*sync.Cond).Broadcast", Func(condBroadcast))
+
+	// Tests: subtests run synchronously in the calling test, and the rest of
+	// the testing package has no communication.
+	Register("(*testing.T).Run", Func(testRun))
+	Register("(*testing.B).Run", Func(testRun))
+	RegisterPackage("testing", Func(nop))
+}
+
+// nop is the model of a function without communication.
+func nop(s Site) {}
+
+func timeAfter(s Site) {
+	if ch := s.NewChan(0, 1); ch != nil {
+		s.AddStmts(&migo.SendStatement{Chan: ch.Name()})
+	}
+}
+
+func timeNewTimer(s Site) {
+	if ch := s.NewChanField(0, "C", 1); ch != nil {
+		s.AddStmts(&migo.SendStatement{Chan: ch.Name()})
+	}
+}
+
+func timeTick(s Site) {
+	if ch := s.NewChan(0, 1); ch != nil {
+		spawnSender(s, "time.ticker", ch, false)
+	}
+}
+
+func timeNewTicker(s Site) {
+	if ch := s.NewChanField(0, "C", 1); ch != nil {
+		spawnSender(s, "time.ticker", ch, false)
+	}
+}
+
+func signalNotify(s Site) {
+	if ch := s.Arg(0); ch != nil {
+		spawnSender(s, "os/signal.notify", ch, true)
+	}
+}
+
+func onceDo(s Site) {
+	s.Call(1)
+}
+
+// testRun calls the subtest function with the receiver as its argument.
+func testRun(s Site) {
+	s.CallWith(2, 0)
+}
+
+func condNew(s Site) {
+	s.NewChan(0, 0)
+}
+
+func condWait(s Site) {
+	if c := s.Arg(0); c != nil {
+		s.AddStmts(&migo.RecvStatement{Chan: c.Name()})
+	}
+}
+
+func condSignal(s Site) {
+	if c := s.Arg(0); c != nil {
+		s.AddStmts(&migo.SelectStatement{Cases: [][]migo.Statement{
+			{&migo.SendStatement{Chan: c.Name()}},
+			{&migo.TauStatement{}},
+		}})
+	}
+}
+
+func condBroadcast(s Site) {
+	if c := s.Arg(0); c != nil {
+		s.AddFunction(sender("sync.broadcast", true))
+		s.AddStmts(&migo.CallStatement{
+			Name:   "sync.broadcast",
+			Params: []*migo.Parameter{{Caller: c, Callee: Var("c")}},
+		})
+	}
+}
+
+// spawnSender spawns a goroutine which repeatedly sends to ch.
+func spawnSender(s Site, name string, ch migo.NamedVar, optional bool) {
+	s.AddFunction(sender(name, optional))
+	s.AddStmts(&migo.SpawnStatement{
+		Name:   name,
+		Params: []*migo.Parameter{{Caller: ch, Callee: Var("c")}},
+	})
+}
+
+// sender returns a recursive definition which repeatedly sends to its
+// parameter c. If optional is true, the definition may stop at any point.
+func sender(name string, optional bool) *migo.Function {
+	c := Var("c")
+	fn := migo.NewFunction(name)
+	fn.AddParams(&migo.Parameter{Caller: c, Callee: c})
+	loop := []migo.Statement{
+		&migo.SendStatement{Chan: c.Name()},
+		&migo.CallStatement{Name: name, Params: []*migo.Parameter{{Caller: c, Callee: c}}},
+	}
+	if optional {
+		fn.AddStmts(&migo.SelectStatement{Cases: [][]migo.Statement{
+			loop,
+			{&migo.TauStatement{}},
+		}})
+		return fn
+	}
+	fn.AddStmts(loop...)
+	return fn
+}
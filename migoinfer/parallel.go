@@ -0,0 +1,149 @@
+package migoinfer
+
+// Parallel analysis of entries.
+//
+// In library and tests mode, the entries can be analysed by a pool of workers.
+// Each entry is analysed in its own (forked) environment, so the analysis of
+// an entry is independent of the other entries and of the scheduling. The
+// programs of the entries are then merged in the order of the entries: a
+// definition already merged is shared, and a different definition with the
+// same name (e.g. a context variant numbered differently) is renamed.
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/nickng/gospal/migoinfer/internal/migoinfer"
+	"github.com/nickng/gospal/store"
+	"github.com/nickng/migo"
+	"github.com/pkg/errors"
+	gossa "golang.org/x/tools/go/ssa"
+)
+
+// entryResult is the result of analysing an entry in its own environment.
+type entryResult struct {
+	name string                 // Name of the entry MiGo function.
+	env  *migoinfer.Environment // Environment of the entry.
+	err  error                  // Error which stopped the analysis.
+}
+
+// analyseParallel analyses the entry functions fns with i.Workers workers, and
+// returns the names of the entry MiGo functions after merging. Only the
+// entries of library and tests mode are analysed in parallel, the main
+// functions of main packages are always analysed one after another.
+func (i *Inferer) analyseParallel(fns []*gossa.Function) []string {
+	results := make([]entryResult, len(fns))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < i.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range jobs {
+				results[k] = i.analyseIsolated(fns[k])
+			}
+		}()
+	}
+	for k := range fns {
+		jobs <- k
+	}
+	close(jobs)
+	wg.Wait()
+
+	var entries []string
+	for k, res := range results {
+		if res.err != nil {
+			i.Env.Error(res.err)
+			continue
+		}
+		i.Env.Join(res.env)
+		renamed := mergeProgram(i.Env.Prog, res.env.Prog, fmt.Sprintf("#e%d", k))
+		if name, ok := renamed[res.name]; ok {
+			entries = append(entries, name)
+		} else {
+			entries = append(entries, res.name)
+		}
+	}
+	return entries
+}
+
+// analyseIsolated analyses the entry function fn in a new environment.
+func (i *Inferer) analyseIsolated(fn *gossa.Function) (res entryResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			res.err = errors.Wrapf(ErrPanic, "%s: %v", fn, r)
+		}
+	}()
+	env := i.Env.Fork()
+	i.visitInits(env)
+	ctx := env.Toplevel
+	if l, ok := ctx.(store.Logger); ok {
+		l.SetLog(i.errWriter)
+	}
+	return entryResult{name: i.analyseEntry(env, fn, ctx), env: env}
+}
+
+// mergeProgram merges the definitions of src into dst. A definition of src
+// which has the same name but differs from the definition in dst is renamed
+// by appending suffix, together with the calls to it in src. The renamed
+// definitions are returned as a map from the original names to the final
+// names, a definition may be renamed more than once.
+func mergeProgram(dst, src *migo.Program, suffix string) map[string]string {
+	orig := make(map[*migo.Function]string)
+	for _, f := range src.Funcs {
+		orig[f] = f.Name
+	}
+	for {
+		conflicts := make(map[string]string)
+		for _, f := range src.Funcs {
+			if g, ok := dst.Function(f.Name); ok && g.String() != f.String() {
+				conflicts[f.Name] = f.Name + suffix
+			}
+		}
+		if len(conflicts) == 0 {
+			break
+		}
+		for _, f := range src.Funcs {
+			if name, ok := conflicts[f.Name]; ok {
+				f.Name = name
+			}
+			renameCalls(f.Stmts, conflicts)
+		}
+	}
+	renamed := make(map[string]string)
+	for _, f := range src.Funcs {
+		if f.Name != orig[f] {
+			renamed[orig[f]] = f.Name
+		}
+		if _, ok := dst.Function(f.Name); !ok {
+			dst.AddFunction(f)
+		}
+	}
+	return renamed
+}
+
+// renameCalls renames the callee of calls and spawns in stmts.
+func renameCalls(stmts []migo.Statement, names map[string]string) {
+	for _, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case *migo.CallStatement:
+			if name, ok := names[stmt.Name]; ok {
+				stmt.Name = name
+			}
+		case *migo.SpawnStatement:
+			if name, ok := names[stmt.Name]; ok {
+				stmt.Name = name
+			}
+		case *migo.IfStatement:
+			renameCalls(stmt.Then, names)
+			renameCalls(stmt.Else, names)
+		case *migo.IfForStatement:
+			renameCalls(stmt.Then, names)
+			renameCalls(stmt.Else, names)
+		case *migo.SelectStatement:
+			for _, c := range stmt.Cases {
+				renameCalls(c, names)
+			}
+		}
+	}
+}
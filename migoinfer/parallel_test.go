@@ -0,0 +1,36 @@
+package migoinfer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nickng/migo/parser"
+)
+
+// TestMergeProgram tests that a definition renamed more than once maps to its
+// final name.
+func TestMergeProgram(t *testing.T) {
+	dst, err := parser.Parse(strings.NewReader(`
+def main.f(a): send a;
+def main.f#e1(a): recv a;`))
+	if err != nil {
+		t.Fatalf("cannot parse: %v", err)
+	}
+	src, err := parser.Parse(strings.NewReader(`
+def main.g(a): call main.f(a);
+def main.f(a): close a;`))
+	if err != nil {
+		t.Fatalf("cannot parse: %v", err)
+	}
+	renamed := mergeProgram(dst, src, "#e1")
+	if want, got := "main.f#e1#e1", renamed["main.f"]; want != got {
+		t.Errorf("expects main.f renamed to %s but got %s", want, got)
+	}
+	g, ok := dst.Function("main.g")
+	if !ok {
+		t.Fatalf("expects main.g merged but got\n%s", dst)
+	}
+	if want, got := "call main.f#e1#e1(a)", g.Stmts[0].String(); want != got {
+		t.Errorf("expects %s but got %s", want, got)
+	}
+}
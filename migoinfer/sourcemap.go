@@ -0,0 +1,158 @@
+package migoinfer
+
+// Source map of MiGo.
+//
+// The source map links the MiGo definitions and statements to the Go source
+// they are inferred from. It is either written as trailing comments of the
+// MiGo output (see SetPositions), or as a JSON sidecar keyed by the definition
+// name and the statement index (see SourceMap).
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"io"
+	"path/filepath"
+
+	"github.com/nickng/gospal/migoinfer/internal/migoinfer"
+	"github.com/nickng/migo"
+)
+
+// Origin is the Go source (position and SSA instruction) of a MiGo statement
+// or definition.
+type Origin = migoinfer.Origin
+
+// SourceMap maps the name of MiGo definitions to their Go source.
+type SourceMap map[string]FuncSource
+
+// FuncSource is the Go source of a MiGo definition and its statements.
+type FuncSource struct {
+	Pos   *Position    `json:"pos,omitempty"` // Position of function or block.
+	Stmts []StmtSource `json:"stmts"`         // Sources of statements.
+}
+
+// StmtSource is the Go source of the statement at Index of a definition.
+type StmtSource struct {
+	Index int       `json:"index"`           // Index of statement.
+	Pos   *Position `json:"pos,omitempty"`   // Source position.
+	Instr string    `json:"instr,omitempty"` // SSA instruction.
+}
+
+// Position is a source position.
+type Position struct {
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+func newPosition(pos token.Position) *Position {
+	if !pos.IsValid() {
+		return nil
+	}
+	return &Position{File: pos.Filename, Line: pos.Line, Column: pos.Column}
+}
+
+// SetPositions sets the MiGo output to have the source positions of the
+// definitions and statements as trailing comments.
+func (i *Inferer) SetPositions() {
+	i.Positions = true
+}
+
+// SourceMap returns the source map of the inferred MiGo program.
+func (i *Inferer) SourceMap() SourceMap {
+	m := make(SourceMap)
+	for _, f := range i.MiGo.Funcs {
+		src := FuncSource{Stmts: []StmtSource{}}
+		if o, ok := i.Env.FuncOrigin(f); ok {
+			src.Pos = newPosition(o.Pos)
+		}
+		for idx, stmt := range f.Stmts {
+			if o, ok := i.Env.StmtOrigin(stmt); ok {
+				s := StmtSource{Index: idx, Pos: newPosition(o.Pos)}
+				if o.Instr != nil {
+					s.Instr = o.Instr.String()
+				}
+				src.Stmts = append(src.Stmts, s)
+			}
+		}
+		m[f.SimpleName()] = src
+	}
+	return m
+}
+
+// StmtPosition returns the source position of the MiGo statement s.
+func (i *Inferer) StmtPosition(s migo.Statement) (token.Position, bool) {
+	o, ok := i.Env.StmtOrigin(s)
+	return o.Pos, ok && o.Pos.IsValid()
+}
+
+// SpawnContext returns the call-context path of the go statement of the MiGo
+// spawn statement s, i.e. the functions called from the entry to the spawning
+// function (outermost first).
+func (i *Inferer) SpawnContext(s migo.Statement) []string {
+	o, _ := i.Env.StmtOrigin(s)
+	return o.Context
+}
+
+// LoopApprox returns true if the MiGo statement s is an if statement which
+// approximates a loop, i.e. a loop whose bound is unknown, so the problems
+// found through s may not be reachable in the program.
+func (i *Inferer) LoopApprox(s migo.Statement) bool {
+	return i.Env.LoopApprox(s)
+}
+
+// LoopBound returns the number of iterations of the loop of the MiGo ifFor
+// statement s, false if the loop does not have a constant bound.
+func (i *Inferer) LoopBound(s migo.Statement) (int64, bool) {
+	return i.Env.LoopBound(s)
+}
+
+// WriteSourceMap writes the source map of the inferred MiGo program to w as
+// JSON.
+func (i *Inferer) WriteSourceMap(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(i.SourceMap())
+}
+
+// writeFunc writes the MiGo definition f to w, with the source positions as
+// trailing comments if enabled.
+func (i *Inferer) writeFunc(w io.Writer, f *migo.Function) {
+	if !i.Positions {
+		fmt.Fprint(w, f.String())
+		return
+	}
+	fmt.Fprintf(w, "def %s(%s):", f.SimpleName(), migo.CalleeParameterString(f.Params))
+	if o, ok := i.Env.FuncOrigin(f); ok {
+		writePosComment(w, o.Pos)
+	}
+	fmt.Fprintln(w)
+	if len(f.Stmts) == 0 {
+		fmt.Fprintf(w, "    %s;\n", &migo.TauStatement{})
+	}
+	for _, stmt := range f.Stmts {
+		fmt.Fprintf(w, "    %s;", stmt)
+		if o, ok := i.Env.StmtOrigin(stmt); ok {
+			writePosComment(w, o.Pos)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// writePosComment writes pos as a comment, the file is written as its base
+// name so the output does not depend on the location of the source.
+func writePosComment(w io.Writer, pos token.Position) {
+	if pos.IsValid() {
+		fmt.Fprintf(w, " -- %s:%d:%d", filepath.Base(pos.Filename), pos.Line, pos.Column)
+	}
+}
+
+// writeProgram writes the non-empty MiGo definitions of prog to w, see
+// writeFunc.
+func (i *Inferer) writeProgram(w io.Writer, prog *migo.Program) {
+	for _, f := range prog.Funcs {
+		if !f.IsEmpty() {
+			i.writeFunc(w, f)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+// The analysis of broken fails (see TestAnalysisError), but the rest of the
+// program is still inferred.
+
+func fail() {}
+
+func broken(ch chan int) {
+	fail()
+	ch <- 1
+}
+
+func main() {
+	ch := make(chan int)
+	go func() { ch <- 2 }()
+	broken(ch)
+	<-ch
+}
@@ -0,0 +1,28 @@
+package main
+
+func a(ch chan int) {
+	ch <- 1
+	b(ch)
+}
+
+func b(ch chan int) {
+	ch <- 2
+	c(ch)
+}
+
+func c(ch chan int) {
+	ch <- 3
+}
+
+func pair(x, y chan int) {
+	x <- 1
+	<-y
+}
+
+func main() {
+	ch := make(chan int, 3)
+	a(ch)
+	x, y := make(chan int, 1), make(chan int, 1)
+	pair(x, y)
+	pair(x, x)
+}
@@ -0,0 +1,22 @@
+package main
+
+// Two reply channels of the same type sent over a channel, the server replies
+// on either of them.
+
+func server(reqs chan chan int) {
+	for {
+		reply := <-reqs
+		reply <- 42
+	}
+}
+
+func main() {
+	reqs := make(chan chan int)
+	go server(reqs)
+	a := make(chan int)
+	b := make(chan int)
+	reqs <- a
+	<-a
+	reqs <- b
+	<-b
+}
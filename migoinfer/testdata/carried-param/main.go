@@ -0,0 +1,20 @@
+package main
+
+// A reply channel sent over a channel through a parameter.
+
+func register(reqs chan chan int, reply chan int) {
+	reqs <- reply
+}
+
+func server(reqs chan chan int) {
+	reply := <-reqs
+	reply <- 42
+}
+
+func main() {
+	reqs := make(chan chan int)
+	go server(reqs)
+	reply := make(chan int)
+	register(reqs, reply)
+	<-reply
+}
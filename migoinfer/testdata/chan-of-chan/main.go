@@ -0,0 +1,18 @@
+package main
+
+func server(reqs chan chan int, quit chan bool) {
+	select {
+	case reply := <-reqs:
+		reply <- 42
+	case <-quit:
+	}
+}
+
+func main() {
+	reqs := make(chan chan int)
+	quit := make(chan bool)
+	go server(reqs, quit)
+	reply := make(chan int)
+	reqs <- reply
+	<-reply
+}
@@ -0,0 +1,28 @@
+package main
+
+func send(ch chan int) {
+	ch <- 1
+}
+
+func recv(ch chan int) {
+	<-ch
+}
+
+func apply(f func(chan int), ch chan int) {
+	f(ch)
+}
+
+func relay(in, out chan int) {
+	out <- <-in
+}
+
+func main() {
+	ch := make(chan int)
+	go apply(send, ch)
+	apply(recv, ch)
+
+	a, b := make(chan int, 1), make(chan int, 1)
+	go relay(a, b)
+	go relay(b, b)
+	a <- 1
+}
@@ -0,0 +1,11 @@
+package main
+
+func work(n int) {
+	ch := make(chan int, n)
+	ch <- 1
+	<-ch
+}
+
+func main() {
+	work(2)
+}
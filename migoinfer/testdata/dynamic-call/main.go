@@ -0,0 +1,22 @@
+package main
+
+type handler func(chan int)
+
+func send(ch chan int) {
+	ch <- 1
+}
+
+func recv(ch chan int) {
+	<-ch
+}
+
+func dispatch(h handler, ch chan int) {
+	h(ch)
+}
+
+func main() {
+	ch := make(chan int)
+	go dispatch(send, ch)
+	handlers := map[string]handler{"recv": recv}
+	handlers["recv"](ch)
+}
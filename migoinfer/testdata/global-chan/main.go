@@ -0,0 +1,24 @@
+package main
+
+var jobs = make(chan int)
+
+type server struct {
+	reqs chan int
+}
+
+var srv server
+
+func init() {
+	srv.reqs = make(chan int, 1)
+}
+
+func worker() {
+	j := <-jobs
+	srv.reqs <- j
+}
+
+func main() {
+	go worker()
+	jobs <- 1
+	<-srv.reqs
+}
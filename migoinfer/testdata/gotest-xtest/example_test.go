@@ -0,0 +1,24 @@
+package queue_test
+
+import (
+	"queue"
+	"testing"
+)
+
+func TestUnbuffered(t *testing.T) {
+	q := make(chan int)
+	go queue.Put(q, 1)
+	if queue.Get(q) != 1 {
+		t.Fatal("unexpected value")
+	}
+}
+
+func TestSubtests(t *testing.T) {
+	done := make(chan struct{})
+	t.Run("close", func(t *testing.T) {
+		close(done)
+	})
+	t.Run("wait", func(t *testing.T) {
+		<-done
+	})
+}
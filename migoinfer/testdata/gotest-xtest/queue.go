@@ -0,0 +1,11 @@
+package queue
+
+// Put puts v in the queue q.
+func Put(q chan int, v int) {
+	q <- v
+}
+
+// Get gets a value from the queue q.
+func Get(q chan int) int {
+	return <-q
+}
@@ -0,0 +1,11 @@
+package queue
+
+import "testing"
+
+func TestBuffered(t *testing.T) {
+	q := make(chan int, 1)
+	Put(q, 1)
+	if Get(q) != 1 {
+		t.Fatal("unexpected value")
+	}
+}
@@ -0,0 +1,42 @@
+package testing
+
+// This package is a stand-in for the standard testing package, which is not
+// loaded by the tests. Goroutines are closures since named functions of the
+// testing package are stubs.
+
+type T struct{}
+
+func (t *T) Run(name string, f func(t *T)) bool {
+	f(t)
+	return true
+}
+
+func (t *T) Fatal(args ...interface{}) {}
+
+func TestPingPong(t *T) {
+	ping, pong := make(chan int), make(chan int)
+	go func() {
+		v := <-ping
+		pong <- v
+	}()
+	ping <- 1
+	if <-pong != 1 {
+		t.Fatal("unexpected value")
+	}
+}
+
+func TestSubtests(t *T) {
+	done := make(chan struct{})
+	t.Run("close", func(t *T) {
+		go func() { close(done) }()
+	})
+	t.Run("wait", func(t *T) {
+		<-done
+	})
+}
+
+func TestNoComm(t *T) {
+	t.Fatal("no communication")
+}
+
+func helper(t *T) {}
@@ -0,0 +1,27 @@
+package main
+
+type sender interface {
+	send(ch chan int)
+}
+
+type one struct{}
+
+func (one) send(ch chan int) {
+	ch <- 1
+}
+
+type closer struct{}
+
+func (*closer) send(ch chan int) {
+	close(ch)
+}
+
+func run(s sender, ch chan int) {
+	s.send(ch)
+}
+
+func main() {
+	ch := make(chan int)
+	go run(one{}, ch)
+	<-ch
+}
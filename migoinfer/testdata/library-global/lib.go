@@ -0,0 +1,15 @@
+package lib
+
+// Package-level channels in library mode.
+
+var jobs = make(chan int)
+
+func worker() {
+	<-jobs
+}
+
+// Start starts a worker and sends it a job.
+func Start() {
+	go worker()
+	jobs <- 1
+}
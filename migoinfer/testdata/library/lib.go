@@ -0,0 +1,51 @@
+package lib
+
+// Pool is a pool of workers sharing a job queue.
+type Pool struct {
+	jobs chan int
+	done chan bool
+}
+
+// Pipe is a channel of values.
+type Pipe chan int
+
+// Submit sends a job to the pool and waits for it to complete.
+func (p *Pool) Submit(job int) {
+	p.jobs <- job
+	<-p.done
+}
+
+// Work receives a job from the pool and reports completion.
+func (p *Pool) Work() {
+	<-p.jobs
+	p.done <- true
+}
+
+// Put sends a value to the pipe.
+func (p Pipe) Put(v int) {
+	p <- v
+}
+
+// Forward forwards a value from in to out.
+func Forward(in <-chan int, out chan<- int) {
+	out <- <-in
+}
+
+// Relay forwards a value from in to out in a new goroutine.
+func Relay(in, out chan int) {
+	go Forward(in, out)
+}
+
+// Close closes ch.
+func Close(ch chan int) {
+	close(ch)
+}
+
+// Len has no communication.
+func Len(ch chan int) int {
+	return len(ch)
+}
+
+func unexported(ch chan int) {
+	ch <- 1
+}
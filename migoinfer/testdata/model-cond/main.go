@@ -0,0 +1,21 @@
+package main
+
+import "sync"
+
+// A goroutine waits on the condition variable which is signalled, and
+// broadcast.
+
+func main() {
+	var mu sync.Mutex
+	cond := sync.NewCond(&mu)
+	done := make(chan bool)
+	go func() {
+		mu.Lock()
+		cond.Wait()
+		mu.Unlock()
+		done <- true
+	}()
+	cond.Signal()
+	cond.Broadcast()
+	<-done
+}
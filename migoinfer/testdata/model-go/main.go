@@ -0,0 +1,21 @@
+package main
+
+import "sync"
+
+// Modelled functions run in their own goroutines.
+
+func main() {
+	var mu sync.Mutex
+	cond := sync.NewCond(&mu)
+	go cond.Signal()
+	mu.Lock()
+	cond.Wait()
+	mu.Unlock()
+
+	var once sync.Once
+	done := make(chan bool)
+	go once.Do(func() {
+		done <- true
+	})
+	<-done
+}
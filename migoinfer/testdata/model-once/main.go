@@ -0,0 +1,14 @@
+package main
+
+import "sync"
+
+// Once.Do calls the function.
+
+func main() {
+	var once sync.Once
+	ch := make(chan int, 1)
+	once.Do(func() {
+		ch <- 1
+	})
+	<-ch
+}
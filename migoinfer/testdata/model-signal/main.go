@@ -0,0 +1,15 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+)
+
+// Signals are delivered to the channel any number of times.
+
+func main() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	<-sigs
+	signal.Stop(sigs)
+}
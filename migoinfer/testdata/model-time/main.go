@@ -0,0 +1,18 @@
+package main
+
+import "time"
+
+// Timers and tickers deliver to their channels.
+
+func main() {
+	<-time.After(time.Second)
+	timer := time.NewTimer(time.Second)
+	<-timer.C
+	timer.Stop()
+	tick := time.Tick(time.Second)
+	<-tick
+	ticker := time.NewTicker(time.Second)
+	<-ticker.C
+	<-ticker.C
+	ticker.Stop()
+}
@@ -0,0 +1,15 @@
+package main
+
+// notify is replaced by a model which sends to ch.
+func notify(ch chan int) {}
+
+// do is replaced by a model which calls f.
+func do(f func()) {}
+
+func main() {
+	ch := make(chan int, 1)
+	notify(ch)
+	do(func() {
+		<-ch
+	})
+}
@@ -0,0 +1,28 @@
+package main
+
+type handle struct{}
+
+func generate() (<-chan int, func()) {
+	ch := make(chan int)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case ch <- 1:
+		case <-done:
+		}
+	}()
+	return ch, func() { close(done) }
+}
+
+func open() (chan int, *handle) {
+	ch := make(chan int, 1)
+	return ch, new(handle)
+}
+
+func main() {
+	ch, cancel := generate()
+	<-ch
+	cancel()
+	c, _ := open()
+	c <- 1
+}
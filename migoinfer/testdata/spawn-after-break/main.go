@@ -0,0 +1,17 @@
+package main
+
+// Goroutine spawned after a loop which breaks out of the loop body.
+
+func main() {
+	ch := make(chan int)
+	for i := 0; i < 3; i++ {
+		if i == 1 {
+			break
+		}
+		println(i)
+	}
+	go func() {
+		ch <- 1
+	}()
+	<-ch
+}
@@ -0,0 +1,28 @@
+package main
+
+// Fan-out/fan-in with a constant number of workers (replicated), and with an
+// unknown number of workers (approximated by recursion).
+
+var n = 10
+
+func worker(jobs chan int, done chan bool) {
+	<-jobs
+	done <- true
+}
+
+func main() {
+	jobs := make(chan int)
+	done := make(chan bool)
+	for i := 0; i < 3; i++ {
+		go worker(jobs, done)
+	}
+	for i := 0; i < 3; i++ {
+		jobs <- i
+	}
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+	for i := 0; i < n; i++ {
+		go worker(jobs, done)
+	}
+}
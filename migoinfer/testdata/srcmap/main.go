@@ -0,0 +1,12 @@
+package main
+
+func producer(ch chan int) {
+	ch <- 1
+	close(ch)
+}
+
+func main() {
+	ch := make(chan int)
+	go producer(ch)
+	<-ch
+}
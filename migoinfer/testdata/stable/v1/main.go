@@ -0,0 +1,18 @@
+package main
+
+func worker(jobs chan int, done chan bool) {
+	for i := 0; i < 3; i++ {
+		<-jobs
+	}
+	done <- true
+}
+
+func main() {
+	jobs := make(chan int, 1)
+	done := make(chan bool)
+	go worker(jobs, done)
+	for i := 0; i < 3; i++ {
+		jobs <- i
+	}
+	<-done
+}
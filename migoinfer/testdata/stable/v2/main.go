@@ -0,0 +1,21 @@
+package main
+
+func worker(jobs chan int, done chan bool) {
+	sum := 0
+	for i := 0; i < 3; i++ {
+		sum += <-jobs
+	}
+	_ = sum * 2
+	done <- true
+}
+
+func main() {
+	n := len("jobs")
+	jobs := make(chan int, 1)
+	done := make(chan bool)
+	go worker(jobs, done)
+	for i := 0; i < 3; i++ {
+		jobs <- i * n
+	}
+	<-done
+}
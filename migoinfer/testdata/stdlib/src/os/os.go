@@ -0,0 +1,14 @@
+// Package os is a stand-in for the standard os package.
+package os
+
+type Signal interface {
+	String() string
+	Signal()
+}
+
+type signal int
+
+func (s signal) String() string { return "interrupt" }
+func (s signal) Signal()        {}
+
+var Interrupt Signal = signal(2)
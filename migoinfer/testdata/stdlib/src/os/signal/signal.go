@@ -0,0 +1,9 @@
+// Package signal is a stand-in for the standard os/signal package, which is
+// replaced by its models in the analysis.
+package signal
+
+import "os"
+
+func Notify(c chan<- os.Signal, sig ...os.Signal) {}
+
+func Stop(c chan<- os.Signal) {}
@@ -0,0 +1,38 @@
+// Package sync is a stand-in for the standard sync package, which is
+// replaced by its models in the analysis.
+package sync
+
+type Locker interface {
+	Lock()
+	Unlock()
+}
+
+type Mutex struct {
+	state int32
+}
+
+func (m *Mutex) Lock()   {}
+func (m *Mutex) Unlock() {}
+
+type Once struct {
+	done bool
+}
+
+func (o *Once) Do(f func()) {
+	if !o.done {
+		o.done = true
+		f()
+	}
+}
+
+type Cond struct {
+	L Locker
+}
+
+func NewCond(l Locker) *Cond {
+	return &Cond{L: l}
+}
+
+func (c *Cond) Wait()      {}
+func (c *Cond) Signal()    {}
+func (c *Cond) Broadcast() {}
@@ -0,0 +1,21 @@
+// Package testing is a stand-in for the standard testing package, which is
+// replaced by its models in the analysis.
+package testing
+
+type T struct{}
+
+func (t *T) Run(name string, f func(t *T)) bool {
+	f(t)
+	return true
+}
+
+func (t *T) Fatal(args ...interface{}) {}
+
+type B struct {
+	N int
+}
+
+func (b *B) Run(name string, f func(b *B)) bool {
+	f(b)
+	return true
+}
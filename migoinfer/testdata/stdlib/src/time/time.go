@@ -0,0 +1,41 @@
+// Package time is a stand-in for the standard time package, which is
+// replaced by its models in the analysis.
+package time
+
+type Duration int64
+
+const Second Duration = 1000000000
+
+type Time struct{}
+
+type Timer struct {
+	C <-chan Time
+}
+
+func NewTimer(d Duration) *Timer {
+	c := make(chan Time, 1)
+	return &Timer{C: c}
+}
+
+func (t *Timer) Stop() bool { return true }
+
+func (t *Timer) Reset(d Duration) bool { return true }
+
+func After(d Duration) <-chan Time {
+	return NewTimer(d).C
+}
+
+type Ticker struct {
+	C <-chan Time
+}
+
+func NewTicker(d Duration) *Ticker {
+	c := make(chan Time, 1)
+	return &Ticker{C: c}
+}
+
+func (t *Ticker) Stop() {}
+
+func Tick(d Duration) <-chan Time {
+	return NewTicker(d).C
+}
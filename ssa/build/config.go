@@ -3,10 +3,14 @@ package build
 import (
 	"fmt"
 	"go/build"
+	"go/parser"
+	"go/token"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/nickng/gospal/ssa"
 	"golang.org/x/tools/go/loader"
@@ -25,6 +29,8 @@ type Configurer interface {
 	AddBadPkg(pkg, reason string) Configurer
 	WithBuildLog(l io.Writer, flags int) Configurer
 	WithPtaLog(l io.Writer, flags int) Configurer
+	WithTests() Configurer
+	WithGOROOT(root string) Configurer
 }
 
 // Config represents a build configuration.
@@ -36,7 +42,9 @@ type Config struct {
 	ptaLog    io.Writer // Pointer analysis log.
 	ptaLFlags int       // Pointer analysis log flags.
 
-	src srcReader // src points to the program source.
+	src    srcReader // src points to the program source.
+	tests  bool      // Load test files.
+	goroot string    // GOROOT to load the standard library from.
 }
 
 func newConfig(src srcReader) *Config {
@@ -65,6 +73,21 @@ func (c *Config) WithPtaLog(l io.Writer, flags int) Configurer {
 	return c
 }
 
+// WithTests loads test files of the packages, i.e. _test.go files in the same
+// directory as the source files. The files of the external test package (with
+// suffix _test) are loaded as a separate package.
+func (c *Config) WithTests() Configurer {
+	c.tests = true
+	return c
+}
+
+// WithGOROOT loads the standard library from root instead of the default
+// GOROOT, e.g. stand-ins of the standard library packages.
+func (c *Config) WithGOROOT(root string) Configurer {
+	c.goroot = root
+	return c
+}
+
 // AddBadPkg marks a package 'bad' to avoid loading.
 func (c *Config) AddBadPkg(pkg, reason string) Configurer {
 	//c := b.(*Config)
@@ -74,10 +97,33 @@ func (c *Config) AddBadPkg(pkg, reason string) Configurer {
 
 func (c *Config) Build() (*ssa.Info, error) {
 	var lconf = loader.Config{Build: &build.Default}
+	if c.goroot != "" {
+		ctxt := build.Default
+		ctxt.GOROOT = c.goroot
+		lconf.Build = &ctxt
+	}
 	bldLog := log.New(c.bldLog, "ssabuild: ", c.bldLFlags)
 
 	switch src := c.src.(type) {
 	case *FileSrc:
+		if c.tests {
+			// The packages are importable, so that their external test
+			// packages can import them.
+			pkgs, err := testPkgs(src.Files)
+			if err != nil {
+				return nil, err
+			}
+			lconf.FindPackage = func(ctxt *build.Context, path, dir string, mode build.ImportMode) (*build.Package, error) {
+				if bp, ok := pkgs[path]; ok {
+					return bp, nil
+				}
+				return ctxt.Import(path, dir, mode)
+			}
+			for path := range pkgs {
+				lconf.ImportWithTests(path)
+			}
+			break
+		}
 		args, err := lconf.FromArgs(src.Files, false /* No tests */)
 		if err != nil {
 			return nil, err
@@ -135,3 +181,72 @@ func (c *Config) Default() Configurer {
 		AddBadPkg("internal/singleflight", "Singleflight uses unsupported []chan").
 		AddBadPkg("fmt", "Fmt is known to cause unwanted recursive loops")
 }
+
+// testPkgs returns the packages of files and the _test.go files in the same
+// directories by import path, which is the package name. The _test.go files of
+// the same package are the in-package tests, and those of the package with
+// suffix _test are the external test package.
+func testPkgs(files []string) (map[string]*build.Package, error) {
+	dirs := make(map[string][]string) // Directory → files.
+	for _, file := range files {
+		dir := filepath.Dir(file)
+		dirs[dir] = append(dirs[dir], filepath.Base(file))
+	}
+	pkgs := make(map[string]*build.Package)
+	for dir, names := range dirs {
+		tests, err := filepath.Glob(filepath.Join(dir, "*_test.go"))
+		if err != nil {
+			return nil, err
+		}
+		for _, test := range tests {
+			names = append(names, filepath.Base(test))
+		}
+		bp := &build.Package{Dir: dir}
+		pkgOf := make(map[string]string) // File → package name.
+		for _, name := range names {
+			if _, seen := pkgOf[name]; seen {
+				continue
+			}
+			pkg, err := pkgName(filepath.Join(dir, name))
+			if err != nil {
+				return nil, err
+			}
+			pkgOf[name] = pkg
+			if bp.Name == "" || !strings.HasSuffix(name, "_test.go") {
+				bp.Name = strings.TrimSuffix(pkg, "_test")
+			}
+		}
+		for _, name := range names {
+			pkg, ok := pkgOf[name]
+			if !ok {
+				continue // Already added.
+			}
+			delete(pkgOf, name)
+			switch {
+			case pkg == bp.Name+"_test" && strings.HasSuffix(name, "_test.go"):
+				bp.XTestGoFiles = append(bp.XTestGoFiles, name)
+			case pkg != bp.Name:
+				return nil, fmt.Errorf("found packages %s and %s in %s", bp.Name, pkg, dir)
+			case strings.HasSuffix(name, "_test.go"):
+				bp.TestGoFiles = append(bp.TestGoFiles, name)
+			default:
+				bp.GoFiles = append(bp.GoFiles, name)
+			}
+		}
+		bp.ImportPath = bp.Name
+		if other, ok := pkgs[bp.ImportPath]; ok {
+			return nil, fmt.Errorf("found package %s in %s and %s", bp.Name, other.Dir, dir)
+		}
+		pkgs[bp.ImportPath] = bp
+	}
+	return pkgs, nil
+}
+
+// pkgName returns the package name of the Go source file.
+func pkgName(file string) (string, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), file, nil, parser.PackageClauseOnly)
+	if err != nil {
+		return "", err
+	}
+	return f.Name.Name, nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"go/token"
 	"io"
+	"sort"
 
 	"github.com/pkg/errors"
 
@@ -97,6 +98,39 @@ func (g *CallGraph) UsedFunctions() ([]*ssa.Function, error) {
 	return g.usedFns, nil
 }
 
+// Callees returns the functions that may be called at site, ordered by their
+// names.
+func (g *CallGraph) Callees(site ssa.CallInstruction) []*ssa.Function {
+	return g.callees(site.Parent(), site)
+}
+
+// CalleesOf returns the functions that may be called by fn at any call site,
+// ordered by their names.
+func (g *CallGraph) CalleesOf(fn *ssa.Function) []*ssa.Function {
+	return g.callees(fn, nil)
+}
+
+// callees returns the functions called by fn at site, or at any site if site
+// is nil.
+func (g *CallGraph) callees(fn *ssa.Function, site ssa.CallInstruction) []*ssa.Function {
+	node, ok := g.cg.Nodes[fn]
+	if !ok {
+		return nil
+	}
+	seen := make(map[*ssa.Function]bool)
+	var callees []*ssa.Function
+	for _, edge := range node.Out {
+		if (site == nil || edge.Site == site) && !seen[edge.Callee.Func] {
+			seen[edge.Callee.Func] = true
+			callees = append(callees, edge.Callee.Func)
+		}
+	}
+	sort.Slice(callees, func(i, j int) bool {
+		return callees[i].String() < callees[j].String()
+	})
+	return callees
+}
+
 // populateEdges populates a slice of edges in the CallGraph.
 func (g *CallGraph) populateEdges(edge *callgraph.Edge) error {
 	e := &cgEdge{
@@ -203,6 +237,9 @@ func (info *Info) BuildCallGraph(algo string, tests bool) (*CallGraph, error) {
 		}
 		rtares := rta.Analyze(roots, true)
 		cg = rtares.CallGraph
+
+	default:
+		return nil, errors.Wrap(ErrUnknownCallGraph, algo)
 	}
 
 	cg.DeleteSyntheticNodes()
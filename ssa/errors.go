@@ -5,4 +5,7 @@ import "errors"
 var (
 	ErrNoTestMainPkgs = errors.New("no main packages in tests")
 	ErrNoMainPkgs     = errors.New("no main packages")
+	ErrNoLibPkgs      = errors.New("no library packages")
+
+	ErrUnknownCallGraph = errors.New("unknown call graph algorithm")
 )
@@ -201,3 +201,97 @@ func ExampleCallGraph_WriteGraphviz() {
 	//   "<root>" -> "main.main"
 	// }
 }
+
+// This tests finding exported functions of library packages.
+func TestExportedFuncs(t *testing.T) {
+	s := `package lib
+	type T struct{ ch chan int }
+	type t struct{}
+	type I interface{ M() }
+	func (T) A()      {}
+	func (*T) B()     {}
+	func (*T) b()     {}
+	func (t) C()      {}
+	func F(chan int)  {}
+	func f()          {}`
+
+	info, err := build.FromReader(strings.NewReader(s)).Build()
+	if err != nil {
+		t.Fatalf("SSA build failed: %v", err)
+	}
+	libs, err := info.LibPkgs()
+	if err != nil {
+		t.Fatalf("cannot find library packages: %v", err)
+	}
+	if len(libs) != 1 {
+		t.Fatalf("expects 1 library package but got %d", len(libs))
+	}
+	var names []string
+	for _, fn := range ssa.ExportedFuncs(libs[0]) {
+		names = append(names, fn.String())
+	}
+	if expect, got := "(*lib.T).B (lib.T).A lib.F", strings.Join(names, " "); expect != got {
+		t.Errorf("Exported functions mismatch, want:\n%s\ngot:\n%s", expect, got)
+	}
+}
+
+// This tests finding test functions.
+// The package under test is a stand-in for the standard testing package.
+func TestTestFuncs(t *testing.T) {
+	s := `package testing
+	type T struct{}
+	type B struct{}
+	func Test(t *T)            {}
+	func TestA(t *T)           {}
+	func Test_b(t *T)          {}
+	func Testc(t *T)           {}
+	func TestD(b *B)           {}
+	func TestE(t *T, x int)    {}
+	func TestF(t *T) int       { return 0 }`
+
+	info, err := build.FromReader(strings.NewReader(s)).Build()
+	if err != nil {
+		t.Fatalf("SSA build failed: %v", err)
+	}
+	pkgs := info.InitialPkgs()
+	if len(pkgs) != 1 {
+		t.Fatalf("expects 1 package but got %d", len(pkgs))
+	}
+	var names []string
+	for _, fn := range ssa.TestFuncs(pkgs[0]) {
+		names = append(names, fn.Name())
+	}
+	if expect, got := "Test TestA Test_b", strings.Join(names, " "); expect != got {
+		t.Errorf("Test functions mismatch, want:\n%s\ngot:\n%s", expect, got)
+	}
+}
+
+// This tests finding subtest functions, including nested subtests.
+func TestSubtestFuncs(t *testing.T) {
+	s := `package testing
+	type T struct{}
+	func (t *T) Run(name string, f func(t *T)) bool { f(t); return true }
+	func sub(t *T) {}
+	func TestA(t *T) {
+		t.Run("a", func(t *T) {
+			t.Run("b", sub)
+		})
+		t.Run("c", func(t *T) {})
+	}`
+
+	info, err := build.FromReader(strings.NewReader(s)).Build()
+	if err != nil {
+		t.Fatalf("SSA build failed: %v", err)
+	}
+	pkgs := info.InitialPkgs()
+	if len(pkgs) != 1 {
+		t.Fatalf("expects 1 package but got %d", len(pkgs))
+	}
+	var names []string
+	for _, fn := range ssa.SubtestFuncs(pkgs[0].Func("TestA")) {
+		names = append(names, fn.Name())
+	}
+	if expect, got := "TestA$1 TestA$2 sub", strings.Join(names, " "); expect != got {
+		t.Errorf("Subtest functions mismatch, want:\n%s\ngot:\n%s", expect, got)
+	}
+}
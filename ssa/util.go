@@ -1,6 +1,13 @@
 package ssa
 
 import (
+	"go/ast"
+	"go/types"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
 	"golang.org/x/tools/go/ssa"
 	"golang.org/x/tools/go/ssa/ssautil"
 )
@@ -28,3 +35,156 @@ func MainPkgs(prog *ssa.Program, tests bool) ([]*ssa.Package, error) {
 	}
 	return mains, nil
 }
+
+// InitialPkgs returns the packages built from the initial files or import
+// paths of the program, i.e. excluding their dependencies, ordered by path.
+func (info *Info) InitialPkgs() []*ssa.Package {
+	var pkgs []*ssa.Package
+	if info.LProg != nil {
+		for _, pkgInfo := range info.LProg.InitialPackages() {
+			if pkg := info.Prog.Package(pkgInfo.Pkg); pkg != nil {
+				pkgs = append(pkgs, pkg)
+			}
+		}
+	}
+	sort.Slice(pkgs, func(i, j int) bool {
+		return pkgs[i].Pkg.Path() < pkgs[j].Pkg.Path()
+	})
+	return pkgs
+}
+
+// LibPkgs returns the non-main packages of InitialPkgs.
+func (info *Info) LibPkgs() ([]*ssa.Package, error) {
+	var libs []*ssa.Package
+	for _, pkg := range info.InitialPkgs() {
+		if pkg.Pkg.Name() != "main" {
+			libs = append(libs, pkg)
+		}
+	}
+	if len(libs) == 0 {
+		return nil, ErrNoLibPkgs
+	}
+	return libs, nil
+}
+
+// ExportedFuncs returns the exported functions of pkg and the exported
+// methods of its exported types, ordered by their names.
+func ExportedFuncs(pkg *ssa.Package) []*ssa.Function {
+	var fns []*ssa.Function
+	for name, member := range pkg.Members {
+		if !ast.IsExported(name) {
+			continue
+		}
+		switch member := member.(type) {
+		case *ssa.Function:
+			fns = append(fns, member)
+		case *ssa.Type:
+			if types.IsInterface(member.Type()) {
+				continue
+			}
+			// Methods with value receivers are in both method sets, but only
+			// the value method set has the non-synthetic method.
+			for _, t := range []types.Type{member.Type(), types.NewPointer(member.Type())} {
+				mset := pkg.Prog.MethodSets.MethodSet(t)
+				for i := 0; i < mset.Len(); i++ {
+					if sel := mset.At(i); sel.Obj().Exported() {
+						if fn := pkg.Prog.MethodValue(sel); fn != nil && fn.Synthetic == "" {
+							fns = append(fns, fn)
+						}
+					}
+				}
+			}
+		}
+	}
+	sort.Slice(fns, func(i, j int) bool {
+		return fns[i].String() < fns[j].String()
+	})
+	return fns
+}
+
+// TestFuncs returns the test functions of pkg, i.e. functions named TestXxx
+// with a single *testing.T parameter, ordered by their names.
+func TestFuncs(pkg *ssa.Package) []*ssa.Function {
+	var tests []*ssa.Function
+	for name, member := range pkg.Members {
+		if fn, ok := member.(*ssa.Function); ok && isTestName(name) && isTestSig(fn.Signature) {
+			tests = append(tests, fn)
+		}
+	}
+	sort.Slice(tests, func(i, j int) bool {
+		return tests[i].String() < tests[j].String()
+	})
+	return tests
+}
+
+// SubtestFuncs returns the subtest functions of test function fn, i.e. the
+// functions passed to (*testing.T).Run in fn, followed by those in its
+// anonymous functions.
+func SubtestFuncs(fn *ssa.Function) []*ssa.Function {
+	var subtests []*ssa.Function
+	for _, blk := range fn.Blocks {
+		for _, instr := range blk.Instrs {
+			call, ok := instr.(ssa.CallInstruction)
+			if !ok || !isSubtestRun(call.Common().StaticCallee()) {
+				continue
+			}
+			switch f := call.Common().Args[len(call.Common().Args)-1].(type) {
+			case *ssa.Function:
+				subtests = append(subtests, f)
+			case *ssa.MakeClosure:
+				subtests = append(subtests, f.Fn.(*ssa.Function))
+			}
+		}
+	}
+	for _, anon := range fn.AnonFuncs {
+		subtests = append(subtests, SubtestFuncs(anon)...)
+	}
+	return subtests
+}
+
+// isSubtestRun returns true if fn is (*testing.T).Run.
+func isSubtestRun(fn *ssa.Function) bool {
+	if fn == nil || fn.Name() != "Run" || fn.Signature.Recv() == nil {
+		return false
+	}
+	ptr, ok := fn.Signature.Recv().Type().(*types.Pointer)
+	if !ok {
+		return false
+	}
+	named, ok := ptr.Elem().(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := named.Obj()
+	return obj.Name() == "T" && obj.Pkg() != nil && obj.Pkg().Path() == "testing"
+}
+
+// isTestName returns true if name is Test or TestXxx where Xxx does not start
+// with a lower case letter.
+func isTestName(name string) bool {
+	if !strings.HasPrefix(name, "Test") {
+		return false
+	}
+	if len(name) == len("Test") {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(name[len("Test"):])
+	return !unicode.IsLower(r)
+}
+
+// isTestSig returns true if sig is func(*testing.T).
+func isTestSig(sig *types.Signature) bool {
+	if sig.Recv() != nil || sig.Params().Len() != 1 || sig.Results().Len() != 0 {
+		return false
+	}
+	ptr, ok := sig.Params().At(0).Type().(*types.Pointer)
+	if !ok {
+		return false
+	}
+	named, ok := ptr.Elem().(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := named.Obj()
+	return obj.Name() == "T" && obj.Pkg() != nil && obj.Pkg().Path() == "testing"
+}
@@ -150,14 +150,10 @@ func (c Const) UniqName() string {
 	return c.String()
 }
 
-var constants = make(map[*ssa.Const]Const)
-
 // getConst returns a constant where same values gets the same Const.
+//
+// Const is a copy of the constant, so the same constant always gets equal
+// Consts without a (process-global) cache.
 func getConst(c *ssa.Const) Const {
-	if con, ok := constants[c]; ok {
-		return con
-	}
-	con := Const{Const: *c}
-	constants[c] = con
-	return con
+	return Const{Const: *c}
 }